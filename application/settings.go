@@ -1,33 +1,232 @@
 package application
 
 import (
-	"os"
 	"encoding/json"
-	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
 	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
 	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"os"
+)
+
+const (
+	// ListenerModeTLS terminates TLS on the listener using the configured certificate and key; this is the default
+	ListenerModeTLS = "tls"
+	// ListenerModeTCP passes plain TCP through the listener without any TLS termination
+	ListenerModeTCP = "tcp"
+
+	// ClientAuthNone does not request a certificate from clients; this is the default
+	ClientAuthNone = "none"
+	// ClientAuthVerifyIfGiven verifies the client certificate against the client CAs if one is presented
+	ClientAuthVerifyIfGiven = "verify-if-given"
+	// ClientAuthRequire requires a client certificate which is verified against the client CAs
+	ClientAuthRequire = "require"
+
+	// DefaultPoolName is the name given to the single pool configured using the top-level Pool and ECS settings
+	DefaultPoolName = "default"
+	// TransportUnix listens on a Unix domain socket at the SocketPath of the listener
+	TransportUnix = "unix"
+	// TransportSystemd uses a socket passed to the process by systemd socket activation
+	TransportSystemd = "systemd"
+
+	// DefaultListenerName is the name given to the single listener configured using the top-level Listener settings
+	DefaultListenerName = "default"
 )
 
 type (
 	// ListenerSettings represents the command-line settings that can additionally be passed
 	ListenerSettings struct {
+		Name      string
+		Pool      string
 		Host      string
 		Port      string
 		Transport string
+		Mode      string
 		CertFile  string
 		KeyFile   string
+
+		// CertReloadIntervalSec is how often certificate files are checked for changes; if negative, only on SIGHUP
+		CertReloadIntervalSec int
+
+		// TLSPolicyFile, if set, is a JSON file read in place of TLSPolicy
+		TLSPolicy     TLSPolicySettings
+		TLSPolicyFile string
+
+		// SocketPath and SocketMode apply to TransportUnix, and SystemdName to TransportSystemd
+		SocketPath  string
+		SocketMode  string
+		SystemdName string
+
+		// ClientAuth is one of the ClientAuth constants
+		ClientAuth    string
+		ClientCAFile  string
+		ClientCRLFile string
+
+		// Protocols lists the ALPN protocols advertised, in order of preference
+		Protocols []ProtocolSettings
+
+		// DrainTimeoutSec is the time given to active sessions on shutdown before they are closed
+		DrainTimeoutSec int
+
+		// ProxyProtocolTrustedCIDRs lists the load balancers from which a PROXY protocol header is accepted
+		ProxyProtocolTrustedCIDRs []string
+		ProxyProtocolTimeoutSec   int
+
+		// HandshakeTimeoutSec, IdleTimeoutSec and MaxSessionDurationSec are each disabled if zero
+		HandshakeTimeoutSec   int
+		IdleTimeoutSec        int
+		MaxSessionDurationSec int
+
+		Bandwidth     BandwidthSettings
+		ClientLimits  ClientLimitSettings
+		AccessControl AccessControlSettings
+
+		Authentication AuthenticationSettings
+	}
+
+	// TLSPolicySettings represents the TLS versions, cipher suites, curves and session tickets of a listener
+	TLSPolicySettings struct {
+		MinVersion             string
+		MaxVersion             string
+		CipherSuites           []string
+		Curves                 []string
+		OCSPStapleFile         string
+		SessionTicketsDisabled bool
+		SessionTicketKeyFile   string
+	}
+
+	// AccessControlSettings represents the networks which may connect to the listener
+	AccessControlSettings struct {
+		Allow             []string
+		AllowFile         string
+		Deny              []string
+		DenyFile          string
+		ReloadIntervalSec int
+	}
+
+	// AuthenticationSettings represents the token that clients must send in order to be assigned a container
+	AuthenticationSettings struct {
+		Framing     string
+		TokenFile   string
+		HMACKeyFile string
+		TimeoutSec  int
+	}
+
+	// ClientLimitSettings represents the limits applied to the connections of each client IP address
+	ClientLimitSettings struct {
+		ConnectionsPerSec int64
+		ConnectionBurst   int64
+		MaxSessions       int
+	}
+
+	// BandwidthSettings represents the limits on the data copied between clients and containers
+	BandwidthSettings struct {
+		ClientToContainerBytesPerSec int64
+		ContainerToClientBytesPerSec int64
+		SessionBytesPerSec           int64
+		ListenerBytesPerSec          int64
+		BurstBytes                   int64
+		MaxSessionBytes              int64
+	}
+
+	// ProtocolSettings represents an ALPN protocol and the pool, and optionally container port, which serves it
+	ProtocolSettings struct {
+		Name        string
+		Pool        string
+		BackendPort int
+	}
+
+	// PoolSettings represents a named container pool and the SNI server names and certificate which select it
+	PoolSettings struct {
+		Name           string
+		ServerNames    []string
+		CertFile       string
+		KeyFile        string
+		OCSPStapleFile string
+		Manager        string
+		ECS            cntrmgr.Settings
+		Pool           cntrpool.Settings
+	}
+
+	// StatisticsSettings represents the HTTP server providing the statistics endpoint and admin API
+	StatisticsSettings struct {
+		Address           string
+		CertFile          string
+		KeyFile           string
+		ClientCAFile      string
+		ReadOnlyTokenFile string
+		AdminTokenFile    string
+		ReadOnlyClients   []string
+		AdminClients      []string
 	}
 
 	// Settings represents the various different parameters that can be configured using an appropriate configuration
 	// file
 	Settings struct {
-		Listener ListenerSettings
-		Pool     cntrpool.Settings
-		Monitor  monitor.Settings
-		ECS      cntrmgr.Settings
+		Listener    ListenerSettings
+		Listeners   []ListenerSettings
+		Pool        cntrpool.Settings
+		Monitor     monitor.Settings
+		ECS         cntrmgr.Settings
+		Pools       []PoolSettings
+		DefaultPool string
+		Statistics  StatisticsSettings
 	}
 )
 
+// PoolSettings returns the settings of every configured pool; if no named pools have been configured then a single
+// pool named DefaultPoolName is returned using the top-level Pool and ECS settings
+func (s Settings) PoolSettings() []PoolSettings {
+	if len(s.Pools) > 0 {
+		return s.Pools
+	}
+
+	return []PoolSettings{{
+		Name: DefaultPoolName,
+		ECS:  s.ECS,
+		Pool: s.Pool,
+	}}
+}
+
+// ListenerSettings returns the settings of every configured listener; if no named listeners have been configured then
+// the top-level Listener settings are returned, named DefaultListenerName if they have no name
+func (s Settings) ListenerSettings() []ListenerSettings {
+	if len(s.Listeners) > 0 {
+		return s.Listeners
+	}
+
+	listener := s.Listener
+	if listener.Name == "" {
+		listener.Name = DefaultListenerName
+	}
+
+	return []ListenerSettings{listener}
+}
+
+// DefaultPoolName returns the name of the pool used when no other pool has been selected: DefaultPool if set,
+// otherwise the first configured pool
+func (s Settings) DefaultPoolName() string {
+	if s.DefaultPool != "" {
+		return s.DefaultPool
+	}
+
+	return s.PoolSettings()[0].Name
+}
+
+// LoadTLSPolicy loads the TLS policy file from the pathname provided, returning the policy or the error that occurred
+func LoadTLSPolicy(file string) (*TLSPolicySettings, error) {
+	policyFile, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer policyFile.Close()
+
+	var policy TLSPolicySettings
+	if err := json.NewDecoder(policyFile).Decode(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
 // LoadSettings loads the settings file from the pathname provided. It returns the pointer of a populated Settings
 // struct if this file is valid; a nil pointer and the error that occurred if this is not the case
 func LoadSettings(file string) (settings *Settings, err error) {
@@ -22,7 +22,9 @@ set +e
 echo "Deleting from k8s..."
 kubectl delete -f _k8s/tcp-pool-proxy/service.yaml --namespace ${NAMESPACE} 2>/dev/null
 kubectl delete -f _k8s/tcp-pool-proxy/deployment.yaml --namespace ${NAMESPACE} 2>/dev/null
+kubectl delete configmap tcp-pool-proxy-conf --namespace ${NAMESPACE} 2>/dev/null
 
 echo "Creating in k8s..."
+kubectl create configmap tcp-pool-proxy-conf --namespace ${NAMESPACE} --from-file=_k8s/tcp-pool-proxy/tcp-proxy-pool.json
 kubectl create -f _k8s/tcp-pool-proxy/deployment.yaml --namespace ${NAMESPACE}
 kubectl create -f _k8s/tcp-pool-proxy/service.yaml --namespace ${NAMESPACE}
\ No newline at end of file
@@ -0,0 +1,103 @@
+package cidr
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+const (
+	errorInvalidCIDR = "invalid CIDR or IP address: "
+)
+
+type (
+	// List is a list of IPv4 and IPv6 networks
+	List []*net.IPNet
+)
+
+// Parse creates a list from the CIDR blocks provided, such as 10.0.0.0/8 or 2001:db8::/32. Individual IP addresses
+// are also accepted and are treated as a network containing only that address. Blank entries are ignored.
+func Parse(entries []string) (List, error) {
+	var list List
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New(errorInvalidCIDR + entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New(errorInvalidCIDR + entry)
+		}
+		list = append(list, network)
+	}
+
+	return list, nil
+}
+
+// ReadFile creates a list from the file provided, which holds one CIDR block or IP address per line. Blank lines
+// and anything following a # are ignored.
+func ReadFile(file string) (List, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			lines[i] = line[:comment]
+		}
+	}
+
+	return Parse(lines)
+}
+
+// Contains reports whether the IP address provided is within any of the networks in the list
+func (l List) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ContainsAddr reports whether the IP address of the network address provided is within any of the networks in the
+// list. Addresses without an IP address, such as those of UNIX sockets, are never contained.
+func (l List) ContainsAddr(addr net.Addr) bool {
+	return l.Contains(IP(addr))
+}
+
+// IP returns the IP address of the network address provided, or nil if it does not have one
+func IP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package cidr
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func Test_Parse(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		l, err := Parse([]string{"10.0.0.0/8", " 192.0.2.1 ", "", "2001:db8::/32", "::1"})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(l))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := Parse([]string{"10.0.0.0/33"})
+		assert.NotNil(t, err)
+
+		_, err = Parse([]string{"not-an-address"})
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_Contains(t *testing.T) {
+	l, err := Parse([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	assert.Nil(t, err)
+
+	assert.True(t, l.Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, l.Contains(net.ParseIP("192.0.2.1")))
+	assert.False(t, l.Contains(net.ParseIP("192.0.2.2")))
+	assert.True(t, l.Contains(net.ParseIP("2001:db8::5")))
+	assert.False(t, l.Contains(net.ParseIP("2001:db9::5")))
+	assert.False(t, l.Contains(nil))
+
+	// IPv4-mapped IPv6 addresses are treated as IPv4
+	assert.True(t, l.Contains(net.ParseIP("::ffff:10.0.0.1")))
+
+	assert.True(t, l.ContainsAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}))
+	assert.False(t, l.ContainsAddr(&net.UnixAddr{Name: "/tmp/socket"}))
+	assert.False(t, List(nil).Contains(net.ParseIP("10.0.0.1")))
+}
+
+func Test_ReadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "cidr")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString("# office networks\n10.0.0.0/8\n\n192.0.2.1 # gateway\r\n2001:db8::/32\n")
+	f.Close()
+
+	l, err := ReadFile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(l))
+	assert.True(t, l.Contains(net.ParseIP("192.0.2.1")))
+
+	_, err = ReadFile(f.Name() + ".missing")
+	assert.NotNil(t, err)
+}
@@ -1,29 +1,61 @@
 package cntr
 
 import (
-	"time"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
 type (
-	// Container contains the details of a running container within the pool
+	// Container contains the details of a running container within the pool. Only the details of the container
+	// itself are encoded as JSON, as its connections cannot be.
 	Container struct {
+		// bytesTransferred is the total copied in both directions during the current session; it is accessed
+		// atomically so is kept first to guarantee its alignment
+		bytesTransferred int64
+
 		// ExternalID is the ID of the running container and must be unique within the pool
-		ExternalID            string
+		ExternalID string
 
 		// StartTime holds the time that the container was initially started
-		StartTime             time.Time
+		StartTime time.Time
 
 		// IPAddress holds the IP address on which the container is running
-		IPAddress             string
+		IPAddress string
 
 		// Port holds the port on which the container is running
-		Port                  int
+		Port int
 
 		// ConnectionFromClient represents the client connection; if this is nil then this container is available
-		ConnectionFromClient  net.Conn
+		ConnectionFromClient net.Conn `json:"-"`
 
 		// ConnectionToContainer represents the container connection; this should not be set to nil once set
-		ConnectionToContainer net.Conn
+		ConnectionToContainer net.Conn `json:"-"`
+
+		// SessionStart holds the time that the current client was associated with the container, and is zero if
+		// the container is available
+		SessionStart time.Time `json:"-"`
 	}
 )
+
+// AddBytesTransferred adds n to the bytes transferred during the current session; it does nothing if the container
+// is nil
+func (c *Container) AddBytesTransferred(n int64) {
+	if c == nil {
+		return
+	}
+
+	atomic.AddInt64(&c.bytesTransferred, n)
+}
+
+// BytesTransferred returns the total bytes transferred in both directions during the current session
+func (c *Container) BytesTransferred() int64 {
+	return atomic.LoadInt64(&c.bytesTransferred)
+}
+
+// ResetSession records that a new session started at the time provided, clearing the bytes transferred; a zero
+// time indicates that the container is available
+func (c *Container) ResetSession(start time.Time) {
+	c.SessionStart = start
+	atomic.StoreInt64(&c.bytesTransferred, 0)
+}
@@ -0,0 +1,82 @@
+package cntr
+
+import (
+	"crypto/x509"
+	"net"
+)
+
+type (
+	// ClientIdentity contains the details of the verified certificate presented by a client when mutual TLS is used
+	ClientIdentity struct {
+		// Subject holds the distinguished name of the certificate subject
+		Subject string
+
+		// CommonName holds the common name of the certificate subject
+		CommonName string
+
+		// DNSNames, EmailAddresses, IPAddresses and URIs hold the subject alternative names of the certificate
+		DNSNames       []string
+		EmailAddresses []string
+		IPAddresses    []string
+		URIs           []string
+	}
+
+	// identifiedConn is implemented by client connections which may carry a verified client identity
+	identifiedConn interface {
+		ClientIdentity() *ClientIdentity
+	}
+)
+
+// IdentityFromCertificate creates a ClientIdentity from the certificate provided
+func IdentityFromCertificate(cert *x509.Certificate) *ClientIdentity {
+	identity := &ClientIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	for _, ip := range cert.IPAddresses {
+		identity.IPAddresses = append(identity.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+
+	return identity
+}
+
+// IdentityOf returns the verified identity of the client connection provided, or nil if it does not have one
+func IdentityOf(conn net.Conn) *ClientIdentity {
+	if ic, ok := conn.(identifiedConn); ok {
+		return ic.ClientIdentity()
+	}
+
+	return nil
+}
+
+// SubjectAlternativeNames returns all of the subject alternative names of the identity
+func (id *ClientIdentity) SubjectAlternativeNames() (names []string) {
+	names = append(names, id.DNSNames...)
+	names = append(names, id.EmailAddresses...)
+	names = append(names, id.IPAddresses...)
+	names = append(names, id.URIs...)
+
+	return names
+}
+
+// Matches returns true if the common name or any of the subject alternative names of the identity is contained in
+// the names provided
+func (id *ClientIdentity) Matches(names []string) bool {
+	for _, name := range names {
+		if name == id.CommonName {
+			return true
+		}
+		for _, san := range id.SubjectAlternativeNames() {
+			if name == san {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -1,15 +1,18 @@
 package cntrmgr
 
 import (
-	"github.com/aws/aws-sdk-go/service/ecs"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"context"
+	"errors"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"time"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
 	"github.com/nextmetaphor/tcp-proxy-pool/log"
 	"github.com/sirupsen/logrus"
-	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"sync"
+	"time"
 )
 
 const (
@@ -20,6 +23,17 @@ const (
 	logWaitingForTaskNetworkInterfaceToAttach = "Waiting for task [%s] network interface to attach, timing out in [%d] second(s)"
 	logDescribeTaskOutput                     = "DescribeTask output"
 	logTaskNetworkInterfaceStatus             = "Task [%s] network interface in state [%s]"
+
+	// healthCheckTimeout is the time allowed for ECS to describe the cluster when checking that it is reachable
+	healthCheckTimeout = 5 * time.Second
+	// healthCheckInterval is the time for which the result of checking the cluster is used before it is checked again
+	healthCheckInterval = 30 * time.Second
+	// clusterStatusActive is the status of a cluster in which tasks can be run
+	clusterStatusActive = "ACTIVE"
+
+	errorClusterNotFound   = "ECS cluster not found: "
+	errorClusterNotActive  = "ECS cluster is not active: "
+	errorClusterNotChecked = "ECS cluster has not yet been checked: "
 )
 
 type (
@@ -40,15 +54,22 @@ type (
 	// ECS is the receiver struct for the container manager, specifically containing
 	// references to the logging components, settings etc needed
 	ECS struct {
-		Logger     logrus.Logger
+		Logger     *logrus.Logger
 		Conf       Settings
 		ECSService *ecs.ECS
+
+		// health is the result of the last check of the cluster, which completed at healthChecked; healthChecking is
+		// true whilst a check is in progress
+		healthLock     sync.Mutex
+		health         error
+		healthChecked  time.Time
+		healthChecking bool
 	}
 )
 
 func strArrToStrPointerArr(strArr []string) []*string {
 	ps := make([]*string, len(strArr))
-	for i:= 0; i<len(strArr); i++ {
+	for i := 0; i < len(strArr); i++ {
 		ps[i] = &strArr[i]
 	}
 	return ps
@@ -56,7 +77,7 @@ func strArrToStrPointerArr(strArr []string) []*string {
 
 // InitialiseECSService creates a new AWS config object as per the provided configuration with
 // regards to region and credentials
-func (cm *ECS) InitialiseECSService() (error) {
+func (cm *ECS) InitialiseECSService() error {
 	config := &aws.Config{Region: aws.String(cm.Conf.Region)}
 	if cm.Conf.Profile != "" {
 		config.Credentials = credentials.NewSharedCredentials("", cm.Conf.Profile)
@@ -91,18 +112,18 @@ func (cm *ECS) CreateContainer() (*cntr.Container, error) {
 		if err, ok := err.(awserr.Error); ok {
 			switch err.Code() {
 			case ecs.ErrCodeServerException:
-				log.Error(ecs.ErrCodeServerException, err, &cm.Logger)
+				log.Error(ecs.ErrCodeServerException, err, cm.Logger)
 			case ecs.ErrCodeClientException:
-				log.Error(ecs.ErrCodeClientException, err, &cm.Logger)
+				log.Error(ecs.ErrCodeClientException, err, cm.Logger)
 			case ecs.ErrCodeInvalidParameterException:
-				log.Error(ecs.ErrCodeInvalidParameterException, err, &cm.Logger)
+				log.Error(ecs.ErrCodeInvalidParameterException, err, cm.Logger)
 			case ecs.ErrCodeClusterNotFoundException:
-				log.Error(ecs.ErrCodeClusterNotFoundException, err, &cm.Logger)
+				log.Error(ecs.ErrCodeClusterNotFoundException, err, cm.Logger)
 			default:
-				log.Error(logAWSErrorOccurred, err, &cm.Logger)
+				log.Error(logAWSErrorOccurred, err, cm.Logger)
 			}
 		} else {
-			log.Error(logNonAWSErrorOccurred, err, &cm.Logger)
+			log.Error(logNonAWSErrorOccurred, err, cm.Logger)
 		}
 		return nil, err
 	}
@@ -144,7 +165,57 @@ func (cm *ECS) CreateContainer() (*cntr.Container, error) {
 }
 
 // DestroyContainer simply destroys the ECS container identified by the provided ID
-func (cm *ECS) DestroyContainer(externalID string) (error) {
+func (cm *ECS) DestroyContainer(externalID string) error {
 	// TODO obvs needs implementing
 	return nil
-}
\ No newline at end of file
+}
+
+// CheckHealth returns the result of the last check that ECS can be reached and that the configured cluster is active,
+// so that containers can be created in it. The cluster is checked again in the background once this result is older
+// than healthCheckInterval, so that callers such as readiness probes are never held up by ECS; an error is returned
+// until the first check has completed.
+func (cm *ECS) CheckHealth() error {
+	cm.healthLock.Lock()
+	defer cm.healthLock.Unlock()
+
+	if !cm.healthChecking && time.Since(cm.healthChecked) >= healthCheckInterval {
+		cm.healthChecking = true
+		go cm.refreshHealth()
+	}
+
+	if cm.healthChecked.IsZero() {
+		return errors.New(errorClusterNotChecked + cm.Conf.Cluster)
+	}
+	return cm.health
+}
+
+// refreshHealth checks the cluster, recording the result for CheckHealth
+func (cm *ECS) refreshHealth() {
+	err := cm.checkCluster()
+
+	cm.healthLock.Lock()
+	cm.health, cm.healthChecked, cm.healthChecking = err, time.Now(), false
+	cm.healthLock.Unlock()
+}
+
+// checkCluster describes the configured cluster, returning an error should ECS not be reachable within
+// healthCheckTimeout or the cluster not be active
+func (cm *ECS) checkCluster() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	output, err := cm.ECSService.DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+		Clusters: []*string{aws.String(cm.Conf.Cluster)},
+	})
+	if err != nil {
+		return err
+	}
+	if len(output.Clusters) == 0 {
+		return errors.New(errorClusterNotFound + cm.Conf.Cluster)
+	}
+	if status := aws.StringValue(output.Clusters[0].Status); status != clusterStatusActive {
+		return errors.New(errorClusterNotActive + cm.Conf.Cluster + " is " + status)
+	}
+
+	return nil
+}
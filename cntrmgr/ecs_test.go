@@ -1,8 +1,16 @@
 package cntrmgr
 
 import (
-	"testing"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
 )
 
 func Test_StrArrToStrPointerArr(t *testing.T) {
@@ -12,7 +20,7 @@ func Test_StrArrToStrPointerArr(t *testing.T) {
 		strArr := []string{}
 		strPointerArr := strArrToStrPointerArr(strArr)
 
-		assert.Equal(t, []*string {}, strPointerArr)
+		assert.Equal(t, []*string{}, strPointerArr)
 	})
 
 	t.Run("SingleArray", func(t *testing.T) {
@@ -20,7 +28,7 @@ func Test_StrArrToStrPointerArr(t *testing.T) {
 		strArr := []string{a}
 		strPointerArr := strArrToStrPointerArr(strArr)
 
-		assert.Equal(t, []*string {&a}, strPointerArr)
+		assert.Equal(t, []*string{&a}, strPointerArr)
 	})
 
 	t.Run("DoubleArray", func(t *testing.T) {
@@ -29,7 +37,7 @@ func Test_StrArrToStrPointerArr(t *testing.T) {
 		strArr := []string{a, b}
 		strPointerArr := strArrToStrPointerArr(strArr)
 
-		assert.Equal(t, []*string {&a, &b}, strPointerArr)
+		assert.Equal(t, []*string{&a, &b}, strPointerArr)
 	})
 
 	t.Run("MultipleArray", func(t *testing.T) {
@@ -40,7 +48,47 @@ func Test_StrArrToStrPointerArr(t *testing.T) {
 		strArr := []string{a, b, d, c}
 		strPointerArr := strArrToStrPointerArr(strArr)
 
-		assert.Equal(t, []*string {&a, &b, &d, &c}, strPointerArr)
+		assert.Equal(t, []*string{&a, &b, &d, &c}, strPointerArr)
 	})
 
 }
+
+func Test_CheckHealth(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		writer.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		writer.Write([]byte(`{"clusters":[{"clusterName":"test","status":"INACTIVE"}],"failures":[]}`))
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	assert.Nil(t, err)
+	cm := &ECS{Conf: Settings{Cluster: "test"}, ECSService: ecs.New(sess)}
+
+	// the first check is made in the background, so its result is not yet known
+	assert.Equal(t, errorClusterNotChecked+"test", cm.CheckHealth().Error())
+
+	for i := 0; i < 100 && cm.CheckHealth().Error() == errorClusterNotChecked+"test"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, errorClusterNotActive+"test is INACTIVE", cm.CheckHealth().Error())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	t.Run("Expired", func(t *testing.T) {
+		cm.healthLock.Lock()
+		cm.healthChecked = cm.healthChecked.Add(-healthCheckInterval)
+		cm.healthLock.Unlock()
+
+		// the previous result is still returned whilst the cluster is checked again
+		assert.NotNil(t, cm.CheckHealth())
+		for i := 0; i < 100 && atomic.LoadInt32(&requests) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	})
+}
@@ -1,7 +1,18 @@
 package cntrmgr
 
 import (
+	"errors"
 	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ManagerDummy identifies the DummyContainerManager; this is the default
+	ManagerDummy = "dummy"
+	// ManagerECS identifies the ECS container manager
+	ManagerECS = "ecs"
+
+	errorUnknownContainerManager = "unknown container manager: "
 )
 
 type (
@@ -9,6 +20,36 @@ type (
 	// to create a container, and to destroy a specified container
 	ContainerManager interface {
 		CreateContainer() (*cntr.Container, error)
-		DestroyContainer(externalID string) (error)
+		DestroyContainer(externalID string) error
+	}
+
+	// HealthChecker is implemented by container managers which depend on an external service to create containers,
+	// so that it can be checked whether this is reachable. Container managers which do not implement it are assumed
+	// to always be reachable.
+	HealthChecker interface {
+		CheckHealth() error
 	}
-)
\ No newline at end of file
+)
+
+// CreateContainerManager creates the container manager identified by managerType, which is one of the Manager
+// constants, using the settings provided. Any error that occurred whilst initialising it is returned.
+func CreateContainerManager(managerType string, s Settings, l *logrus.Logger) (ContainerManager, error) {
+	switch managerType {
+	case ManagerDummy, "":
+		return DummyContainerManager{}, nil
+
+	case ManagerECS:
+		cm := &ECS{
+			Logger: l,
+			Conf:   s,
+		}
+		if err := cm.InitialiseECSService(); err != nil {
+			return nil, err
+		}
+		// start checking the cluster now, so that the result is known by the time that readiness is first checked
+		cm.CheckHealth()
+		return cm, nil
+	}
+
+	return nil, errors.New(errorUnknownContainerManager + managerType)
+}
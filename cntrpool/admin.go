@@ -0,0 +1,261 @@
+package cntrpool
+
+import (
+	"context"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	logMsgDrainingContainer = "draining container; it will be removed once its client disconnects"
+	logMsgRemovedContainer  = "removed container from the pool"
+	logMsgResizedPool       = "resized pool"
+	logMsgHealthCheckFailed = "container failed health check"
+
+	logErrorScalingPool = "Error scaling pool"
+
+	errorContainerNotFound = "container not found"
+	errorInvalidPoolSize   = "invalid pool size: the maximum size must be at least 1 and the target free size between 0 and the maximum size"
+	errorPoolDestroyed     = "pool has been destroyed"
+)
+
+var (
+	// ErrContainerNotFound is returned when the container requested is not in the pool
+	ErrContainerNotFound = errors.New(errorContainerNotFound)
+)
+
+type (
+	// HealthCheckResult holds the result of checking a single container. Containers in use are not checked, as
+	// their clients are already connected to them; Checked is false for these.
+	HealthCheckResult struct {
+		ID      string
+		Checked bool
+		Healthy bool
+		Error   string `json:",omitempty"`
+	}
+)
+
+// Container returns the container with the ID provided, or nil if it is not in the pool
+func (cp *ContainerPool) Container(id string) *cntr.Container {
+	cp.status.RLock()
+	defer cp.status.RUnlock()
+
+	if c, ok := cp.status.usedContainers[id]; ok {
+		return c
+	}
+	return cp.status.unusedContainers[id]
+}
+
+// DrainContainer stops the container with the ID provided from being assigned to any further clients. If it is not in
+// use then it is removed from the pool immediately, and true returned; otherwise this happens once its client
+// disconnects. Either way, the container is destroyed and the pool scaled up to replace it in the background.
+func (cp *ContainerPool) DrainContainer(id string) (bool, error) {
+	cp.status.Lock()
+	if _, used := cp.status.usedContainers[id]; used {
+		cp.status.drainingContainers[id] = true
+		cp.status.Unlock()
+
+		cp.entry.WithFields(logrus.Fields{logFieldContainerID: id}).Info(logMsgDrainingContainer)
+		return false, nil
+	}
+	cp.status.Unlock()
+
+	if err := cp.RemoveContainer(id); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RemoveContainer removes the container with the ID provided from the pool, whether or not it is in use. Any client
+// using it is not disconnected by the pool. The container is then destroyed and the pool scaled up to replace it as
+// required in the background, so that the caller is not held up by the container manager.
+func (cp *ContainerPool) RemoveContainer(id string) error {
+	cp.status.Lock()
+	c, ok := cp.containers[id]
+	if ok {
+		delete(cp.containers, id)
+		delete(cp.status.unusedContainers, id)
+		delete(cp.status.usedContainers, id)
+		delete(cp.status.drainingContainers, id)
+	}
+	cp.status.Unlock()
+	cp.writePoolStats()
+
+	if !ok {
+		return ErrContainerNotFound
+	}
+
+	cp.entry.WithFields(logrus.Fields{logFieldContainerID: id}).Info(logMsgRemovedContainer)
+	cp.rescaleInBackground([]*cntr.Container{c}, 0)
+
+	return nil
+}
+
+// Resize changes the maximum size of the pool and the number of free containers that it aims to keep. The pool is
+// then scaled in the background: free containers beyond the new maximum size or target are destroyed, and new ones
+// are created to meet the target. Containers in use are never removed, so the pool may remain over its maximum size
+// until their clients disconnect.
+func (cp *ContainerPool) Resize(maximumSize, targetFreeSize int) error {
+	if maximumSize < 1 || targetFreeSize < 0 || targetFreeSize > maximumSize {
+		return errors.New(errorInvalidPoolSize)
+	}
+
+	cp.status.Lock()
+	if cp.status.isDestroyed {
+		cp.status.Unlock()
+		return errors.New(errorPoolDestroyed)
+	}
+
+	cp.settings.MaximumSize = maximumSize
+	cp.settings.TargetFreeSize = targetFreeSize
+
+	excess := len(cp.status.unusedContainers) - targetFreeSize
+	if overMaximum := len(cp.containers) - maximumSize; overMaximum > excess {
+		excess = overMaximum
+	}
+	cp.status.Unlock()
+
+	cp.entry.WithFields(logrus.Fields{
+		logFieldMaxSizePool:    maximumSize,
+		logFieldTargetFreePool: targetFreeSize,
+	}).Info(logMsgResizedPool)
+
+	cp.rescaleInBackground(nil, excess)
+
+	return nil
+}
+
+// rescaleInBackground destroys the containers provided, which must already have been removed from the pool, together
+// with up to excess free containers, and then scales the pool up as required. This is done in a separate goroutine so
+// that admin requests are not held up by the container manager; any errors are logged. DestroyPool waits for this to
+// complete, so once the pool has been destroyed the containers provided are destroyed straight away instead.
+func (cp *ContainerPool) rescaleInBackground(removed []*cntr.Container, excess int) {
+	cp.status.Lock()
+	destroyed := cp.status.isDestroyed
+	if !destroyed {
+		cp.background.Add(1)
+	}
+	cp.status.Unlock()
+
+	rescale := func() {
+		var errs []error
+		for _, c := range removed {
+			if err := cp.destroyContainer(c); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if excess > 0 {
+			errs = append(errs, cp.removeContainersFromPool(excess)...)
+		}
+		errs = append(errs, cp.scaleUpPoolIfRequired()...)
+
+		for _, err := range errs {
+			log.ErrorEntry(logErrorScalingPool, err, cp.entry)
+		}
+	}
+
+	if destroyed {
+		rescale()
+		return
+	}
+
+	go func() {
+		defer cp.background.Done()
+		rescale()
+	}()
+}
+
+// removeFreeContainer removes the container with the ID provided from the pool, returning it, but only if it is
+// not in use; otherwise nil is returned. Checking and removing the container under the same lock ensures that it
+// cannot be assigned to a client in between.
+func (cp *ContainerPool) removeFreeContainer(id string) *cntr.Container {
+	cp.status.Lock()
+	c, free := cp.status.unusedContainers[id]
+	if free {
+		delete(cp.containers, id)
+		delete(cp.status.unusedContainers, id)
+	}
+	cp.status.Unlock()
+
+	if !free {
+		return nil
+	}
+	cp.writePoolStats()
+
+	return c
+}
+
+// CheckHealth attempts to connect to every free container at once, allowing the timeout provided for all of them
+// to do so. Any container which cannot be connected to, and which is still free, is treated as having failed: it is
+// removed from the pool, then destroyed and replaced in the background. The result for each container is returned,
+// ordered as per Snapshot.
+func (cp *ContainerPool) CheckHealth(timeout time.Duration) []HealthCheckResult {
+	snapshot := cp.Snapshot()
+	results := make([]HealthCheckResult, len(snapshot.Containers))
+
+	deadline, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, cs := range snapshot.Containers {
+		results[i].ID = cs.ID
+		if cs.Busy {
+			continue
+		}
+
+		c := cp.Container(cs.ID)
+		if c == nil {
+			// the container was removed since the snapshot was taken
+			continue
+		}
+
+		results[i].Checked = true
+		wg.Add(1)
+		go func(result *HealthCheckResult, address string) {
+			defer wg.Done()
+
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(deadline, "tcp", address)
+			if err != nil {
+				result.Error = err.Error()
+				return
+			}
+			result.Healthy = true
+			// we're not going to act on Close errors, so ignore purposefully
+			conn.Close()
+		}(&results[i], c.IPAddress+":"+strconv.Itoa(c.Port))
+	}
+	wg.Wait()
+
+	var failed []*cntr.Container
+	for _, result := range results {
+		if !result.Checked || result.Healthy {
+			continue
+		}
+
+		entry := cp.entry.WithFields(logrus.Fields{
+			logFieldContainerID: result.ID,
+			logFieldError:       result.Error,
+		})
+		entry.Warn(logMsgHealthCheckFailed)
+
+		// the container may have been assigned to a client whilst it was being checked, in which case it is left alone
+		if c := cp.removeFreeContainer(result.ID); c != nil {
+			entry.Warn(logMsgContainerFailed)
+			cp.monitor.WriteContainerFailed(1)
+			failed = append(failed, c)
+		}
+	}
+	if len(failed) > 0 {
+		cp.rescaleInBackground(failed, 0)
+	}
+
+	return results
+}
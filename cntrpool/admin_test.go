@@ -0,0 +1,176 @@
+package cntrpool
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_DrainContainer(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	s := Settings{InitialSize: 2, MaximumSize: 4, TargetFreeSize: 0}
+
+	t.Run("FreeContainerRemovedImmediately", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		cp.AdoptContainers([]*cntr.Container{{ExternalID: "a"}, {ExternalID: "b"}})
+
+		removed, err := cp.DrainContainer("a")
+		assert.Nil(t, err)
+		assert.True(t, removed)
+		assert.Nil(t, cp.Container("a"))
+		cp.background.Wait()
+		assert.Equal(t, 1, len(cp.containers))
+	})
+
+	t.Run("UsedContainerRemovedOnDisconnect", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		cp.AdoptContainers([]*cntr.Container{{ExternalID: "a"}})
+
+		serverConn, clientConn := net.Pipe()
+		defer clientConn.Close()
+		c, err := cp.AssociateClientWithContainer(serverConn)
+		assert.Nil(t, err)
+
+		removed, err := cp.DrainContainer("a")
+		assert.Nil(t, err)
+		assert.False(t, removed)
+		assert.True(t, cp.Snapshot().Containers[0].Draining)
+
+		// the container is not returned to the pool once its client disconnects
+		cp.DissociateClientWithContainer(serverConn, c)
+		assert.Nil(t, cp.Container("a"))
+		assert.Equal(t, 0, len(cp.status.unusedContainers))
+		assert.Equal(t, 0, len(cp.status.drainingContainers))
+	})
+
+	t.Run("UnknownContainer", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		_, err := cp.DrainContainer("missing")
+		assert.Equal(t, ErrContainerNotFound, err)
+	})
+}
+
+func Test_RemoveContainer(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	s := Settings{InitialSize: 1, MaximumSize: 4, TargetFreeSize: 1}
+
+	cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+	cp.AdoptContainers([]*cntr.Container{{ExternalID: "a"}})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	_, err := cp.AssociateClientWithContainer(serverConn)
+	assert.Nil(t, err)
+
+	// containers in use can be removed, and a free container is created in the background as per the target
+	assert.Nil(t, cp.RemoveContainer("a"))
+	assert.Nil(t, cp.Container("a"))
+	cp.background.Wait()
+	assert.Equal(t, 0, len(cp.status.usedContainers))
+	assert.Equal(t, 1, len(cp.status.unusedContainers))
+
+	assert.Equal(t, ErrContainerNotFound, cp.RemoveContainer("a"))
+}
+
+func Test_Resize(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	s := Settings{InitialSize: 2, MaximumSize: 4, TargetFreeSize: 2}
+
+	t.Run("Invalid", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		for _, size := range [][2]int{{0, 0}, {2, -1}, {2, 3}} {
+			assert.Equal(t, errorInvalidPoolSize, cp.Resize(size[0], size[1]).Error())
+		}
+		assert.Equal(t, 4, cp.Snapshot().Settings.MaximumSize)
+	})
+
+	t.Run("ScaleUp", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		cp.InitialisePool()
+
+		assert.Nil(t, cp.Resize(6, 5))
+		cp.background.Wait()
+		assert.Equal(t, 5, len(cp.status.unusedContainers))
+		assert.Equal(t, SnapshotSettings{InitialSize: 2, MaximumSize: 6, TargetFreeSize: 5}, cp.Snapshot().Settings)
+	})
+
+	t.Run("ScaleDown", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		cp.InitialisePool()
+		cp.Resize(4, 4)
+		cp.background.Wait()
+
+		serverConn, clientConn := net.Pipe()
+		defer clientConn.Close()
+		_, err := cp.AssociateClientWithContainer(serverConn)
+		assert.Nil(t, err)
+
+		// only free containers are removed, down to the new maximum size
+		assert.Nil(t, cp.Resize(2, 1))
+		cp.background.Wait()
+		assert.Equal(t, 1, len(cp.status.usedContainers))
+		assert.Equal(t, 1, len(cp.status.unusedContainers))
+	})
+
+	t.Run("Destroyed", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		cp.DestroyPool()
+		assert.Equal(t, errorPoolDestroyed, cp.Resize(4, 1).Error())
+	})
+}
+
+func Test_CheckHealth(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	s := Settings{InitialSize: 0, MaximumSize: 4, TargetFreeSize: 0}
+
+	healthy, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer healthy.Close()
+	unhealthy, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.Nil(t, err)
+	unhealthy.Close()
+
+	cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+	cp.AdoptContainers([]*cntr.Container{
+		{ExternalID: "a", IPAddress: "127.0.0.1", Port: healthy.Addr().(*net.TCPAddr).Port},
+		{ExternalID: "b", IPAddress: "127.0.0.1", Port: unhealthy.Addr().(*net.TCPAddr).Port},
+		{ExternalID: "c"},
+	})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	// make sure that "c" is the container assigned to the client
+	cp.status.Lock()
+	a, b := cp.status.unusedContainers["a"], cp.status.unusedContainers["b"]
+	delete(cp.status.unusedContainers, "a")
+	delete(cp.status.unusedContainers, "b")
+	cp.status.Unlock()
+	_, err = cp.AssociateClientWithContainer(serverConn)
+	assert.Nil(t, err)
+	cp.status.Lock()
+	cp.status.unusedContainers["a"], cp.status.unusedContainers["b"] = a, b
+	cp.status.Unlock()
+
+	results := cp.CheckHealth(time.Second)
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, HealthCheckResult{ID: "a", Checked: true, Healthy: true}, results[0])
+	assert.Equal(t, "b", results[1].ID)
+	assert.True(t, results[1].Checked)
+	assert.False(t, results[1].Healthy)
+	assert.NotEqual(t, "", results[1].Error)
+	assert.Equal(t, HealthCheckResult{ID: "c"}, results[2])
+
+	// the unhealthy container has been removed from the pool
+	assert.Nil(t, cp.Container("b"))
+	assert.NotNil(t, cp.Container("a"))
+	cp.background.Wait()
+}
@@ -0,0 +1,108 @@
+package cntrpool
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+const (
+	// BackendVerificationFull verifies both the certificate chain presented by the container and that it matches
+	// the expected server name; this is the default
+	BackendVerificationFull = "full"
+	// BackendVerificationCA verifies the certificate chain presented by the container but not the server name
+	BackendVerificationCA = "ca"
+	// BackendVerificationNone performs no verification of the certificate presented by the container
+	BackendVerificationNone = "none"
+
+	errorReadingBackendCAFile       = "error reading backend CA file: "
+	errorParsingBackendCAFile       = "error parsing backend CA file: no certificates found in "
+	errorLoadingBackendCertificate  = "error loading backend client certificate: "
+	errorUnknownBackendVerification = "unknown backend verification mode: "
+	errorNoBackendCertificate       = "container did not present a certificate"
+)
+
+type (
+	// BackendTLSSettings represents the configuration used when connecting to containers over TLS rather than
+	// plain TCP
+	BackendTLSSettings struct {
+		Enabled      bool
+		CAFile       string
+		CertFile     string
+		KeyFile      string
+		ServerName   string
+		Verification string
+	}
+)
+
+// createBackendTLSConfig creates the tls.Config used to connect to containers from the settings provided, returning
+// any error that occurred whilst loading the files referenced by it
+func createBackendTLSConfig(s BackendTLSSettings) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: s.ServerName,
+	}
+
+	if s.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, errors.New(errorReadingBackendCAFile + err.Error())
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New(errorParsingBackendCAFile + s.CAFile)
+		}
+	}
+
+	if s.CertFile != "" || s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, errors.New(errorLoadingBackendCertificate + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	switch s.Verification {
+	case BackendVerificationFull, "":
+	case BackendVerificationCA:
+		// the standard verification always checks the server name, so skip it and verify the chain ourselves
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyCertificateChain(config.RootCAs)
+	case BackendVerificationNone:
+		config.InsecureSkipVerify = true
+	default:
+		return nil, errors.New(errorUnknownBackendVerification + s.Verification)
+	}
+
+	return config, nil
+}
+
+// verifyCertificateChain returns a function that verifies the certificate chain presented by a container against
+// the roots provided, without checking the server name
+func verifyCertificateChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New(errorNoBackendCertificate)
+		}
+
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, rawCert := range rawCerts {
+			cert, err := x509.ParseCertificate(rawCert)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
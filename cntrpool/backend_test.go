@@ -0,0 +1,336 @@
+package cntrpool
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/nextmetaphor/tcp-proxy-pool/proxyproto"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type (
+	// TestBackendContainerManager creates containers which all point at the same backend address
+	TestBackendContainerManager struct {
+		addr *net.TCPAddr
+	}
+)
+
+func (cm TestBackendContainerManager) CreateContainer() (*cntr.Container, error) {
+	nextContainerID++
+	return &cntr.Container{
+		ExternalID: "backend-" + strconv.Itoa(nextContainerID),
+		IPAddress:  cm.addr.IP.String(),
+		Port:       cm.addr.Port,
+	}, nil
+}
+
+func (cm TestBackendContainerManager) DestroyContainer(externalID string) error {
+	return nil
+}
+
+// createTestCertificates creates a CA, together with a server certificate for 127.0.0.1 signed by it, returning the
+// server certificate and the path of the PEM-encoded CA file written to the directory provided
+func createTestCertificates(t *testing.T, dir string) (tls.Certificate, string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.Nil(t, err)
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-container"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"container.test"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	assert.Nil(t, err)
+
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+
+	return tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}, caFile
+}
+
+// startBackend starts a listener which completes a handshake (if TLS) and then closes each connection
+func startBackend(t *testing.T, l net.Listener) net.Listener {
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				tlsConn.Handshake()
+			}
+			conn.Close()
+		}
+	}()
+
+	return l
+}
+
+func Test_CreateBackendTLSConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "backend-tls")
+	defer os.RemoveAll(dir)
+	_, caFile := createTestCertificates(t, dir)
+
+	t.Run("DefaultVerification", func(t *testing.T) {
+		config, err := createBackendTLSConfig(BackendTLSSettings{CAFile: caFile, ServerName: "container.test"})
+		assert.Nil(t, err)
+		assert.False(t, config.InsecureSkipVerify)
+		assert.Equal(t, "container.test", config.ServerName)
+		assert.NotNil(t, config.RootCAs)
+	})
+
+	t.Run("CAVerification", func(t *testing.T) {
+		config, err := createBackendTLSConfig(BackendTLSSettings{CAFile: caFile, Verification: BackendVerificationCA})
+		assert.Nil(t, err)
+		assert.True(t, config.InsecureSkipVerify)
+		assert.NotNil(t, config.VerifyPeerCertificate)
+	})
+
+	t.Run("UnknownVerification", func(t *testing.T) {
+		config, err := createBackendTLSConfig(BackendTLSSettings{Verification: "maybe"})
+		assert.Nil(t, config)
+		assert.Equal(t, errorUnknownBackendVerification+"maybe", err.Error())
+	})
+
+	t.Run("MissingCAFile", func(t *testing.T) {
+		config, err := createBackendTLSConfig(BackendTLSSettings{CAFile: filepath.Join(dir, "missing.crt")})
+		assert.Nil(t, config)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("InvalidCAFile", func(t *testing.T) {
+		invalidFile := filepath.Join(dir, "invalid.crt")
+		ioutil.WriteFile(invalidFile, []byte("not a certificate"), 0600)
+		config, err := createBackendTLSConfig(BackendTLSSettings{CAFile: invalidFile})
+		assert.Nil(t, config)
+		assert.Equal(t, errorParsingBackendCAFile+invalidFile, err.Error())
+	})
+}
+
+func Test_ConnectClientToContainer(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+
+	dir, _ := ioutil.TempDir("", "backend-tls")
+	defer os.RemoveAll(dir)
+	serverCert, caFile := createTestCertificates(t, dir)
+
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	assert.Nil(t, err)
+	tlsBackend := startBackend(t, tlsListener)
+	defer tlsBackend.Close()
+
+	plainListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	plainBackend := startBackend(t, plainListener)
+	defer plainBackend.Close()
+
+	connect := func(backend net.Listener, backendTLS BackendTLSSettings) (*ContainerPool, *cntr.Container, error) {
+		tcm := TestBackendContainerManager{addr: backend.Addr().(*net.TCPAddr)}
+		s := Settings{InitialSize: 1, MaximumSize: 1, BackendTLS: backendTLS}
+		cp, err := CreateContainerPool(tcm, s, l, m)
+		assert.Nil(t, err)
+		assert.Nil(t, cp.InitialisePool())
+
+		clientConn, _ := net.Pipe()
+		c, err := cp.AssociateClientWithContainer(clientConn)
+		assert.Nil(t, err)
+
+		return cp, c, cp.ConnectClientToContainer(c, 0)
+	}
+
+	t.Run("PlainTCP", func(t *testing.T) {
+		_, c, err := connect(plainBackend, BackendTLSSettings{})
+		assert.Nil(t, err)
+		assert.IsType(t, &net.TCPConn{}, c.ConnectionToContainer)
+		c.ConnectionToContainer.Close()
+	})
+
+	t.Run("VerifiedTLS", func(t *testing.T) {
+		cp, c, err := connect(tlsBackend, BackendTLSSettings{Enabled: true, CAFile: caFile})
+		assert.Nil(t, err)
+		assert.IsType(t, &tls.Conn{}, c.ConnectionToContainer)
+		assert.Equal(t, 1, len(cp.containers))
+		c.ConnectionToContainer.Close()
+	})
+
+	t.Run("ServerNameOverride", func(t *testing.T) {
+		_, c, err := connect(tlsBackend, BackendTLSSettings{Enabled: true, CAFile: caFile, ServerName: "container.test"})
+		assert.Nil(t, err)
+		c.ConnectionToContainer.Close()
+	})
+
+	t.Run("CAOnlyVerificationIgnoresServerName", func(t *testing.T) {
+		_, c, err := connect(tlsBackend, BackendTLSSettings{
+			Enabled:      true,
+			CAFile:       caFile,
+			ServerName:   "wrong.test",
+			Verification: BackendVerificationCA,
+		})
+		assert.Nil(t, err)
+		c.ConnectionToContainer.Close()
+	})
+
+	t.Run("WrongServerNameFailsContainer", func(t *testing.T) {
+		cp, c, err := connect(tlsBackend, BackendTLSSettings{Enabled: true, CAFile: caFile, ServerName: "wrong.test"})
+		assert.NotNil(t, err)
+		assert.Nil(t, c.ConnectionToContainer)
+		assert.Equal(t, 0, len(cp.containers))
+		assert.Equal(t, 0, len(cp.status.usedContainers))
+	})
+
+	t.Run("FailedHandshakeFailsContainer", func(t *testing.T) {
+		cp, c, err := connect(plainBackend, BackendTLSSettings{Enabled: true, Verification: BackendVerificationNone})
+		assert.NotNil(t, err)
+		assert.Nil(t, c.ConnectionToContainer)
+		assert.Equal(t, 0, len(cp.containers))
+
+		// the failed container should not be returned to the pool once the client disconnects
+		cp.DissociateClientWithContainer(c.ConnectionFromClient, c)
+		assert.Equal(t, 0, len(cp.status.unusedContainers))
+	})
+}
+
+func Test_ConnectClientToContainerProxyProtocol(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer backend.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	tcm := TestBackendContainerManager{addr: backend.Addr().(*net.TCPAddr)}
+
+	_, err = CreateContainerPool(tcm, Settings{InitialSize: 1, MaximumSize: 1, ProxyProtocol: 3}, l, m)
+	assert.NotNil(t, err)
+
+	cp, err := CreateContainerPool(tcm, Settings{InitialSize: 1, MaximumSize: 1, ProxyProtocol: 1}, l, m)
+	assert.Nil(t, err)
+	assert.Nil(t, cp.InitialisePool())
+
+	// use a real TCP connection to represent the client so that it has TCP addresses
+	clientListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer clientListener.Close()
+	clientConn, err := net.Dial("tcp", clientListener.Addr().String())
+	assert.Nil(t, err)
+	defer clientConn.Close()
+
+	c, err := cp.AssociateClientWithContainer(clientConn)
+	assert.Nil(t, err)
+	assert.Nil(t, cp.ConnectClientToContainer(c, 0))
+	defer c.ConnectionToContainer.Close()
+
+	src := clientConn.RemoteAddr().(*net.TCPAddr)
+	dst := clientConn.LocalAddr().(*net.TCPAddr)
+	assert.Equal(t, fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %d\r\n", src.Port, dst.Port), <-received)
+}
+
+func Test_ConnectClientToContainerProxyProtocolV2(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+
+	dir, _ := ioutil.TempDir("", "proxy-protocol-v2")
+	defer os.RemoveAll(dir)
+	cert, _ := createTestCertificates(t, dir)
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer backend.Close()
+
+	received := make(chan *proxyproto.Header, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		h, _ := proxyproto.Read(bufio.NewReader(conn))
+		received <- h
+	}()
+
+	tcm := TestBackendContainerManager{addr: backend.Addr().(*net.TCPAddr)}
+	cp, err := CreateContainerPool(tcm, Settings{InitialSize: 1, MaximumSize: 1, ProxyProtocol: 2}, l, m)
+	assert.Nil(t, err)
+	assert.Nil(t, cp.InitialisePool())
+
+	// the client connects over TLS, without presenting a certificate, so that its TLS session is described by TLVs
+	clientListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.Nil(t, err)
+	defer clientListener.Close()
+	go func() {
+		conn, err := tls.Dial("tcp", clientListener.Addr().String(),
+			&tls.Config{InsecureSkipVerify: true, ServerName: "container.test"})
+		if err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	clientConn, err := clientListener.Accept()
+	assert.Nil(t, err)
+	defer clientConn.Close()
+	assert.Nil(t, clientConn.(*tls.Conn).Handshake())
+
+	c, err := cp.AssociateClientWithContainer(clientConn)
+	assert.Nil(t, err)
+	assert.Nil(t, cp.ConnectClientToContainer(c, 0))
+	defer c.ConnectionToContainer.Close()
+
+	h := <-received
+	assert.NotNil(t, h)
+	assert.Equal(t, clientConn.RemoteAddr().String(), h.Source.String())
+	assert.Equal(t, 2, len(h.TLVs))
+	assert.Equal(t, proxyproto.TLV{Type: proxyproto.TypeAuthority, Value: []byte("container.test")}, h.TLVs[0])
+
+	ssl := h.TLVs[1]
+	assert.Equal(t, byte(proxyproto.TypeSSL), ssl.Type)
+	assert.Equal(t, byte(proxyproto.ClientSSL), ssl.Value[0])
+	assert.Equal(t, []byte{0, 0, 0, proxyproto.VerifyFailed}, ssl.Value[1:5])
+}
@@ -1,16 +1,18 @@
 package cntrpool
 
 import (
+	"crypto/tls"
 	"errors"
 	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
 	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
 	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/nextmetaphor/tcp-proxy-pool/proxyproto"
 	"github.com/sirupsen/logrus"
 	"net"
 	"strconv"
 	"sync"
 	"time"
-	"github.com/nextmetaphor/tcp-proxy-pool/log"
 )
 
 const (
@@ -20,7 +22,13 @@ const (
 	logMsgOldContainersNotRequired = "calculating old containers not required"
 	logMsgAlreadyScaling           = "already scaling; not considering scale-up event"
 	logMsgScaleDownStatus          = "scale down status"
+	logMsgDestroyingPool           = "destroying all containers in the pool"
+	logMsgContainerFailed          = "container failed; removing from the pool"
+	logMsgReleasedContainers       = "released unused containers from the pool"
+	logMsgAdoptedContainers        = "adopted containers into the pool"
+	logMsgDrainedContainer         = "drained container; removing from the pool"
 
+	logFieldPool                     = "pool"
 	logFieldContainerID              = "container-id"
 	logFieldSizePool                 = "size-pool"
 	logFieldMaxSizePool              = "max-size-pool"
@@ -32,8 +40,13 @@ const (
 	logFieldLastScaleDownTime        = "last-scale-down-time"
 	logFieldNextScaleDownTime        = "next-scale-down-time"
 	logFieldCurrentTime              = "current-time"
+	logFieldContainersToDestroy      = "containers-to-destroy"
+	logFieldContainers               = "containers"
+	logFieldError                    = "error"
 
 	logErrorCreatingContainer     = "Error creating container"
+	logErrorReplacingContainer    = "Error replacing failed container"
+	logErrorDestroyingContainer   = "Error destroying container"
 	logNilContainerToDisassociate = "Nil container to disassociate from the container pool"
 	logContainerDoesNotExist      = "The container with ID [%s] to disassociate from the client does not exist in the pool"
 
@@ -41,16 +54,38 @@ const (
 	errorLoggerNil                   = "error creating container pool: logger cannot be nil"
 	errorCreatedContainerCannotBeNil = "created container cannot be nil"
 	errorContainerPoolFull           = "pool is full; cannot allocate connection to container"
+	errorClientNotAllowed            = "client is not allowed to use the pool"
+
+	// backendConnectTimeout limits the time taken to connect to a container, including sending any PROXY protocol
+	// header and completing any TLS handshake
+	backendConnectTimeout = 10 * time.Second
 )
 
 type (
 	// Settings represents the various configuration parameters for a connection pool and are typically read
 	// from an external configuration file
 	Settings struct {
+		// Name identifies the pool in logs and monitor points; it is set from the name of the pool in the
+		// application settings
+		Name string
+
 		InitialSize    int
 		MaximumSize    int
 		TargetFreeSize int
 		ScaleDownDelay int
+		BackendTLS     BackendTLSSettings
+
+		// AllowedClients restricts the pool to clients presenting a verified certificate with a common name or
+		// subject alternative name in the list; if empty then all clients are allowed
+		AllowedClients []string
+
+		// ProxyProtocol is the version of the PROXY protocol header sent to a container when a client is connected
+		// to it, either 1 or 2; if 0 then no header is sent
+		ProxyProtocol int
+
+		// ReadyFreeSize is the number of free containers the pool must have for the proxy to be ready to serve
+		// clients; if 0 then the pool is ready once it has first reached InitialSize
+		ReadyFreeSize int
 	}
 
 	// containerStatus is a synchronised struct that is used to provide maps of used and unused containers that
@@ -59,10 +94,25 @@ type (
 		sync.RWMutex
 
 		isScaling     bool
+		isDestroyed   bool
 		lastScaleDown time.Time
 
+		// startingContainers is the number of containers being created for the pool
+		startingContainers int
+
+		// hasReachedInitialSize is set once the pool has first held InitialSize containers, so that the pool remains
+		// ready should it later be scaled down below this
+		hasReachedInitialSize bool
+
 		usedContainers   map[string]*cntr.Container
 		unusedContainers map[string]*cntr.Container
+
+		// drainingContainers holds the IDs of used containers which are to be removed from the pool and destroyed
+		// once their client disconnects, rather than being returned to it
+		drainingContainers map[string]bool
+
+		// statsRead is incremented each time the numbers of containers are read to be written to the monitor
+		statsRead uint64
 	}
 
 	// ContainerPool represents the internal representation of a connection pool, specifically containing
@@ -75,15 +125,27 @@ type (
 		status containerStatus
 
 		logger   *logrus.Logger
+		entry    *logrus.Entry
 		settings Settings
 		manager  cntrmgr.ContainerManager
-		monitor  monitor.Client
+		monitor  monitor.Monitor
+
+		// backendTLSConfig is used to connect to containers over TLS; if nil, plain TCP is used
+		backendTLSConfig *tls.Config
+
+		// background tracks the containers being destroyed and created in the background following admin requests
+		background sync.WaitGroup
+
+		// statsLock is held whilst writing the numbers of containers to the monitor, and statsWritten is the value of
+		// statsRead when the last numbers written were read
+		statsLock    sync.Mutex
+		statsWritten uint64
 	}
 )
 
 // CreateContainerPool creates a container pool; if an error occurs then this is returned together with a nil
-// container pool
-func CreateContainerPool(cm cntrmgr.ContainerManager, s Settings, l *logrus.Logger, m monitor.Client) (pool *ContainerPool, err error) {
+// container pool. Points are written to the monitor provided, or discarded if it is nil.
+func CreateContainerPool(cm cntrmgr.ContainerManager, s Settings, l *logrus.Logger, m monitor.Monitor) (pool *ContainerPool, err error) {
 	if cm == nil {
 		return nil, errors.New(errorContainerManagerNil)
 	}
@@ -91,25 +153,100 @@ func CreateContainerPool(cm cntrmgr.ContainerManager, s Settings, l *logrus.Logg
 		return nil, errors.New(errorLoggerNil)
 	}
 
+	if s.ProxyProtocol != 0 {
+		if err := proxyproto.ValidVersion(s.ProxyProtocol); err != nil {
+			return nil, err
+		}
+	}
+
+	if m == nil {
+		m = monitor.NoOp{}
+	}
+
+	var backendTLSConfig *tls.Config
+	if s.BackendTLS.Enabled {
+		if backendTLSConfig, err = createBackendTLSConfig(s.BackendTLS); err != nil {
+			return nil, err
+		}
+	}
+
 	pool = &ContainerPool{
 		containers: make(map[string]*cntr.Container),
 		status: containerStatus{
-			unusedContainers: make(map[string]*cntr.Container),
-			usedContainers:   make(map[string]*cntr.Container),
-			lastScaleDown:    time.Now(),
+			unusedContainers:   make(map[string]*cntr.Container),
+			usedContainers:     make(map[string]*cntr.Container),
+			drainingContainers: make(map[string]bool),
+			lastScaleDown:      time.Now(),
 		},
 		logger:   l,
+		entry:    l.WithField(logFieldPool, s.Name),
 		settings: s,
 		manager:  cm,
 		monitor:  m,
+
+		backendTLSConfig: backendTLSConfig,
 	}
 
 	return pool, nil
 }
 
-// InitialisePool simply creates a pool of the specified pool.Settings.InitialSize
+// InitialisePool simply creates a pool of the specified pool.Settings.InitialSize, taking into account any containers
+// which have already been adopted into it
 func (cp *ContainerPool) InitialisePool() (errors []error) {
-	return cp.addContainersToPool(cp.settings.InitialSize)
+	cp.status.RLock()
+	numContainers := cp.settings.InitialSize - len(cp.containers)
+	cp.status.RUnlock()
+
+	return cp.addContainersToPool(numContainers)
+}
+
+// ReleaseUnusedContainers removes every container which is not in use from the pool without destroying it, returning
+// the containers removed. This allows them to be handed over to another process; should this fail then they can be
+// returned to the pool using AdoptContainers.
+func (cp *ContainerPool) ReleaseUnusedContainers() (released []*cntr.Container) {
+	cp.status.Lock()
+	{
+		for cID, c := range cp.status.unusedContainers {
+			released = append(released, c)
+
+			delete(cp.status.unusedContainers, cID)
+			delete(cp.containers, cID)
+		}
+	}
+	cp.status.Unlock()
+	cp.writePoolStats()
+
+	cp.entry.WithFields(logrus.Fields{logFieldContainers: len(released)}).Info(logMsgReleasedContainers)
+
+	return released
+}
+
+// AdoptContainers adds the running containers provided to the pool as unused containers, for example those released
+// by another process. They are added even if this takes the pool over its maximum size, as they would otherwise be
+// left running; the pool will be scaled down as normal. Containers are not adopted once the pool has been destroyed.
+func (cp *ContainerPool) AdoptContainers(containers []*cntr.Container) (adopted []*cntr.Container) {
+	cp.status.Lock()
+	{
+		if !cp.status.isDestroyed {
+			for _, c := range containers {
+				if _, exists := cp.containers[c.ExternalID]; exists {
+					continue
+				}
+
+				c.ConnectionFromClient = nil
+				c.ConnectionToContainer = nil
+				cp.status.unusedContainers[c.ExternalID] = c
+				cp.containers[c.ExternalID] = c
+				adopted = append(adopted, c)
+			}
+		}
+	}
+	cp.status.Unlock()
+	cp.writePoolStats()
+
+	cp.entry.WithFields(logrus.Fields{logFieldContainers: len(adopted)}).Info(logMsgAdoptedContainers)
+
+	return adopted
 }
 
 func (cp *ContainerPool) addContainersToPool(numContainers int) (e []error) {
@@ -118,6 +255,7 @@ func (cp *ContainerPool) addContainersToPool(numContainers int) (e []error) {
 		c, err := cp.createContainer()
 		if err != nil {
 			e = append(e, err)
+			cp.writePoolStats()
 			continue
 		}
 
@@ -125,8 +263,9 @@ func (cp *ContainerPool) addContainersToPool(numContainers int) (e []error) {
 		{
 			// there is a chance that the number of used containers in the pool has changed which would mean that
 			// we'd exceed the maximum size of the pool by adding our new container to it.
-			// now we've got the lock, check if this is the case, and destroy the container if necessary
-			if len(cp.containers) < cp.settings.MaximumSize {
+			// now we've got the lock, check if this is the case, and destroy the container if necessary. The same
+			// applies if the pool has been destroyed whilst the container was being created.
+			if !cp.status.isDestroyed && len(cp.containers) < cp.settings.MaximumSize {
 				cp.status.unusedContainers[c.ExternalID] = c
 				cp.containers[c.ExternalID] = c
 			} else {
@@ -138,6 +277,7 @@ func (cp *ContainerPool) addContainersToPool(numContainers int) (e []error) {
 
 		}
 		cp.status.Unlock()
+		cp.writePoolStats()
 	}
 
 	return e
@@ -163,6 +303,7 @@ func (cp *ContainerPool) removeContainersFromPool(numContainers int) (errors []e
 		}
 	}
 	cp.status.Unlock()
+	cp.writePoolStats()
 
 	// at this point these containers are no longer referenced from the pool so can be destroyed
 	// without a lock
@@ -181,9 +322,21 @@ func (cp *ContainerPool) removeContainersFromPool(numContainers int) (errors []e
 // whilst the container is being created. We create the container first; only locking the pool when we want to
 // add the container pointer.
 func (cp *ContainerPool) createContainer() (c *cntr.Container, err error) {
+	cp.status.Lock()
+	cp.status.startingContainers++
+	cp.status.Unlock()
+	cp.writePoolStats()
+
+	start := time.Now()
 	c, err = cp.manager.CreateContainer()
+	created := time.Since(start)
+
+	cp.status.Lock()
+	cp.status.startingContainers--
+	cp.status.Unlock()
+
 	if err != nil {
-		log.Error(logErrorCreatingContainer, err, cp.logger)
+		log.ErrorEntry(logErrorCreatingContainer, err, cp.entry)
 
 		cp.monitor.WriteContainerCreated(1)
 		return c, err
@@ -191,16 +344,38 @@ func (cp *ContainerPool) createContainer() (c *cntr.Container, err error) {
 	if c == nil {
 		return c, errors.New(errorCreatedContainerCannotBeNil)
 	}
-	cp.logger.WithFields(logrus.Fields{logFieldContainerID: c.ExternalID}).Infof(logMsgCreatedContainer)
+	cp.entry.WithFields(logrus.Fields{logFieldContainerID: c.ExternalID}).Infof(logMsgCreatedContainer)
+	cp.monitor.WriteContainerCreateTime(created)
 
 	return c, nil
 }
 
+// writePoolStats writes the number of containers in the pool, and how many are being created for it, to the monitor.
+// The numbers are read under the lock but written outside it; should numbers read later already have been written
+// then these are out of date, and are not written, so that the latest numbers are always the last written.
+func (cp *ContainerPool) writePoolStats() {
+	cp.status.Lock()
+	cp.status.statsRead++
+	read := cp.status.statsRead
+	size, free, used, starting := len(cp.containers), len(cp.status.unusedContainers), len(cp.status.usedContainers),
+		cp.status.startingContainers
+	cp.status.Unlock()
+
+	cp.statsLock.Lock()
+	defer cp.statsLock.Unlock()
+
+	if read < cp.statsWritten {
+		return
+	}
+	cp.statsWritten = read
+	cp.monitor.WriteContainerPoolStats(size, free, used, starting)
+}
+
 // destroyContainer destroys the specified container, returning any error that occurred
 func (cp *ContainerPool) destroyContainer(c *cntr.Container) (err error) {
 	err = cp.manager.DestroyContainer(c.ExternalID)
 
-	cp.logger.WithFields(logrus.Fields{logFieldContainerID: c.ExternalID}).Infof(logMsgDestroyedContainer)
+	cp.entry.WithFields(logrus.Fields{logFieldContainerID: c.ExternalID}).Infof(logMsgDestroyedContainer)
 
 	cp.monitor.WriteContainerDestroyed(1)
 	return err
@@ -239,79 +414,89 @@ func getOldContainersNoLongerRequired(freePool, targetFreePool int) (numContaine
 // pool should it be required.
 func (cp *ContainerPool) scaleUpPoolIfRequired() (errors []error) {
 	amountToScale := 0
-	cp.status.RLock()
+	cp.status.Lock()
 	if cp.status.isScaling {
-		cp.logger.Debug(logMsgAlreadyScaling)
-		cp.status.RUnlock()
+		cp.entry.Debug(logMsgAlreadyScaling)
+		cp.status.Unlock()
 		return errors
 	}
 
 	cp.status.isScaling = true
 	amountToScale = getNewContainersRequired(len(cp.containers), cp.settings.MaximumSize, len(cp.status.unusedContainers), cp.settings.TargetFreeSize)
-	cp.logger.WithFields(logrus.Fields{
+	cp.entry.WithFields(logrus.Fields{
 		logFieldSizePool:              len(cp.containers),
 		logFieldMaxSizePool:           cp.settings.MaximumSize,
 		logFieldFreePool:              len(cp.status.unusedContainers),
 		logFieldTargetFreePool:        cp.settings.TargetFreeSize,
 		logFieldNewContainersRequired: amountToScale,
 	}).Debugf(logMsgNewContainersRequired)
-	cp.status.RUnlock()
+	cp.status.Unlock()
 
 	if amountToScale > 0 {
 		errors = cp.addContainersToPool(amountToScale)
 	}
 
-	cp.status.RLock()
+	cp.status.Lock()
 	cp.status.isScaling = false
-	cp.status.RUnlock()
+	cp.status.Unlock()
 
 	return errors
 }
 
 func (cp *ContainerPool) scaleDownPoolIfRequired() (errors []error) {
-	lastScaleDownTime := cp.status.lastScaleDown
-	nextScaleDownTime := lastScaleDownTime.Add(time.Duration(cp.settings.ScaleDownDelay) * time.Second)
-	currentTime := time.Now()
-
-	cp.logger.WithFields(logrus.Fields{
-		logFieldLastScaleDownTime: lastScaleDownTime,
-		logFieldNextScaleDownTime: nextScaleDownTime,
-		logFieldCurrentTime:       currentTime,
-	}).Debugf(logMsgScaleDownStatus)
-
-	// only consider scaling down if necessary
-	if (cp.status.lastScaleDown.IsZero()) || (currentTime.Before(nextScaleDownTime)) {
-		return nil
-	}
-
 	amountToScale := 0
-	cp.status.RLock()
+	cp.status.Lock()
 	{
-		if !cp.status.isScaling {
-			cp.status.isScaling = true
-			amountToScale = getOldContainersNoLongerRequired(len(cp.status.usedContainers), cp.settings.TargetFreeSize)
-			cp.logger.WithFields(logrus.Fields{
-				logFieldUsedPool:                 len(cp.status.usedContainers),
-				logFieldTargetFreePool:           cp.settings.TargetFreeSize,
-				logFieldOldContainersNotRequired: amountToScale,
-			}).Debugf(logMsgOldContainersNotRequired)
-
+		lastScaleDownTime := cp.status.lastScaleDown
+		nextScaleDownTime := lastScaleDownTime.Add(time.Duration(cp.settings.ScaleDownDelay) * time.Second)
+		currentTime := time.Now()
+
+		cp.entry.WithFields(logrus.Fields{
+			logFieldLastScaleDownTime: lastScaleDownTime,
+			logFieldNextScaleDownTime: nextScaleDownTime,
+			logFieldCurrentTime:       currentTime,
+		}).Debugf(logMsgScaleDownStatus)
+
+		// only consider scaling down if necessary, and if the pool is not already being scaled
+		if lastScaleDownTime.IsZero() || currentTime.Before(nextScaleDownTime) || cp.status.isScaling {
+			cp.status.Unlock()
+			return nil
 		}
+
+		cp.status.isScaling = true
+		amountToScale = getOldContainersNoLongerRequired(len(cp.status.usedContainers), cp.settings.TargetFreeSize)
+		cp.entry.WithFields(logrus.Fields{
+			logFieldUsedPool:                 len(cp.status.usedContainers),
+			logFieldTargetFreePool:           cp.settings.TargetFreeSize,
+			logFieldOldContainersNotRequired: amountToScale,
+		}).Debugf(logMsgOldContainersNotRequired)
 	}
-	cp.status.RUnlock()
+	cp.status.Unlock()
 
 	if amountToScale > 0 {
 		errors = cp.removeContainersFromPool(amountToScale)
-		cp.status.lastScaleDown = time.Now()
 	}
 
-	cp.status.RLock()
+	cp.status.Lock()
+	if amountToScale > 0 {
+		cp.status.lastScaleDown = time.Now()
+	}
 	cp.status.isScaling = false
-	cp.status.RUnlock()
+	cp.status.Unlock()
 
 	return errors
 }
 
+// isClientAllowed returns true if the client connection is allowed to use the pool as per Settings.AllowedClients
+func (cp *ContainerPool) isClientAllowed(conn net.Conn) bool {
+	if len(cp.settings.AllowedClients) == 0 {
+		return true
+	}
+
+	identity := cntr.IdentityOf(conn)
+	return (identity != nil) && identity.Matches(cp.settings.AllowedClients)
+}
+
 // AssociateClientWithContainer is called whenever a client connection is made requiring a container to
 // service it. This is essentially one of the 'core' function handling both associating connections with containers,
 // but also scaling the up pool when new connection requests are made.
@@ -319,12 +504,18 @@ func (cp *ContainerPool) AssociateClientWithContainer(conn net.Conn) (*cntr.Cont
 	var cID = ""
 	var c *cntr.Container
 
+	if !cp.isClientAllowed(conn) {
+		cp.monitor.WriteConnectionRejected(conn)
+		return nil, errors.New(errorClientNotAllowed)
+	}
+
 	cp.status.Lock()
 
 	// use a loop to simply get a single element in the map
 	for cID, c = range cp.status.unusedContainers {
 		// associate the connection with the container
 		c.ConnectionFromClient = conn
+		c.ResetSession(time.Now())
 
 		// add this container to the "used" map and remove from the "unused" map
 		cp.status.usedContainers[cID] = c
@@ -336,6 +527,7 @@ func (cp *ContainerPool) AssociateClientWithContainer(conn net.Conn) (*cntr.Cont
 	cp.status.Unlock()
 
 	if c != nil {
+		cp.writePoolStats()
 		cp.monitor.WriteConnectionAccepted(conn)
 		cp.scaleUpPoolIfRequired()
 		return c, nil
@@ -350,32 +542,164 @@ func (cp *ContainerPool) AssociateClientWithContainer(conn net.Conn) (*cntr.Cont
 // but also scaling the down pool when new connection requests are made.
 func (cp *ContainerPool) DissociateClientWithContainer(serverConn net.Conn, c *cntr.Container) {
 	if c == nil {
-		cp.logger.Warnf(logNilContainerToDisassociate)
+		cp.entry.Warnf(logNilContainerToDisassociate)
 		return
 	}
 
+	drained := false
 	cp.status.Lock()
 	{
 		c.ConnectionFromClient = nil
-
-		cp.status.unusedContainers[c.ExternalID] = c
+		c.ResetSession(time.Time{})
+
+		// only return the container to the pool if it is still part of it; it may have been destroyed whilst the
+		// client was connected. Draining containers are removed from the pool instead.
+		if _, inPool := cp.containers[c.ExternalID]; inPool {
+			if cp.status.drainingContainers[c.ExternalID] {
+				delete(cp.containers, c.ExternalID)
+				drained = true
+			} else {
+				cp.status.unusedContainers[c.ExternalID] = c
+			}
+		}
 		delete(cp.status.usedContainers, c.ExternalID)
+		delete(cp.status.drainingContainers, c.ExternalID)
 
 		cp.monitor.WriteConnectionPoolStats(serverConn, len(cp.status.usedContainers), len(cp.containers))
 	}
 	cp.status.Unlock()
+	cp.writePoolStats()
+
+	if drained {
+		cp.entry.WithFields(logrus.Fields{logFieldContainerID: c.ExternalID}).Info(logMsgDrainedContainer)
+		if err := cp.destroyContainer(c); err != nil {
+			log.ErrorEntry(logErrorDestroyingContainer, err, cp.entry)
+		}
+		for _, err := range cp.scaleUpPoolIfRequired() {
+			log.ErrorEntry(logErrorReplacingContainer, err, cp.entry)
+		}
+		return
+	}
 
 	cp.scaleDownPoolIfRequired()
 }
 
-// ConnectClientToContainer creates a TCP connection to the address + port of the specified container,
-// returning any errors that occurred
-func ConnectClientToContainer(c *cntr.Container) error {
-	conn, err := net.Dial("tcp", c.IPAddress+":"+strconv.Itoa(c.Port))
+// DestroyPool removes every container from the pool, whether in use or not, and destroys each of them using the
+// container manager. Once called, no further containers will be added to the pool. Any containers being destroyed or
+// created in the background following admin requests are waited for, so that none are left running once it returns.
+// Any errors that occurred whilst destroying the containers are returned.
+func (cp *ContainerPool) DestroyPool() (errors []error) {
+	var containersToDestroy []*cntr.Container
+
+	cp.status.Lock()
+	{
+		cp.status.isDestroyed = true
+
+		for cID, c := range cp.containers {
+			containersToDestroy = append(containersToDestroy, c)
+
+			delete(cp.containers, cID)
+			delete(cp.status.unusedContainers, cID)
+			delete(cp.status.usedContainers, cID)
+			delete(cp.status.drainingContainers, cID)
+		}
+	}
+	cp.status.Unlock()
+	cp.writePoolStats()
+
+	// containers created in the background from now on are destroyed rather than added to the pool
+	cp.background.Wait()
+
+	cp.entry.WithFields(logrus.Fields{
+		logFieldContainersToDestroy: len(containersToDestroy),
+	}).Info(logMsgDestroyingPool)
+
+	// as with removeContainersFromPool, these containers are no longer referenced from the pool so can be destroyed
+	// without a lock
+	for _, c := range containersToDestroy {
+		if e := cp.destroyContainer(c); e != nil {
+			errors = append(errors, e)
+		}
+	}
+
+	return errors
+}
+
+// containerFailed removes the specified container from the pool and destroys it, for example when it could not
+// complete a TLS handshake. The pool is then scaled up to replace it if required.
+func (cp *ContainerPool) containerFailed(c *cntr.Container, cause error) {
+	cp.status.Lock()
+	{
+		delete(cp.containers, c.ExternalID)
+		delete(cp.status.unusedContainers, c.ExternalID)
+		delete(cp.status.usedContainers, c.ExternalID)
+		delete(cp.status.drainingContainers, c.ExternalID)
+	}
+	cp.status.Unlock()
+	cp.writePoolStats()
+
+	cp.entry.WithFields(logrus.Fields{
+		logFieldContainerID: c.ExternalID,
+		logFieldError:       cause,
+	}).Warn(logMsgContainerFailed)
+	cp.monitor.WriteContainerFailed(1)
+
+	if err := cp.destroyContainer(c); err != nil {
+		log.ErrorEntry(logErrorDestroyingContainer, err, cp.entry)
+	}
+
+	for _, err := range cp.scaleUpPoolIfRequired() {
+		log.ErrorEntry(logErrorReplacingContainer, err, cp.entry)
+	}
+}
+
+// ConnectClientToContainer creates a TCP connection to the address + port of the specified container, returning
+// any errors that occurred. If port is non-zero then it is used instead of the port of the container. If the PROXY
+// protocol is enabled then a header describing the client connection is sent before anything else. If backend TLS is
+// enabled then a TLS handshake is performed over the connection; should this fail then the container is treated as
+// having failed and is removed from the pool. The connection must be established within backendConnectTimeout.
+func (cp *ContainerPool) ConnectClientToContainer(c *cntr.Container, port int) error {
+	if port == 0 {
+		port = c.Port
+	}
+
+	start := time.Now()
+	dialer := net.Dialer{Timeout: backendConnectTimeout}
+	conn, err := dialer.Dial("tcp", c.IPAddress+":"+strconv.Itoa(port))
 	if err != nil {
 		return err
 	}
+	cp.monitor.WriteBackendDialLatency(time.Since(start))
+
+	// the header and handshake must complete within what remains of the timeout
+	conn.SetDeadline(start.Add(backendConnectTimeout))
+
+	if cp.settings.ProxyProtocol != 0 && c.ConnectionFromClient != nil {
+		header := proxyproto.NewHeader(cp.settings.ProxyProtocol, c.ConnectionFromClient)
+		if _, err := header.WriteTo(conn); err != nil {
+			// we're not going to act on Close errors, so ignore purposefully
+			conn.Close()
+			return err
+		}
+	}
+
+	if cp.backendTLSConfig != nil {
+		config := cp.backendTLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = c.IPAddress
+		}
+
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			// we're not going to act on Close errors, so ignore purposefully
+			conn.Close()
+			cp.containerFailed(c, err)
+			return err
+		}
+		conn = tlsConn
+	}
 
+	conn.SetDeadline(time.Time{})
 	c.ConnectionToContainer = conn
 
 	return nil
@@ -7,8 +7,11 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"net"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const (
@@ -26,6 +29,20 @@ type (
 	TestCreateErrContainerManager struct{}
 
 	TestDestroyErrContainerManager struct{}
+
+	// testBlockingContainerManager only creates containers once created is closed, counting those still running
+	testBlockingContainerManager struct {
+		created chan struct{}
+		running *int32
+	}
+
+	// testStatsMonitor records the pool stats written to it, and whether the pool was locked whilst they were
+	testStatsMonitor struct {
+		monitor.NoOp
+		pool    *ContainerPool
+		written *[]int
+		locked  *bool
+	}
 )
 
 var (
@@ -69,6 +86,16 @@ func (cm TestIncrementContainerManager) DestroyContainer(externalID string) erro
 	return nil
 }
 
+func (cm testBlockingContainerManager) CreateContainer() (*cntr.Container, error) {
+	<-cm.created
+	return &cntr.Container{ExternalID: strconv.Itoa(int(atomic.AddInt32(cm.running, 1)))}, nil
+}
+
+func (cm testBlockingContainerManager) DestroyContainer(externalID string) error {
+	atomic.AddInt32(cm.running, -1)
+	return nil
+}
+
 func (cm TestCreateErrContainerManager) CreateContainer() (*cntr.Container, error) {
 	return nil, errors.New(errorInitialiseError)
 }
@@ -86,13 +113,22 @@ func (cm TestDestroyErrContainerManager) DestroyContainer(externalID string) err
 	return errors.New(errorDestroyContainer)
 }
 
+func (m testStatsMonitor) WriteContainerPoolStats(size, free, used, starting int) {
+	if m.pool.status.TryLock() {
+		m.pool.status.Unlock()
+	} else {
+		*m.locked = true
+	}
+	*m.written = append(*m.written, size)
+}
+
 func Test_CreateContainer(t *testing.T) {
 	logger, _ := test.NewNullLogger()
 	logger.Level = logrus.DebugLevel
 	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, logger)
 
 	tcm := Test42ContainerManager{}
-	cp, _ := CreateContainerPool(tcm, Settings{}, logger, *m)
+	cp, _ := CreateContainerPool(tcm, Settings{}, logger, m)
 
 	t.Run("EmptyPool", func(t *testing.T) {
 		cp.containers = make(map[string]*cntr.Container)
@@ -145,7 +181,7 @@ func Test_CreateContainer(t *testing.T) {
 		pool.containers[testContainer42.ExternalID] = testContainer42
 
 		tcm := TestNilContainerManager{}
-		cp, _ := CreateContainerPool(tcm, Settings{}, logger, *m)
+		cp, _ := CreateContainerPool(tcm, Settings{}, logger, m)
 		c, err := cp.createContainer()
 
 		assert.NotNil(t, err, "error expected")
@@ -171,7 +207,7 @@ func Test_DestroyContainer(t *testing.T) {
 		pool.containers[testContainer42.ExternalID] = testContainer42
 
 		tcm := TestDestroyErrContainerManager{}
-		cp, _ := CreateContainerPool(tcm, Settings{}, logger, *m)
+		cp, _ := CreateContainerPool(tcm, Settings{}, logger, m)
 		err := cp.destroyContainer(pool.containers[testContainer42.ExternalID])
 
 		assert.NotNil(t, err, "error expected")
@@ -188,26 +224,32 @@ func Test_CreateContainerPool(t *testing.T) {
 	s := Settings{}
 
 	t.Run("NilLogger", func(t *testing.T) {
-		cp, err := CreateContainerPool(tcm, s, nil, *m)
+		cp, err := CreateContainerPool(tcm, s, nil, m)
 		assert.Equal(t, errors.New(errorLoggerNil), err)
 		assert.Nil(t, cp)
 	})
 
 	t.Run("NilContainerManager", func(t *testing.T) {
-		cp, err := CreateContainerPool(nil, s, l, *m)
+		cp, err := CreateContainerPool(nil, s, l, m)
 		assert.Equal(t, errors.New(errorContainerManagerNil), err)
 		assert.Nil(t, cp)
 	})
 
 	t.Run("ValidCall", func(t *testing.T) {
-		cp, err := CreateContainerPool(tcm, s, l, *m)
+		cp, err := CreateContainerPool(tcm, s, l, m)
 		assert.Equal(t, l, cp.logger)
 		assert.Equal(t, s, cp.settings)
-		assert.Equal(t, m, &cp.monitor)
+		assert.Equal(t, m, cp.monitor)
 		assert.Equal(t, tcm, cp.manager)
 
 		assert.Nil(t, err)
 	})
+
+	t.Run("NilMonitor", func(t *testing.T) {
+		cp, err := CreateContainerPool(tcm, s, l, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, monitor.NoOp{}, cp.monitor)
+	})
 }
 
 func Test_InitialisePool(t *testing.T) {
@@ -218,7 +260,7 @@ func Test_InitialisePool(t *testing.T) {
 
 	t.Run("PoolSizeOf0", func(t *testing.T) {
 		s := Settings{InitialSize: 0, MaximumSize: 10}
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		err := cp.InitialisePool()
 		assert.Nil(t, err)
 		assert.Equal(t, 0, len(cp.containers))
@@ -226,7 +268,7 @@ func Test_InitialisePool(t *testing.T) {
 
 	t.Run("PoolSizeOf1", func(t *testing.T) {
 		s := Settings{InitialSize: 1, MaximumSize: 10}
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		err := cp.InitialisePool()
 		assert.Nil(t, err)
 		assert.Equal(t, 1, len(cp.containers))
@@ -235,7 +277,7 @@ func Test_InitialisePool(t *testing.T) {
 	t.Run("PoolSizeOf10", func(t *testing.T) {
 		h.Reset()
 		s := Settings{InitialSize: 10, MaximumSize: 10}
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		err := cp.InitialisePool()
 		assert.Nil(t, err)
 		assert.Equal(t, 10, len(cp.containers))
@@ -249,7 +291,7 @@ func Test_InitialisePool(t *testing.T) {
 	t.Run("ErrorCreatingContainer", func(t *testing.T) {
 		h.Reset()
 		s := Settings{InitialSize: 3, MaximumSize: 10}
-		cp, _ := CreateContainerPool(TestCreateErrContainerManager{}, s, l, *m)
+		cp, _ := CreateContainerPool(TestCreateErrContainerManager{}, s, l, m)
 		err := cp.InitialisePool()
 		assert.Equal(t, []error{errors.New(errorInitialiseError), errors.New(errorInitialiseError), errors.New(errorInitialiseError)}, err)
 		assert.Equal(t, 0, len(cp.containers))
@@ -342,7 +384,7 @@ func Test_AddContainersToPool(t *testing.T) {
 	s := Settings{InitialSize: 0, MaximumSize: 10}
 
 	t.Run("AddZeroContainers", func(t *testing.T) {
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		errors := cp.addContainersToPool(0)
 		assert.Nil(t, errors)
 		assert.Equal(t, 0, len(cp.containers))
@@ -351,7 +393,7 @@ func Test_AddContainersToPool(t *testing.T) {
 	})
 
 	t.Run("AddSingleContainer", func(t *testing.T) {
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		errors := cp.addContainersToPool(1)
 		assert.Nil(t, errors)
 		assert.Equal(t, 1, len(cp.containers))
@@ -360,7 +402,7 @@ func Test_AddContainersToPool(t *testing.T) {
 	})
 
 	t.Run("AddMultipleContainers", func(t *testing.T) {
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		errors := cp.addContainersToPool(9)
 		assert.Nil(t, errors)
 		assert.Equal(t, 9, len(cp.containers))
@@ -370,7 +412,7 @@ func Test_AddContainersToPool(t *testing.T) {
 
 	t.Run("AddMultipleCreateErroringContainers", func(t *testing.T) {
 		tcm := TestCreateErrContainerManager{}
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		errors := cp.addContainersToPool(9)
 		assert.NotNil(t, errors)
 		assert.Equal(t, 9, len(errors))
@@ -385,7 +427,7 @@ func Test_AddContainersToPool(t *testing.T) {
 	t.Run("AddMultipleDestroyErroringContainers", func(t *testing.T) {
 		tcm := TestDestroyErrContainerManager{}
 		s := Settings{InitialSize: 0, MaximumSize: 0}
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		errors := cp.addContainersToPool(9)
 		assert.NotNil(t, errors)
 		assert.Equal(t, 9, len(errors))
@@ -406,7 +448,7 @@ func Test_RemoveContainersFromPool(t *testing.T) {
 	s := Settings{InitialSize: 0, MaximumSize: 10}
 
 	t.Run("RemoveZeroContainers", func(t *testing.T) {
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 
 		// First add several containers and check they are created as expected
 		errors := cp.addContainersToPool(9)
@@ -423,7 +465,7 @@ func Test_RemoveContainersFromPool(t *testing.T) {
 	})
 
 	t.Run("RemoveSingleContainer", func(t *testing.T) {
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 
 		// First add several containers and check they are created as expected
 		errors := cp.addContainersToPool(9)
@@ -440,7 +482,7 @@ func Test_RemoveContainersFromPool(t *testing.T) {
 	})
 
 	t.Run("RemoveMultipleContainers", func(t *testing.T) {
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 
 		// First add several containers and check they are created as expected
 		errors := cp.addContainersToPool(9)
@@ -458,7 +500,7 @@ func Test_RemoveContainersFromPool(t *testing.T) {
 
 	t.Run("RemoveMultipleDestroyErroringContainers", func(t *testing.T) {
 		tcm := TestDestroyErrContainerManager{}
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 
 		// First add several containers and check they are created as expected
 		errors := cp.addContainersToPool(9)
@@ -480,7 +522,7 @@ func Test_RemoveContainersFromPool(t *testing.T) {
 
 	t.Run("RemoveSingleContainerFromUsed", func(t *testing.T) {
 		tcm := Test42ContainerManager{}
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 
 		// First add container and check it is created as expected
 		errors := cp.addContainersToPool(1)
@@ -510,7 +552,7 @@ func Test_scaleUpPoolIfRequired(t *testing.T) {
 
 	t.Run("AlreadyScaling", func(t *testing.T) {
 		h.Reset()
-		cp, _ := CreateContainerPool(tcm, s, l, *m)
+		cp, _ := CreateContainerPool(tcm, s, l, m)
 		cp.status.isScaling = true
 
 		assert.Equal(t, len(cp.containers), 0)
@@ -522,4 +564,143 @@ func Test_scaleUpPoolIfRequired(t *testing.T) {
 		assert.Equal(t, 1, len(h.AllEntries()))
 		assert.Contains(t, logMsgAlreadyScaling, h.LastEntry().Message)
 	})
-}
\ No newline at end of file
+}
+
+func Test_DestroyPool(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	l.Level = logrus.DebugLevel
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	tcm := TestIncrementContainerManager{}
+	s := Settings{InitialSize: 0, MaximumSize: 10}
+
+	t.Run("DestroyUsedAndUnusedContainers", func(t *testing.T) {
+		cp, _ := CreateContainerPool(tcm, s, l, m)
+		errors := cp.addContainersToPool(5)
+		assert.Nil(t, errors)
+
+		clientConn, _ := net.Pipe()
+		c, err := cp.AssociateClientWithContainer(clientConn)
+		assert.Nil(t, err)
+		assert.NotNil(t, c)
+
+		errors = cp.DestroyPool()
+		assert.Nil(t, errors)
+		assert.Equal(t, 0, len(cp.containers))
+		assert.Equal(t, 0, len(cp.status.usedContainers))
+		assert.Equal(t, 0, len(cp.status.unusedContainers))
+
+		// the container should not be returned to the pool once the client disconnects
+		cp.DissociateClientWithContainer(clientConn, c)
+		assert.Equal(t, 0, len(cp.containers))
+		assert.Equal(t, 0, len(cp.status.unusedContainers))
+	})
+
+	t.Run("NoContainersAddedAfterDestroy", func(t *testing.T) {
+		cp, _ := CreateContainerPool(tcm, s, l, m)
+		errors := cp.DestroyPool()
+		assert.Nil(t, errors)
+
+		errors = cp.addContainersToPool(3)
+		assert.Nil(t, errors)
+		assert.Equal(t, 0, len(cp.containers))
+		assert.Equal(t, 0, len(cp.status.unusedContainers))
+	})
+
+	t.Run("DestroyErroringContainers", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestDestroyErrContainerManager{}, s, l, m)
+		errors := cp.addContainersToPool(3)
+		assert.Nil(t, errors)
+
+		errors = cp.DestroyPool()
+		assert.Equal(t, 3, len(errors))
+		assert.Equal(t, 0, len(cp.containers))
+	})
+
+	t.Run("WaitForBackgroundScaling", func(t *testing.T) {
+		var running int32
+		cm := testBlockingContainerManager{created: make(chan struct{}), running: &running}
+		cp, _ := CreateContainerPool(cm, s, l, m)
+		assert.Nil(t, cp.Resize(10, 3))
+
+		// the pool is only destroyed once the containers being created in the background have been destroyed too
+		destroyed := make(chan []error, 1)
+		go func() {
+			destroyed <- cp.DestroyPool()
+		}()
+		select {
+		case <-destroyed:
+			t.Fatal("pool destroyed whilst containers were being created")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(cm.created)
+		assert.Nil(t, <-destroyed)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&running))
+		assert.Equal(t, errorPoolDestroyed, cp.Resize(10, 3).Error())
+	})
+}
+
+func Test_ReleaseAndAdoptContainers(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	tcm := TestIncrementContainerManager{}
+	s := Settings{InitialSize: 3, MaximumSize: 10}
+
+	t.Run("ReleaseOnlyUnusedContainers", func(t *testing.T) {
+		cp, _ := CreateContainerPool(tcm, s, l, m)
+		errors := cp.InitialisePool()
+		assert.Nil(t, errors)
+
+		clientConn, _ := net.Pipe()
+		c, err := cp.AssociateClientWithContainer(clientConn)
+		assert.Nil(t, err)
+
+		released := cp.ReleaseUnusedContainers()
+		assert.Equal(t, 2, len(released))
+		assert.Equal(t, 1, len(cp.containers))
+		assert.Equal(t, 0, len(cp.status.unusedContainers))
+		assert.Equal(t, c, cp.status.usedContainers[c.ExternalID])
+	})
+
+	t.Run("AdoptedContainersCountTowardsInitialSize", func(t *testing.T) {
+		cp, _ := CreateContainerPool(tcm, s, l, m)
+		adopted := cp.AdoptContainers([]*cntr.Container{{ExternalID: "a"}, {ExternalID: "b"}, {ExternalID: "a"}})
+		assert.Equal(t, 2, len(adopted))
+		assert.Equal(t, 2, len(cp.status.unusedContainers))
+
+		errors := cp.InitialisePool()
+		assert.Nil(t, errors)
+		assert.Equal(t, 3, len(cp.containers))
+		assert.NotNil(t, cp.containers["a"])
+		assert.NotNil(t, cp.containers["b"])
+	})
+
+	t.Run("NoContainersAdoptedAfterDestroy", func(t *testing.T) {
+		cp, _ := CreateContainerPool(tcm, s, l, m)
+		cp.DestroyPool()
+
+		adopted := cp.AdoptContainers([]*cntr.Container{{ExternalID: "a"}})
+		assert.Equal(t, 0, len(adopted))
+		assert.Equal(t, 0, len(cp.containers))
+	})
+}
+
+func Test_WritePoolStats(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	cp, err := CreateContainerPool(TestIncrementContainerManager{}, Settings{MaximumSize: 10}, l, nil)
+	assert.Nil(t, err)
+
+	var written []int
+	var locked bool
+	cp.monitor = testStatsMonitor{pool: cp, written: &written, locked: &locked}
+
+	assert.Nil(t, cp.addContainersToPool(2))
+	assert.Equal(t, 2, written[len(written)-1])
+	assert.False(t, locked)
+
+	// numbers read before the last written are out of date, so are not written
+	count := len(written)
+	cp.statsWritten = cp.status.statsRead + 2
+	cp.writePoolStats()
+	assert.Equal(t, count, len(written))
+}
@@ -0,0 +1,49 @@
+package cntrpool
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
+)
+
+const (
+	errorBelowInitialSize   = "pool has %d of its initial %d containers"
+	errorBelowReadyFreeSize = "pool has %d free containers; %d are required"
+)
+
+// Ready returns nil if the pool is able to serve clients, or an error describing why it is not. The pool is ready
+// once it has ReadyFreeSize free containers, or if this is 0, once it has first reached InitialSize.
+func (cp *ContainerPool) Ready() error {
+	cp.status.Lock()
+	defer cp.status.Unlock()
+
+	if cp.status.isDestroyed {
+		return errors.New(errorPoolDestroyed)
+	}
+
+	if cp.settings.ReadyFreeSize > 0 {
+		if free := len(cp.status.unusedContainers); free < cp.settings.ReadyFreeSize {
+			return fmt.Errorf(errorBelowReadyFreeSize, free, cp.settings.ReadyFreeSize)
+		}
+		return nil
+	}
+
+	if !cp.status.hasReachedInitialSize {
+		if size := len(cp.containers); size < cp.settings.InitialSize {
+			return fmt.Errorf(errorBelowInitialSize, size, cp.settings.InitialSize)
+		}
+		cp.status.hasReachedInitialSize = true
+	}
+
+	return nil
+}
+
+// CheckManager returns nil if the container manager of the pool is able to create containers, or the error which
+// prevents it from doing so. Only managers implementing cntrmgr.HealthChecker are checked.
+func (cp *ContainerPool) CheckManager() error {
+	if hc, ok := cp.manager.(cntrmgr.HealthChecker); ok {
+		return hc.CheckHealth()
+	}
+
+	return nil
+}
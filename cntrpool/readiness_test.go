@@ -0,0 +1,78 @@
+package cntrpool
+
+import (
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+type (
+	// TestUnreachableContainerManager creates containers as per TestIncrementContainerManager, but always fails its
+	// health check
+	TestUnreachableContainerManager struct {
+		TestIncrementContainerManager
+	}
+)
+
+func (cm TestUnreachableContainerManager) CheckHealth() error {
+	return errors.New("unreachable")
+}
+
+func Test_Ready(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+
+	t.Run("InitialSize", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, Settings{InitialSize: 2, MaximumSize: 4}, l, m)
+		assert.Equal(t, "pool has 0 of its initial 2 containers", cp.Ready().Error())
+
+		cp.AdoptContainers([]*cntr.Container{{ExternalID: "a"}, {ExternalID: "b"}})
+		assert.Nil(t, cp.Ready())
+
+		// the pool remains ready once it has been scaled down below its initial size
+		assert.Nil(t, cp.RemoveContainer("a"))
+		assert.Nil(t, cp.Ready())
+	})
+
+	t.Run("ReadyFreeSize", func(t *testing.T) {
+		s := Settings{InitialSize: 0, MaximumSize: 4, ReadyFreeSize: 1}
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		cp.AdoptContainers([]*cntr.Container{{ExternalID: "a"}})
+		assert.Nil(t, cp.Ready())
+
+		serverConn, clientConn := net.Pipe()
+		defer clientConn.Close()
+		c, err := cp.AssociateClientWithContainer(serverConn)
+		assert.Nil(t, err)
+		assert.Equal(t, "pool has 0 free containers; 1 are required", cp.Ready().Error())
+
+		cp.DissociateClientWithContainer(serverConn, c)
+		assert.Nil(t, cp.Ready())
+	})
+
+	t.Run("Destroyed", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, Settings{MaximumSize: 4}, l, m)
+		cp.DestroyPool()
+		assert.Equal(t, errorPoolDestroyed, cp.Ready().Error())
+	})
+}
+
+func Test_CheckManager(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	s := Settings{MaximumSize: 4}
+
+	t.Run("NotHealthChecker", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+		assert.Nil(t, cp.CheckManager())
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		cp, _ := CreateContainerPool(TestUnreachableContainerManager{}, s, l, m)
+		assert.Equal(t, "unreachable", cp.CheckManager().Error())
+	})
+}
@@ -0,0 +1,155 @@
+package cntrpool
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// SnapshotVersion is the version of the Snapshot structure; it is incremented whenever a field is removed or its
+	// meaning changed, but not when fields are added
+	SnapshotVersion = 1
+)
+
+type (
+	// Snapshot is a consistent view of the state of a container pool at a point in time. It is encoded as JSON using
+	// the field names as keys, for example:
+	//
+	//	{
+	//	  "Version": 1,
+	//	  "Name": "default",
+	//	  "Time": "2020-05-01T12:00:00Z",
+	//	  "Settings": {"InitialSize": 2, "MaximumSize": 4, "TargetFreeSize": 1, "ScaleDownDelay": 1, ...},
+	//	  "Containers": [
+	//	    {"ID": "a1", "IPAddress": "10.0.0.1", "Port": 8080, "StartTime": "...", "Busy": true,
+	//	     "Draining": false, "ClientAddress": "192.0.2.1:50000", "SessionStart": "...", "BytesTransferred": 1024},
+	//	    {"ID": "a2", "IPAddress": "10.0.0.2", "Port": 8080, "StartTime": "...", "Busy": false, "Draining": false,
+	//	     "BytesTransferred": 0}
+	//	  ],
+	//	  "Totals": {"Containers": 2, "Busy": 1, "Free": 1, "BytesTransferred": 1024}
+	//	}
+	Snapshot struct {
+		// Version is always SnapshotVersion
+		Version int
+		Name    string
+		// Time is when the snapshot was taken
+		Time time.Time
+
+		Settings SnapshotSettings
+		// Containers holds every container in the pool, ordered by ID
+		Containers []ContainerSnapshot
+		Totals     SnapshotTotals
+
+		// Scaling is true whilst containers are being added to or removed from the pool, and Destroyed once the pool
+		// has been destroyed
+		Scaling   bool
+		Destroyed bool
+	}
+
+	// SnapshotSettings holds the settings of the pool which determine how it is scaled and connected to
+	SnapshotSettings struct {
+		InitialSize    int
+		MaximumSize    int
+		TargetFreeSize int
+		ScaleDownDelay int
+		BackendTLS     bool
+		ProxyProtocol  int
+		AllowedClients []string
+		ReadyFreeSize  int
+	}
+
+	// ContainerSnapshot holds the state of a single container. ClientAddress and SessionStart are omitted unless the
+	// container is Busy; BytesTransferred is the total in both directions during the current session. Draining is
+	// true if the container will be removed from the pool once its client disconnects.
+	ContainerSnapshot struct {
+		ID               string
+		IPAddress        string
+		Port             int
+		StartTime        time.Time
+		Busy             bool
+		Draining         bool
+		ClientAddress    string     `json:",omitempty"`
+		SessionStart     *time.Time `json:",omitempty"`
+		BytesTransferred int64
+	}
+
+	// SnapshotTotals summarises the containers in the pool
+	SnapshotTotals struct {
+		Containers       int
+		Busy             int
+		Free             int
+		BytesTransferred int64
+	}
+)
+
+// Snapshot returns the current state of the pool. The state is read under the status lock, so that the containers
+// and totals are consistent with one another.
+func (cp *ContainerPool) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Version:    SnapshotVersion,
+		Name:       cp.settings.Name,
+		Time:       time.Now(),
+		Containers: []ContainerSnapshot{},
+	}
+
+	cp.status.RLock()
+	{
+		// the sizes of the pool may be changed by Resize, so are read under the lock
+		s := cp.settings
+		snapshot.Settings = SnapshotSettings{
+			InitialSize:    s.InitialSize,
+			MaximumSize:    s.MaximumSize,
+			TargetFreeSize: s.TargetFreeSize,
+			ScaleDownDelay: s.ScaleDownDelay,
+			BackendTLS:     s.BackendTLS.Enabled,
+			ProxyProtocol:  s.ProxyProtocol,
+			AllowedClients: s.AllowedClients,
+			ReadyFreeSize:  s.ReadyFreeSize,
+		}
+		snapshot.Scaling = cp.status.isScaling
+		snapshot.Destroyed = cp.status.isDestroyed
+
+		for _, c := range cp.status.usedContainers {
+			cs := ContainerSnapshot{
+				ID:               c.ExternalID,
+				IPAddress:        c.IPAddress,
+				Port:             c.Port,
+				StartTime:        c.StartTime,
+				Busy:             true,
+				Draining:         cp.status.drainingContainers[c.ExternalID],
+				BytesTransferred: c.BytesTransferred(),
+			}
+			if c.ConnectionFromClient != nil {
+				cs.ClientAddress = c.ConnectionFromClient.RemoteAddr().String()
+			}
+			if !c.SessionStart.IsZero() {
+				start := c.SessionStart
+				cs.SessionStart = &start
+			}
+			snapshot.Containers = append(snapshot.Containers, cs)
+		}
+
+		for _, c := range cp.status.unusedContainers {
+			snapshot.Containers = append(snapshot.Containers, ContainerSnapshot{
+				ID:        c.ExternalID,
+				IPAddress: c.IPAddress,
+				Port:      c.Port,
+				StartTime: c.StartTime,
+			})
+		}
+
+		snapshot.Totals.Busy = len(cp.status.usedContainers)
+		snapshot.Totals.Free = len(cp.status.unusedContainers)
+	}
+	cp.status.RUnlock()
+
+	sort.Slice(snapshot.Containers, func(i, j int) bool {
+		return snapshot.Containers[i].ID < snapshot.Containers[j].ID
+	})
+	snapshot.Totals.Containers = len(snapshot.Containers)
+	for _, cs := range snapshot.Containers {
+		snapshot.Totals.BytesTransferred += cs.BytesTransferred
+	}
+
+	return snapshot
+}
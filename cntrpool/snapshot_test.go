@@ -0,0 +1,78 @@
+package cntrpool
+
+import (
+	"encoding/json"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func Test_Snapshot(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	m := monitor.CreateMonitor(monitor.Settings{Address: "something"}, l)
+	s := Settings{Name: "alpha", InitialSize: 2, MaximumSize: 2, TargetFreeSize: 1, ProxyProtocol: 2}
+
+	cp, _ := CreateContainerPool(TestIncrementContainerManager{}, s, l, m)
+	cp.AdoptContainers([]*cntr.Container{{ExternalID: "b", IPAddress: "10.0.0.2", Port: 8080}, {ExternalID: "a"}})
+
+	t.Run("AllFree", func(t *testing.T) {
+		snapshot := cp.Snapshot()
+		assert.Equal(t, SnapshotVersion, snapshot.Version)
+		assert.Equal(t, "alpha", snapshot.Name)
+		assert.Equal(t, SnapshotSettings{InitialSize: 2, MaximumSize: 2, TargetFreeSize: 1, ProxyProtocol: 2}, snapshot.Settings)
+		assert.Equal(t, SnapshotTotals{Containers: 2, Free: 2}, snapshot.Totals)
+
+		// containers are ordered by ID
+		assert.Equal(t, "a", snapshot.Containers[0].ID)
+		assert.Equal(t, ContainerSnapshot{ID: "b", IPAddress: "10.0.0.2", Port: 8080}, snapshot.Containers[1])
+	})
+
+	t.Run("Busy", func(t *testing.T) {
+		serverConn, clientConn := net.Pipe()
+		defer serverConn.Close()
+		defer clientConn.Close()
+
+		c, err := cp.AssociateClientWithContainer(serverConn)
+		assert.Nil(t, err)
+		c.AddBytesTransferred(100)
+		c.AddBytesTransferred(28)
+
+		snapshot := cp.Snapshot()
+		assert.Equal(t, SnapshotTotals{Containers: 2, Busy: 1, Free: 1, BytesTransferred: 128}, snapshot.Totals)
+		for _, cs := range snapshot.Containers {
+			if cs.ID != c.ExternalID {
+				continue
+			}
+			assert.True(t, cs.Busy)
+			assert.Equal(t, serverConn.RemoteAddr().String(), cs.ClientAddress)
+			assert.Equal(t, c.SessionStart, *cs.SessionStart)
+			assert.Equal(t, int64(128), cs.BytesTransferred)
+		}
+
+		// the session is cleared once the client is dissociated
+		cp.DissociateClientWithContainer(serverConn, c)
+		snapshot = cp.Snapshot()
+		assert.Equal(t, SnapshotTotals{Containers: 2, Free: 2}, snapshot.Totals)
+		assert.True(t, c.SessionStart.IsZero())
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(cp.Snapshot())
+		assert.Nil(t, err)
+
+		var decoded map[string]interface{}
+		assert.Nil(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, float64(SnapshotVersion), decoded["Version"])
+		containers := decoded["Containers"].([]interface{})
+		assert.Equal(t, 2, len(containers))
+
+		// the client details of free containers are omitted
+		_, hasClient := containers[0].(map[string]interface{})["ClientAddress"]
+		assert.False(t, hasClient)
+		_, hasSession := containers[0].(map[string]interface{})["SessionStart"]
+		assert.False(t, hasSession)
+	})
+}
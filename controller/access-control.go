@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cidr"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAccessControlReloadInterval is how often access control files are checked for changes, unless set
+	defaultAccessControlReloadInterval = 10 * time.Second
+
+	logMsgConnectionDenied         = "connection denied by access control"
+	logMsgAccessControlLoaded      = "access control lists loaded"
+	logErrorReloadingAccessControl = "Error reloading access control list; continuing to use the previous list"
+
+	logFieldAllowEntries = "allow-entries"
+	logFieldDenyEntries  = "deny-entries"
+	logFieldFile         = "file"
+)
+
+type (
+	// accessControl decides whether clients may connect based on the allow and deny lists in the
+	// AccessControlSettings, reloading the lists from their files when these change
+	accessControl struct {
+		settings application.AccessControlSettings
+		logger   *logrus.Entry
+
+		lock  sync.RWMutex
+		allow cidr.List
+		deny  cidr.List
+
+		// modTimes holds the modification time of each file when it was last loaded; it is only used by watch
+		modTimes map[string]time.Time
+		stop     chan struct{}
+		stopOnce sync.Once
+	}
+)
+
+// newAccessControl creates the access control from the settings provided, loading any files, and returns nil if
+// there are no lists configured. If any list is invalid then an error is returned.
+func newAccessControl(settings application.AccessControlSettings, logger *logrus.Entry) (*accessControl, error) {
+	if len(settings.Allow) == 0 && len(settings.Deny) == 0 && settings.AllowFile == "" && settings.DenyFile == "" {
+		return nil, nil
+	}
+
+	ac := &accessControl{
+		settings: settings,
+		logger:   logger,
+		modTimes: make(map[string]time.Time),
+		stop:     make(chan struct{}),
+	}
+
+	allow, err := ac.loadList(settings.Allow, settings.AllowFile)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := ac.loadList(settings.Deny, settings.DenyFile)
+	if err != nil {
+		return nil, err
+	}
+	ac.setLists(allow, deny)
+
+	return ac, nil
+}
+
+// loadList combines the inline entries with those in the file, if there is one
+func (ac *accessControl) loadList(entries []string, file string) (cidr.List, error) {
+	list, err := cidr.Parse(entries)
+	if err != nil || file == "" {
+		return list, err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	fileList, err := cidr.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	ac.modTimes[file] = info.ModTime()
+
+	return append(list, fileList...), nil
+}
+
+// setLists replaces the lists currently in use
+func (ac *accessControl) setLists(allow, deny cidr.List) {
+	ac.lock.Lock()
+	ac.allow, ac.deny = allow, deny
+	ac.lock.Unlock()
+
+	ac.logger.WithFields(logrus.Fields{
+		logFieldAllowEntries: len(allow),
+		logFieldDenyEntries:  len(deny),
+	}).Info(logMsgAccessControlLoaded)
+}
+
+// allowed returns whether a client with the address provided may connect; all addresses are allowed when ac is nil.
+// Addresses without an IP address, such as those of UNIX sockets, cannot be on either list, so are denied if there is
+// an allow list and allowed otherwise.
+func (ac *accessControl) allowed(addr net.Addr) bool {
+	if ac == nil {
+		return true
+	}
+
+	ac.lock.RLock()
+	defer ac.lock.RUnlock()
+
+	ip := cidr.IP(addr)
+	if ip == nil {
+		return !ac.hasAllowList()
+	}
+	if ac.deny.Contains(ip) {
+		return false
+	}
+	if ac.hasAllowList() && !ac.allow.Contains(ip) {
+		return false
+	}
+
+	return true
+}
+
+// hasAllowList returns true if an allow list has been configured, even if it is currently empty, so that emptying an
+// allow list file rejects everything rather than allowing everything
+func (ac *accessControl) hasAllowList() bool {
+	return len(ac.settings.Allow) > 0 || ac.settings.AllowFile != ""
+}
+
+// changed returns true if any file has been modified since it was last loaded
+func (ac *accessControl) changed() bool {
+	for _, file := range []string{ac.settings.AllowFile, ac.settings.DenyFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(ac.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reload loads the lists again should any file have changed. If a list cannot be loaded then the previous lists
+// continue to be used.
+func (ac *accessControl) reload() {
+	if !ac.changed() {
+		return
+	}
+
+	allow, err := ac.loadList(ac.settings.Allow, ac.settings.AllowFile)
+	if err != nil {
+		log.ErrorEntry(logErrorReloadingAccessControl, err, ac.logger.WithField(logFieldFile, ac.settings.AllowFile))
+		return
+	}
+	deny, err := ac.loadList(ac.settings.Deny, ac.settings.DenyFile)
+	if err != nil {
+		log.ErrorEntry(logErrorReloadingAccessControl, err, ac.logger.WithField(logFieldFile, ac.settings.DenyFile))
+		return
+	}
+	ac.setLists(allow, deny)
+}
+
+// watch periodically reloads the lists from their files until close is called; it returns immediately if there are
+// no files to watch
+func (ac *accessControl) watch() {
+	if ac == nil || (ac.settings.AllowFile == "" && ac.settings.DenyFile == "") {
+		return
+	}
+
+	interval := time.Duration(ac.settings.ReloadIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = defaultAccessControlReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ac.stop:
+			return
+		case <-ticker.C:
+			ac.reload()
+		}
+	}
+}
+
+// close stops the lists from being reloaded
+func (ac *accessControl) close() {
+	if ac == nil {
+		return
+	}
+
+	ac.stopOnce.Do(func() { close(ac.stop) })
+}
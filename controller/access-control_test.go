@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_AccessControl(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	logger := logrus.NewEntry(l)
+	addr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1000}
+	}
+
+	t.Run("NoLists", func(t *testing.T) {
+		ac, err := newAccessControl(application.AccessControlSettings{}, logger)
+		assert.Nil(t, err)
+		assert.Nil(t, ac)
+		assert.True(t, ac.allowed(addr("192.0.2.1")))
+	})
+
+	t.Run("InlineLists", func(t *testing.T) {
+		ac, err := newAccessControl(application.AccessControlSettings{
+			Allow: []string{"192.0.2.0/24", "2001:db8::/32"},
+			Deny:  []string{"192.0.2.66", "2001:db8:bad::/48"},
+		}, logger)
+		assert.Nil(t, err)
+
+		assert.True(t, ac.allowed(addr("192.0.2.1")))
+		assert.False(t, ac.allowed(addr("192.0.2.66")))
+		assert.False(t, ac.allowed(addr("198.51.100.1")))
+		assert.True(t, ac.allowed(addr("2001:db8::1")))
+		assert.False(t, ac.allowed(addr("2001:db8:bad::1")))
+		// addresses without an IP cannot be on the allow list, so are denied
+		assert.False(t, ac.allowed(&net.UnixAddr{Name: "/tmp/socket"}))
+	})
+
+	t.Run("DenyOnly", func(t *testing.T) {
+		ac, err := newAccessControl(application.AccessControlSettings{Deny: []string{"192.0.2.0/24"}}, logger)
+		assert.Nil(t, err)
+
+		assert.False(t, ac.allowed(addr("192.0.2.1")))
+		assert.True(t, ac.allowed(addr("198.51.100.1")))
+		assert.True(t, ac.allowed(&net.UnixAddr{Name: "/tmp/socket"}))
+	})
+
+	t.Run("InvalidList", func(t *testing.T) {
+		_, err := newAccessControl(application.AccessControlSettings{Deny: []string{"192.0.2.0/33"}}, logger)
+		assert.NotNil(t, err)
+
+		_, err = newAccessControl(application.AccessControlSettings{AllowFile: "/does/not/exist"}, logger)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("FileReload", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "access-control")
+		defer os.RemoveAll(dir)
+		denyFile := filepath.Join(dir, "deny.txt")
+		ioutil.WriteFile(denyFile, []byte("192.0.2.1\n"), 0600)
+
+		ac, err := newAccessControl(application.AccessControlSettings{
+			Deny:     []string{"198.51.100.1"},
+			DenyFile: denyFile,
+		}, logger)
+		assert.Nil(t, err)
+		assert.False(t, ac.allowed(addr("192.0.2.1")))
+		assert.False(t, ac.allowed(addr("198.51.100.1")))
+		assert.True(t, ac.allowed(addr("192.0.2.2")))
+
+		// an unchanged file is not reloaded
+		assert.False(t, ac.changed())
+
+		ioutil.WriteFile(denyFile, []byte("192.0.2.2\n"), 0600)
+		os.Chtimes(denyFile, time.Now(), time.Now().Add(time.Minute))
+		ac.reload()
+		assert.True(t, ac.allowed(addr("192.0.2.1")))
+		assert.False(t, ac.allowed(addr("192.0.2.2")))
+		assert.False(t, ac.allowed(addr("198.51.100.1")))
+
+		// an invalid file leaves the previous list in place
+		ioutil.WriteFile(denyFile, []byte("not-an-address\n"), 0600)
+		os.Chtimes(denyFile, time.Now(), time.Now().Add(2*time.Minute))
+		ac.reload()
+		assert.False(t, ac.allowed(addr("192.0.2.2")))
+	})
+}
+
+func Test_AccessControlListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+	certFile, keyFile := pki.issueFiles("server", true)
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		AccessControl: application.AccessControlSettings{Deny: []string{"127.0.0.0/8"}},
+	})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	// the connection is closed without the server taking part in a TLS handshake
+	conn, err := net.Dial("tcp", addr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	data, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(data))
+}
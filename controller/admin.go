@@ -0,0 +1,261 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const (
+	// TerminationAdmin is recorded when a session is closed using the admin API
+	TerminationAdmin = "admin"
+
+	// adminHealthCheckTimeout is the time allowed to connect to all of the containers when checking their health
+	adminHealthCheckTimeout = 5 * time.Second
+
+	urlPoolContainers = "/pools/{pool}/containers"
+	urlContainer      = "/pools/{pool}/containers/{container}"
+	urlDrainContainer = "/pools/{pool}/containers/{container}/drain"
+	urlSession        = "/pools/{pool}/containers/{container}/session"
+	urlPoolSize       = "/pools/{pool}/size"
+	urlHealthCheck    = "/pools/{pool}/health-check"
+
+	urlVarPool      = "pool"
+	urlVarContainer = "container"
+
+	logMsgAdminRequest = "admin request"
+
+	logFieldContainerID = "container-id"
+	logFieldOperation   = "operation"
+
+	logCannotEncodeResponse = "Cannot JSON encode response"
+
+	errorUnknownPool      = "unknown pool: "
+	errorUnknownContainer = "unknown container: "
+	errorNoSession        = "container has no client session: "
+	errorInvalidBody      = "invalid request body: "
+	errorNotFound         = "not found"
+	errorMethodNotAllowed = "method not allowed"
+)
+
+type (
+	// adminError is the JSON body of every error response
+	adminError struct {
+		Error string
+	}
+
+	// poolSizeRequest is the JSON body used to resize a pool; sizes which are omitted are left unchanged
+	poolSizeRequest struct {
+		MaximumSize    *int
+		TargetFreeSize *int
+	}
+)
+
+// addAdminRoutes adds the admin API to the router provided, authenticating requests using the auth provided. Every
+// response is JSON, including errors, which are an object with a single Error field:
+//
+//	GET    /pools/{pool}/containers                    lists the containers in the pool
+//	POST   /pools/{pool}/containers/{container}/drain  drains the container, removing it from the pool straight away
+//	                                                   if free, otherwise once its client disconnects
+//	DELETE /pools/{pool}/containers/{container}        disconnects any client and removes the container from the pool
+//	DELETE /pools/{pool}/containers/{container}/session
+//	                                                   disconnects the client of the container
+//	PUT    /pools/{pool}/size                          changes the MaximumSize and/or TargetFreeSize of the pool
+//	POST   /pools/{pool}/health-check                  checks that each free container can be connected to
+//
+// Removed containers are destroyed, and the pool scaled to match its settings, in the background, so draining,
+// destroying and resizing respond with 202. Listing containers requires the read-only role, and everything else the
+// admin role.
+func (ctx *Context) addAdminRoutes(r *mux.Router, a *statisticsAuth) {
+	r.HandleFunc(urlPoolContainers, ctx.requireRole(a, roleReadOnly, ctx.handleListContainers)).Methods(http.MethodGet)
+	r.HandleFunc(urlDrainContainer, ctx.requireRole(a, roleAdmin, ctx.handleDrainContainer)).Methods(http.MethodPost)
+	r.HandleFunc(urlContainer, ctx.requireRole(a, roleAdmin, ctx.handleDestroyContainer)).Methods(http.MethodDelete)
+	r.HandleFunc(urlSession, ctx.requireRole(a, roleAdmin, ctx.handleDisconnectSession)).Methods(http.MethodDelete)
+	r.HandleFunc(urlPoolSize, ctx.requireRole(a, roleAdmin, ctx.handleResizePool)).Methods(http.MethodPut)
+	r.HandleFunc(urlHealthCheck, ctx.requireRole(a, roleAdmin, ctx.handleHealthCheck)).Methods(http.MethodPost)
+
+	r.NotFoundHandler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx.writeAdminError(writer, http.StatusNotFound, errors.New(errorNotFound))
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx.writeAdminError(writer, http.StatusMethodNotAllowed, errors.New(errorMethodNotAllowed))
+	})
+}
+
+// writeAdminResponse writes the value provided as the JSON body of the response, with the status provided
+func (ctx *Context) writeAdminResponse(writer http.ResponseWriter, status int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		log.Error(logCannotEncodeResponse, err, ctx.Logger)
+	}
+}
+
+// writeAdminError writes the error provided as the JSON body of the response, with the status provided
+func (ctx *Context) writeAdminError(writer http.ResponseWriter, status int, err error) {
+	ctx.writeAdminResponse(writer, status, adminError{Error: err.Error()})
+}
+
+// adminPool returns the pool named in the request, writing an error response and returning nil if there is none
+func (ctx *Context) adminPool(writer http.ResponseWriter, request *http.Request) *cntrpool.ContainerPool {
+	name := mux.Vars(request)[urlVarPool]
+
+	ctx.lock.Lock()
+	pool := ctx.ContainerPools[name]
+	ctx.lock.Unlock()
+
+	if pool == nil {
+		ctx.writeAdminError(writer, http.StatusNotFound, errors.New(errorUnknownPool+name))
+	}
+
+	return pool
+}
+
+// adminContainer returns the pool and container named in the request, writing an error response and returning nil
+// if either does not exist
+func (ctx *Context) adminContainer(writer http.ResponseWriter, request *http.Request) (*cntrpool.ContainerPool, *cntr.Container) {
+	pool := ctx.adminPool(writer, request)
+	if pool == nil {
+		return nil, nil
+	}
+
+	id := mux.Vars(request)[urlVarContainer]
+	c := pool.Container(id)
+	if c == nil {
+		ctx.writeAdminError(writer, http.StatusNotFound, errors.New(errorUnknownContainer+id))
+		return nil, nil
+	}
+
+	return pool, c
+}
+
+// logAdminRequest logs the admin operation requested on the pool provided
+func (ctx *Context) logAdminRequest(request *http.Request, operation string) {
+	vars := mux.Vars(request)
+	ctx.Logger.WithFields(logrus.Fields{
+		logFieldOperation:   operation,
+		logFieldPool:        vars[urlVarPool],
+		logFieldContainerID: vars[urlVarContainer],
+	}).Info(logMsgAdminRequest)
+}
+
+// containerSession returns the session which has been assigned the container provided, or nil if there is none
+func (ctx *Context) containerSession(c *cntr.Container) *session {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	for _, sess := range ctx.sessions {
+		if sess.container == c {
+			return sess
+		}
+	}
+
+	return nil
+}
+
+func (ctx *Context) handleListContainers(writer http.ResponseWriter, request *http.Request) {
+	if pool := ctx.adminPool(writer, request); pool != nil {
+		ctx.writeAdminResponse(writer, http.StatusOK, pool.Snapshot().Containers)
+	}
+}
+
+func (ctx *Context) handleDrainContainer(writer http.ResponseWriter, request *http.Request) {
+	pool, c := ctx.adminContainer(writer, request)
+	if c == nil {
+		return
+	}
+	ctx.logAdminRequest(request, "drain")
+
+	switch _, err := pool.DrainContainer(c.ExternalID); {
+	case err == cntrpool.ErrContainerNotFound:
+		ctx.writeAdminError(writer, http.StatusNotFound, errors.New(errorUnknownContainer+c.ExternalID))
+	case err != nil:
+		ctx.writeAdminError(writer, http.StatusInternalServerError, err)
+	default:
+		writer.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (ctx *Context) handleDestroyContainer(writer http.ResponseWriter, request *http.Request) {
+	pool, c := ctx.adminContainer(writer, request)
+	if c == nil {
+		return
+	}
+	ctx.logAdminRequest(request, "destroy")
+
+	if sess := ctx.containerSession(c); sess != nil {
+		ctx.terminateSession(sess, TerminationAdmin)
+	}
+
+	switch err := pool.RemoveContainer(c.ExternalID); {
+	case err == cntrpool.ErrContainerNotFound:
+		ctx.writeAdminError(writer, http.StatusNotFound, errors.New(errorUnknownContainer+c.ExternalID))
+	case err != nil:
+		ctx.writeAdminError(writer, http.StatusInternalServerError, err)
+	default:
+		writer.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (ctx *Context) handleDisconnectSession(writer http.ResponseWriter, request *http.Request) {
+	_, c := ctx.adminContainer(writer, request)
+	if c == nil {
+		return
+	}
+
+	sess := ctx.containerSession(c)
+	if sess == nil {
+		ctx.writeAdminError(writer, http.StatusNotFound, errors.New(errorNoSession+c.ExternalID))
+		return
+	}
+	ctx.logAdminRequest(request, "disconnect")
+
+	ctx.terminateSession(sess, TerminationAdmin)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (ctx *Context) handleResizePool(writer http.ResponseWriter, request *http.Request) {
+	pool := ctx.adminPool(writer, request)
+	if pool == nil {
+		return
+	}
+
+	var size poolSizeRequest
+	if err := json.NewDecoder(request.Body).Decode(&size); err != nil {
+		ctx.writeAdminError(writer, http.StatusBadRequest, errors.New(errorInvalidBody+err.Error()))
+		return
+	}
+	ctx.logAdminRequest(request, "resize")
+
+	settings := pool.Snapshot().Settings
+	if size.MaximumSize != nil {
+		settings.MaximumSize = *size.MaximumSize
+	}
+	if size.TargetFreeSize != nil {
+		settings.TargetFreeSize = *size.TargetFreeSize
+	}
+
+	if err := pool.Resize(settings.MaximumSize, settings.TargetFreeSize); err != nil {
+		ctx.writeAdminError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.writeAdminResponse(writer, http.StatusAccepted, pool.Snapshot().Settings)
+}
+
+func (ctx *Context) handleHealthCheck(writer http.ResponseWriter, request *http.Request) {
+	pool := ctx.adminPool(writer, request)
+	if pool == nil {
+		return
+	}
+	ctx.logAdminRequest(request, "health-check")
+
+	ctx.writeAdminResponse(writer, http.StatusOK, pool.CheckHealth(adminHealthCheckTimeout))
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest makes a request to the admin API served by the context, returning the status and decoding any body
+// into the value provided
+func adminRequest(t *testing.T, ctx *Context, method, url, body string, v interface{}) int {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	recorder := httptest.NewRecorder()
+	ctx.statisticsRouter(nil).ServeHTTP(recorder, httptest.NewRequest(method, url, reader))
+
+	if v != nil {
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.Nil(t, json.NewDecoder(recorder.Body).Decode(v))
+	}
+
+	return recorder.Code
+}
+
+// openSession connects to the address and waits for a message to be echoed, so that a container has been assigned
+func openSession(t *testing.T, address string) net.Conn {
+	conn, err := net.Dial("tcp", address)
+	assert.Nil(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("ping"))
+	response := make([]byte, 4)
+	_, err = io.ReadFull(conn, response)
+	assert.Nil(t, err)
+
+	return conn
+}
+
+// busyContainer returns the ID of the container serving the session from the address provided
+func busyContainer(t *testing.T, ctx *Context, pool string, clientAddr net.Addr) string {
+	var containers []cntrpool.ContainerSnapshot
+	assert.Equal(t, http.StatusOK, adminRequest(t, ctx, http.MethodGet, "/pools/"+pool+"/containers", "", &containers))
+
+	for _, cs := range containers {
+		if cs.ClientAddress == clientAddr.String() {
+			return cs.ID
+		}
+	}
+
+	t.Fatal("no container is serving the session")
+	return ""
+}
+
+func Test_AdminAPI(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	var pool string
+	for name := range ctx.ContainerPools {
+		pool = name
+	}
+	poolURL := "/pools/" + pool
+
+	t.Run("ListContainers", func(t *testing.T) {
+		var containers []cntrpool.ContainerSnapshot
+		assert.Equal(t, http.StatusOK, adminRequest(t, ctx, http.MethodGet, poolURL+"/containers", "", &containers))
+		assert.Equal(t, ctx.ContainerPools[pool].Snapshot().Totals.Containers, len(containers))
+	})
+
+	t.Run("UnknownPool", func(t *testing.T) {
+		var e adminError
+		assert.Equal(t, http.StatusNotFound, adminRequest(t, ctx, http.MethodGet, "/pools/missing/containers", "", &e))
+		assert.Equal(t, errorUnknownPool+"missing", e.Error)
+	})
+
+	t.Run("UnknownContainer", func(t *testing.T) {
+		var e adminError
+		assert.Equal(t, http.StatusNotFound, adminRequest(t, ctx, http.MethodDelete, poolURL+"/containers/missing", "", &e))
+		assert.Equal(t, errorUnknownContainer+"missing", e.Error)
+	})
+
+	t.Run("UnknownRouteAndMethod", func(t *testing.T) {
+		var e adminError
+		assert.Equal(t, http.StatusNotFound, adminRequest(t, ctx, http.MethodGet, "/unknown", "", &e))
+		assert.Equal(t, errorNotFound, e.Error)
+		assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, ctx, http.MethodPost, poolURL+"/containers", "", &e))
+		assert.Equal(t, errorMethodNotAllowed, e.Error)
+	})
+
+	t.Run("DisconnectSession", func(t *testing.T) {
+		conn := openSession(t, addr.String())
+		defer conn.Close()
+		id := busyContainer(t, ctx, pool, conn.LocalAddr())
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(t, ctx, http.MethodDelete, poolURL+"/containers/"+id+"/session", "", nil))
+
+		// the client is disconnected, and the container returned to the pool
+		data, _ := ioutil.ReadAll(conn)
+		assert.Equal(t, 0, len(data))
+
+		var e adminError
+		for i := 0; i < 100 && ctx.ContainerPools[pool].Snapshot().Totals.Busy > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, http.StatusNotFound, adminRequest(t, ctx, http.MethodDelete, poolURL+"/containers/"+id+"/session", "", &e))
+		assert.Equal(t, errorNoSession+id, e.Error)
+	})
+
+	t.Run("DrainContainer", func(t *testing.T) {
+		conn := openSession(t, addr.String())
+		id := busyContainer(t, ctx, pool, conn.LocalAddr())
+
+		assert.Equal(t, http.StatusAccepted, adminRequest(t, ctx, http.MethodPost, poolURL+"/containers/"+id+"/drain", "", nil))
+		assert.NotNil(t, ctx.ContainerPools[pool].Container(id))
+
+		// the session is unaffected, but the container is removed once it ends
+		conn.Write([]byte("pong"))
+		response := make([]byte, 4)
+		_, err := io.ReadFull(conn, response)
+		assert.Nil(t, err)
+		conn.Close()
+
+		for i := 0; i < 100 && ctx.ContainerPools[pool].Container(id) != nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Nil(t, ctx.ContainerPools[pool].Container(id))
+
+		// free containers are removed immediately
+		free := ctx.ContainerPools[pool].Snapshot().Containers[0].ID
+		assert.Equal(t, http.StatusAccepted, adminRequest(t, ctx, http.MethodPost, poolURL+"/containers/"+free+"/drain", "", nil))
+		assert.Nil(t, ctx.ContainerPools[pool].Container(free))
+	})
+
+	t.Run("DestroyContainer", func(t *testing.T) {
+		conn := openSession(t, addr.String())
+		defer conn.Close()
+		id := busyContainer(t, ctx, pool, conn.LocalAddr())
+
+		assert.Equal(t, http.StatusAccepted, adminRequest(t, ctx, http.MethodDelete, poolURL+"/containers/"+id, "", nil))
+		assert.Nil(t, ctx.ContainerPools[pool].Container(id))
+
+		data, _ := ioutil.ReadAll(conn)
+		assert.Equal(t, 0, len(data))
+	})
+
+	t.Run("ResizePool", func(t *testing.T) {
+		var settings cntrpool.SnapshotSettings
+		assert.Equal(t, http.StatusAccepted, adminRequest(t, ctx, http.MethodPut, poolURL+"/size", `{"TargetFreeSize": 3}`, &settings))
+		assert.Equal(t, 4, settings.MaximumSize)
+		assert.Equal(t, 3, settings.TargetFreeSize)
+
+		// the pool is scaled up to the new target in the background
+		for i := 0; i < 100 && ctx.ContainerPools[pool].Snapshot().Totals.Free < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, 3, ctx.ContainerPools[pool].Snapshot().Totals.Free)
+
+		var e adminError
+		assert.Equal(t, http.StatusBadRequest, adminRequest(t, ctx, http.MethodPut, poolURL+"/size", `{"MaximumSize": 2}`, &e))
+		assert.Equal(t, http.StatusBadRequest, adminRequest(t, ctx, http.MethodPut, poolURL+"/size", `{"MaximumSize": `, &e))
+		assert.True(t, strings.HasPrefix(e.Error, errorInvalidBody))
+	})
+
+	t.Run("HealthCheck", func(t *testing.T) {
+		var results []cntrpool.HealthCheckResult
+		assert.Equal(t, http.StatusOK, adminRequest(t, ctx, http.MethodPost, poolURL+"/health-check", "", &results))
+		assert.Equal(t, ctx.ContainerPools[pool].Snapshot().Totals.Containers, len(results))
+		for _, result := range results {
+			assert.True(t, result.Healthy)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/preamble"
+	"net"
+	"time"
+)
+
+const (
+	// defaultAuthenticationTimeout is the time allowed for a client to send its token, unless set
+	defaultAuthenticationTimeout = 10 * time.Second
+
+	logMsgAuthenticationFailed = "client authentication failed"
+	logMsgTokenAuthenticated   = "client token authenticated"
+
+	logFieldTokenSubject = "token-subject"
+
+	errorAuthenticationValidator = "authentication requires exactly one of TokenFile and HMACKeyFile"
+)
+
+type (
+	// authenticator reads the token sent by each client before it is assigned a container and validates it
+	authenticator struct {
+		framing   string
+		validator preamble.Validator
+		timeout   time.Duration
+	}
+)
+
+// newAuthenticator creates the authenticator described by the settings provided, reading its token or key file, and
+// returns nil if authentication is disabled. If the settings are invalid then an error is returned.
+func newAuthenticator(settings application.AuthenticationSettings) (*authenticator, error) {
+	if settings.Framing == "" {
+		return nil, nil
+	}
+	if err := preamble.ValidFraming(settings.Framing); err != nil {
+		return nil, err
+	}
+	if (settings.TokenFile == "") == (settings.HMACKeyFile == "") {
+		return nil, errors.New(errorAuthenticationValidator)
+	}
+
+	a := &authenticator{
+		framing: settings.Framing,
+		timeout: time.Duration(settings.TimeoutSec) * time.Second,
+	}
+	if a.timeout <= 0 {
+		a.timeout = defaultAuthenticationTimeout
+	}
+
+	var err error
+	if settings.TokenFile != "" {
+		a.validator, err = preamble.ReadTokenFile(settings.TokenFile)
+	} else {
+		a.validator, err = preamble.ReadHMACKeyFile(settings.HMACKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// authenticate reads the token sent by the client within the timeout and returns the subject that it identifies.
+// Nothing is read beyond the token, so that the container receives everything the client sends afterwards.
+func (a *authenticator) authenticate(conn net.Conn) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(a.timeout))
+	token, err := preamble.ReadToken(conn, a.framing)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return "", err
+	}
+
+	return a.validator.Validate(token)
+}
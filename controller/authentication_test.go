@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/preamble"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_NewAuthenticator(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	tokenFile := filepath.Join(pki.dir, "tokens")
+	ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600)
+
+	t.Run("Disabled", func(t *testing.T) {
+		a, err := newAuthenticator(application.AuthenticationSettings{TokenFile: tokenFile})
+		assert.Nil(t, err)
+		assert.Nil(t, a)
+	})
+
+	t.Run("DefaultTimeout", func(t *testing.T) {
+		a, err := newAuthenticator(application.AuthenticationSettings{Framing: preamble.FramingLine, TokenFile: tokenFile})
+		assert.Nil(t, err)
+		assert.Equal(t, defaultAuthenticationTimeout, a.timeout)
+	})
+
+	t.Run("UnknownFraming", func(t *testing.T) {
+		_, err := newAuthenticator(application.AuthenticationSettings{Framing: "xml", TokenFile: tokenFile})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("NoValidator", func(t *testing.T) {
+		_, err := newAuthenticator(application.AuthenticationSettings{Framing: preamble.FramingLine})
+		assert.Equal(t, errorAuthenticationValidator, err.Error())
+	})
+
+	t.Run("BothValidators", func(t *testing.T) {
+		_, err := newAuthenticator(application.AuthenticationSettings{
+			Framing:     preamble.FramingLine,
+			TokenFile:   tokenFile,
+			HMACKeyFile: tokenFile,
+		})
+		assert.Equal(t, errorAuthenticationValidator, err.Error())
+	})
+}
+
+func Test_AuthenticationListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	t.Run("TokenLine", func(t *testing.T) {
+		tokenFile := filepath.Join(pki.dir, "tokens")
+		ioutil.WriteFile(tokenFile, []byte("first\nsecond\n"), 0600)
+
+		cm := &TestEchoContainerManager{backend: backend}
+		ctx := createTestContext(application.ListenerSettings{
+			Mode:           application.ListenerModeTCP,
+			Authentication: application.AuthenticationSettings{Framing: preamble.FramingLine, TokenFile: tokenFile},
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		// the token is not passed on to the container, but everything sent after it is
+		response, err := proxyEcho(addr.String(), []byte("second\r\n"), "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", response)
+
+		// clients with an unknown token are closed without being sent anything; the close may reset the connection
+		// as the data sent after the token is never read
+		response, _ = proxyEcho(addr.String(), []byte("third\n"), "hello")
+		assert.Equal(t, "", response)
+	})
+
+	t.Run("TokenTimeout", func(t *testing.T) {
+		tokenFile := filepath.Join(pki.dir, "tokens")
+		ioutil.WriteFile(tokenFile, []byte("first\n"), 0600)
+
+		cm := &TestEchoContainerManager{backend: backend}
+		ctx := createTestContext(application.ListenerSettings{
+			Mode: application.ListenerModeTCP,
+			Authentication: application.AuthenticationSettings{
+				Framing:    preamble.FramingLine,
+				TokenFile:  tokenFile,
+				TimeoutSec: 1,
+			},
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		// a client which never finishes sending its token is closed once the timeout has passed
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("fir"))
+
+		start := time.Now()
+		data, err := ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(data))
+		assert.True(t, time.Since(start) >= 500*time.Millisecond)
+	})
+
+	t.Run("SignedTokenFrameOverTLS", func(t *testing.T) {
+		key := []byte(strings.Repeat("k", preamble.MinHMACKeyLength))
+		keyFile := filepath.Join(pki.dir, "hmac.key")
+		ioutil.WriteFile(keyFile, key, 0600)
+		certFile, certKeyFile := pki.issueFiles("server", true)
+		roots := x509.NewCertPool()
+		roots.AddCert(pki.cert)
+
+		cm := &TestEchoContainerManager{backend: backend}
+		ctx := createTestContext(application.ListenerSettings{
+			CertFile:       certFile,
+			KeyFile:        certKeyFile,
+			Authentication: application.AuthenticationSettings{Framing: preamble.FramingJSON, HMACKeyFile: keyFile},
+		})
+		logger, hook := test.NewNullLogger()
+		logger.SetLevel(logrus.DebugLevel)
+		ctx.Logger = logger
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		frame := func(expiry time.Time) string {
+			token, err := preamble.Sign(key, preamble.Claims{Subject: "alice", Expiry: expiry.Unix()})
+			assert.Nil(t, err)
+			body := `{"Token": "` + token + `"}`
+			length := make([]byte, 4)
+			binary.BigEndian.PutUint32(length, uint32(len(body)))
+
+			return string(length) + body
+		}
+		config := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+
+		response, err := tlsEcho(addr.String(), config, frame(time.Now().Add(time.Minute))+"hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", response)
+
+		response, _ = tlsEcho(addr.String(), config, frame(time.Now().Add(-time.Minute))+"hello")
+		assert.Equal(t, "", response)
+
+		// the subject of the signed token is logged for the client which authenticated
+		var subjects, failures []string
+		for _, entry := range hook.AllEntries() {
+			switch entry.Message {
+			case logMsgTokenAuthenticated:
+				subjects = append(subjects, entry.Data[logFieldTokenSubject].(string))
+			case logMsgAuthenticationFailed:
+				failures = append(failures, entry.Data[logFieldError].(error).Error())
+			}
+		}
+		assert.Equal(t, []string{"alice"}, subjects)
+		assert.Equal(t, []string{"token has expired"}, failures)
+	})
+}
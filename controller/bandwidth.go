@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/ratelimit"
+	"io"
+	"time"
+)
+
+const (
+	// TerminationByteLimit is recorded when a session is closed as the total bytes copied exceeded the configured
+	// maximum
+	TerminationByteLimit = "byte-limit"
+
+	// copyBufferSize is the size of the buffer used to copy data between the client and the container
+	copyBufferSize = 32 * 1024
+
+	errorSessionByteLimit = "session byte limit exceeded"
+	errorSessionStopped   = "session stopped whilst waiting on bandwidth limits"
+)
+
+var (
+	errSessionByteLimit = errors.New(errorSessionByteLimit)
+	errSessionStopped   = errors.New(errorSessionStopped)
+)
+
+// bandwidthLimits returns the buckets which limit data copied in the direction provided: those for the direction
+// itself, the session as a whole and the listener. Any of these may be nil where there is no limit.
+func bandwidthLimits(sess *session, srcIsServer bool) []*ratelimit.Bucket {
+	bandwidth := sess.listener.settings.Bandwidth
+
+	rate := bandwidth.ContainerToClientBytesPerSec
+	if srcIsServer {
+		rate = bandwidth.ClientToContainerBytesPerSec
+	}
+
+	return []*ratelimit.Bucket{ratelimit.NewBucket(rate, bandwidth.BurstBytes), sess.bandwidth, sess.listener.bandwidth}
+}
+
+// addBytesCopied adds n to the bytes copied by the session and its container, returning how many of them may be sent
+// without exceeding the maximum provided; if max is not positive then there is no limit
+func (sess *session) addBytesCopied(n, max int64) int64 {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	if max > 0 && sess.bytesCopied+n > max {
+		n = max - sess.bytesCopied
+	}
+	sess.bytesCopied += n
+	sess.container.AddBytesTransferred(n)
+
+	return n
+}
+
+// copyData copies from src to dst until either EOF is reached on src or an error occurs, recording activity on the
+// session for each successful write. Data is copied no faster than the buckets provided allow, and only up to the
+// maximum bytes for the session, after which errSessionByteLimit is returned; errSessionStopped is returned should the
+// session be stopped whilst waiting on the buckets. As per io.Copy, reaching EOF is not treated as an error. The time
+// spent waiting on the buckets is also returned.
+func (sess *session) copyData(dst io.Writer, src io.Reader, buckets []*ratelimit.Bucket, maxBytes int64) (written int64, throttled time.Duration, err error) {
+	buf := make([]byte, ratelimit.ChunkSize(copyBufferSize, buckets...))
+
+	for {
+		nr, readErr := src.Read(buf)
+		if nr > 0 {
+			allowed := sess.addBytesCopied(int64(nr), maxBytes)
+			wait, ok := ratelimit.Wait(sess.done, allowed, buckets...)
+			throttled += wait
+			if !ok {
+				return written, throttled, errSessionStopped
+			}
+
+			nw, writeErr := dst.Write(buf[:allowed])
+			written += int64(nw)
+			if writeErr != nil {
+				return written, throttled, writeErr
+			}
+			sess.markActivity()
+			if int64(nw) != allowed {
+				return written, throttled, io.ErrShortWrite
+			}
+			if allowed < int64(nr) {
+				return written, throttled, errSessionByteLimit
+			}
+		}
+		if readErr == io.EOF {
+			return written, throttled, nil
+		}
+		if readErr != nil {
+			return written, throttled, readErr
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"bytes"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_AddBytesCopied(t *testing.T) {
+	sess := &session{}
+
+	assert.Equal(t, int64(6), sess.addBytesCopied(6, 10))
+	assert.Equal(t, int64(4), sess.addBytesCopied(6, 10))
+	assert.Equal(t, int64(0), sess.addBytesCopied(6, 10))
+
+	// without a maximum everything may be sent
+	assert.Equal(t, int64(100), sess.addBytesCopied(100, 0))
+}
+
+func Test_CopyData(t *testing.T) {
+	data := strings.Repeat("x", 30*1024)
+
+	t.Run("Unlimited", func(t *testing.T) {
+		var dst bytes.Buffer
+		written, throttled, err := (&session{}).copyData(&dst, strings.NewReader(data), nil, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(data)), written)
+		assert.Equal(t, time.Duration(0), throttled)
+		assert.Equal(t, data, dst.String())
+	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		// the first 10KiB are sent immediately, the remaining 20KiB take 200ms
+		bucket := ratelimit.NewBucket(100*1024, 10*1024)
+
+		var dst bytes.Buffer
+		started := time.Now()
+		written, throttled, err := (&session{}).copyData(&dst, strings.NewReader(data), []*ratelimit.Bucket{nil, bucket}, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(data)), written)
+		assert.True(t, throttled >= 150*time.Millisecond, throttled.String())
+		assert.True(t, time.Since(started) >= 150*time.Millisecond)
+	})
+
+	t.Run("Stopped", func(t *testing.T) {
+		// at 1KiB/s the data would take half a minute, but stopping the session ends the wait
+		bucket := ratelimit.NewBucket(1024, 1024)
+		sess := &session{done: make(chan struct{})}
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			sess.stop()
+		}()
+
+		var dst bytes.Buffer
+		started := time.Now()
+		written, _, err := sess.copyData(&dst, strings.NewReader(data), []*ratelimit.Bucket{bucket}, 0)
+		assert.Equal(t, errSessionStopped, err)
+		assert.Equal(t, int64(1024), written)
+		assert.True(t, time.Since(started) < 5*time.Second)
+	})
+
+	t.Run("ByteLimit", func(t *testing.T) {
+		var dst bytes.Buffer
+		written, _, err := (&session{}).copyData(&dst, strings.NewReader(data), nil, 1000)
+		assert.Equal(t, errSessionByteLimit, err)
+		assert.Equal(t, int64(1000), written)
+		assert.Equal(t, data[:1000], dst.String())
+	})
+}
+
+func Test_MaxSessionBytes(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	ctx, hook := createTimeoutTestContext(application.ListenerSettings{
+		Mode:      application.ListenerModeTCP,
+		Bandwidth: application.BandwidthSettings{MaxSessionBytes: 10},
+	})
+	addr := startTestListener(t, ctx, managersFor(ctx, &TestEchoContainerManager{backend: backend}))
+	defer ctx.Shutdown()
+
+	conn, err := net.Dial("tcp", addr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("more than ten bytes"))
+	data, _ := ioutil.ReadAll(conn)
+
+	// the limit is shared between both directions, so nothing can be echoed back
+	assert.Equal(t, 0, len(data))
+	assert.Equal(t, []string{TerminationByteLimit}, terminationReasons(hook))
+}
@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCertificateReloadInterval is how often certificate files are checked for changes, unless set
+	defaultCertificateReloadInterval = 10 * time.Second
+
+	logMsgCertificateLoaded            = "certificate loaded"
+	logMsgSessionTicketKeysLoaded      = "session ticket keys loaded"
+	logErrorReloadingCertificate       = "Error reloading certificate; continuing to use the previous certificate"
+	logErrorReloadingSessionTicketKeys = "Error reloading session ticket keys; continuing to use the previous keys"
+
+	logFieldCertFile          = "cert-file"
+	logFieldNotAfter          = "not-after"
+	logFieldSessionTicketKeys = "session-ticket-keys"
+
+	errorCertificateExpired = "certificate has expired: "
+	errorInvalidOCSPStaple  = "OCSP staple file does not hold a DER-encoded response: "
+)
+
+type (
+	// certificateFiles holds the paths of a certificate and its private key, together with an optional OCSP response
+	// to staple to it
+	certificateFiles struct {
+		certFile string
+		keyFile  string
+		ocspFile string
+	}
+
+	// certificateStore holds the certificate presented to clients selecting each pool, keyed by pool name, reloading
+	// each from its files when these change, or whenever asked to. Should the new files not hold a valid certificate
+	// and key then the previous certificate continues to be used. The session ticket keys and client CRL of the
+	// listener, if any, are reloaded in the same way.
+	certificateStore struct {
+		logger   *logrus.Entry
+		monitor  monitor.Monitor
+		interval time.Duration
+		files    map[string]certificateFiles
+
+		lock         sync.RWMutex
+		certificates map[string]*tls.Certificate
+
+		// ticketKeyFile holds the session ticket keys set on ticketConfig
+		ticketKeyFile string
+		ticketConfig  *tls.Config
+
+		// crlFile holds the client CRL signed by one of crlCAs, which is loaded into revocations; crlOutOfDate is set
+		// once it has been logged that the CRL has passed its next update
+		crlFile      string
+		crlCAs       []*x509.Certificate
+		revocations  *revocationList
+		crlOutOfDate bool
+
+		// reloadLock serialises reloads; modTimes holds the modification time of each file when it was last loaded
+		reloadLock sync.Mutex
+		modTimes   map[string]time.Time
+		stop       chan struct{}
+		stopOnce   sync.Once
+	}
+)
+
+// newCertificateStore creates an empty certificate store which checks its files for changes at the interval provided,
+// defaulting to defaultCertificateReloadInterval if zero; if negative then they are only reloaded when asked to
+func newCertificateStore(logger *logrus.Entry, m monitor.Monitor, intervalSec int) *certificateStore {
+	interval := time.Duration(intervalSec) * time.Second
+	if interval == 0 {
+		interval = defaultCertificateReloadInterval
+	}
+
+	return &certificateStore{
+		logger:       logger,
+		monitor:      m,
+		interval:     interval,
+		files:        make(map[string]certificateFiles),
+		certificates: make(map[string]*tls.Certificate),
+		modTimes:     make(map[string]time.Time),
+		stop:         make(chan struct{}),
+	}
+}
+
+// add loads the certificate for the pool from the files provided, replacing any that it already has; ocspFile may be
+// empty if no OCSP response is to be stapled
+func (cs *certificateStore) add(pool, certFile, keyFile, ocspFile string) error {
+	files := certificateFiles{certFile: certFile, keyFile: keyFile, ocspFile: ocspFile}
+	cert, err := cs.load(pool, files)
+	if err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	cs.files[pool] = files
+	cs.certificates[pool] = cert
+	cs.lock.Unlock()
+
+	return nil
+}
+
+// load loads the certificate, key and any OCSP response from the files provided, rejecting them unless the key
+// matches the certificate and the certificate has not expired
+func (cs *certificateStore) load(pool string, files certificateFiles) (*tls.Certificate, error) {
+	certInfo, err := os.Stat(files.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(files.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(files.certFile, files.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return nil, errors.New(errorCertificateExpired + files.certFile)
+	}
+
+	if files.ocspFile != "" {
+		ocspInfo, err := os.Stat(files.ocspFile)
+		if err != nil {
+			return nil, err
+		}
+		if cert.OCSPStaple, err = ioutil.ReadFile(files.ocspFile); err != nil {
+			return nil, err
+		}
+		// a DER-encoded response is a SEQUENCE; anything else is certainly not a response
+		if len(cert.OCSPStaple) == 0 || cert.OCSPStaple[0] != 0x30 {
+			return nil, errors.New(errorInvalidOCSPStaple + files.ocspFile)
+		}
+		cs.modTimes[files.ocspFile] = ocspInfo.ModTime()
+	}
+
+	cs.modTimes[files.certFile] = certInfo.ModTime()
+	cs.modTimes[files.keyFile] = keyInfo.ModTime()
+
+	cs.logger.WithFields(logrus.Fields{
+		logFieldPool:     pool,
+		logFieldCertFile: files.certFile,
+		logFieldNotAfter: cert.Leaf.NotAfter,
+	}).Info(logMsgCertificateLoaded)
+
+	return &cert, nil
+}
+
+// get returns the certificate for the pool provided, or nil if it does not have one
+func (cs *certificateStore) get(pool string) *tls.Certificate {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	return cs.certificates[pool]
+}
+
+// changed returns true if any of the files provided has been modified since it was last loaded
+func (cs *certificateStore) changed(files ...string) bool {
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(cs.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reload loads each certificate again should its files have changed, or regardless if force is true. Each reload is
+// written to the monitor, whether it succeeded or not.
+func (cs *certificateStore) reload(force bool) {
+	if cs == nil {
+		return
+	}
+
+	cs.reloadLock.Lock()
+	defer cs.reloadLock.Unlock()
+
+	cs.lock.RLock()
+	pools := make(map[string]certificateFiles, len(cs.files))
+	for pool, files := range cs.files {
+		pools[pool] = files
+	}
+	cs.lock.RUnlock()
+
+	for pool, files := range pools {
+		if !force && !cs.changed(files.certFile, files.keyFile, files.ocspFile) {
+			continue
+		}
+
+		cert, err := cs.load(pool, files)
+		if err != nil {
+			log.ErrorEntry(logErrorReloadingCertificate, err, cs.logger.WithFields(logrus.Fields{
+				logFieldPool:     pool,
+				logFieldCertFile: files.certFile,
+			}))
+			cs.monitor.WriteCertificateReloaded(pool, false)
+			continue
+		}
+
+		cs.lock.Lock()
+		cs.certificates[pool] = cert
+		cs.lock.Unlock()
+		cs.monitor.WriteCertificateReloaded(pool, true)
+	}
+
+	if cs.ticketKeyFile != "" && (force || cs.changed(cs.ticketKeyFile)) {
+		if err := cs.loadSessionTicketKeys(); err != nil {
+			log.ErrorEntry(logErrorReloadingSessionTicketKeys, err, cs.logger.WithField(logFieldFile, cs.ticketKeyFile))
+		}
+	}
+	cs.reloadRevocationList(force)
+}
+
+// setSessionTicketKeys loads the session ticket keys from the file provided and sets them on the TLS config, which
+// is then updated whenever they are reloaded
+func (cs *certificateStore) setSessionTicketKeys(config *tls.Config, file string) error {
+	cs.ticketKeyFile = file
+	cs.ticketConfig = config
+
+	return cs.loadSessionTicketKeys()
+}
+
+// loadSessionTicketKeys loads the session ticket keys from their file, setting them on the TLS config should they be
+// valid
+func (cs *certificateStore) loadSessionTicketKeys() error {
+	info, err := os.Stat(cs.ticketKeyFile)
+	if err != nil {
+		return err
+	}
+	keys, err := readSessionTicketKeys(cs.ticketKeyFile)
+	if err != nil {
+		return err
+	}
+
+	cs.ticketConfig.SetSessionTicketKeys(keys)
+	cs.modTimes[cs.ticketKeyFile] = info.ModTime()
+	cs.logger.WithFields(logrus.Fields{
+		logFieldFile:              cs.ticketKeyFile,
+		logFieldSessionTicketKeys: len(keys),
+	}).Info(logMsgSessionTicketKeysLoaded)
+
+	return nil
+}
+
+// watch periodically reloads any certificates or keys whose files have changed until close is called; it returns
+// immediately if files are only reloaded when asked to
+func (cs *certificateStore) watch() {
+	if cs == nil || cs.interval < 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cs.stop:
+			return
+		case <-ticker.C:
+			cs.reload(false)
+		}
+	}
+}
+
+// close stops the certificates from being reloaded
+func (cs *certificateStore) close() {
+	if cs == nil {
+		return
+	}
+
+	cs.stopOnce.Do(func() { close(cs.stop) })
+}
+
+// ReloadCertificates reloads the certificates of every listener from their files, whether they have changed or not
+func (ctx *Context) ReloadCertificates() {
+	ctx.lock.Lock()
+	listeners := ctx.listeners
+	ctx.lock.Unlock()
+
+	for _, pl := range listeners {
+		pl.router.certificates.reload(true)
+	}
+}
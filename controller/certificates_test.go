@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// touch sets the modification time of the files provided into the future, so that they are seen to have changed
+// however coarse the file system timestamps
+func touch(files ...string) {
+	future := time.Now().Add(time.Minute)
+	for _, file := range files {
+		os.Chtimes(file, future, future)
+	}
+}
+
+// certificateSerial returns the serial number of the certificate provided
+func certificateSerial(cert *tls.Certificate) int64 {
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+func Test_CertificateStore(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	l, hook := test.NewNullLogger()
+	m := monitor.NewRecorder()
+
+	t.Run("ReloadChangedFiles", func(t *testing.T) {
+		certFile, keyFile := pki.issueFiles("changed", true)
+		cs := newCertificateStore(logrus.NewEntry(l), m, 0)
+		assert.Nil(t, cs.add("alpha", certFile, keyFile, ""))
+		serial := certificateSerial(cs.get("alpha"))
+
+		// unchanged files are only reloaded when forced
+		cs.reload(false)
+		assert.Equal(t, serial, certificateSerial(cs.get("alpha")))
+
+		pki.issueFiles("changed", true)
+		touch(certFile, keyFile)
+		cs.reload(false)
+		assert.Equal(t, serial+1, certificateSerial(cs.get("alpha")))
+
+		reloaded := m.PointsFor("WriteCertificateReloaded")
+		assert.Equal(t, []interface{}{"alpha", true}, reloaded[len(reloaded)-1].Values)
+	})
+
+	t.Run("InvalidFilesKeepPreviousCertificate", func(t *testing.T) {
+		certFile, keyFile := pki.issueFiles("invalid", true)
+		cs := newCertificateStore(logrus.NewEntry(l), m, 0)
+		assert.Nil(t, cs.add("alpha", certFile, keyFile, ""))
+		serial := certificateSerial(cs.get("alpha"))
+
+		// a certificate which does not match the key is rejected
+		_, otherKeyFile := pki.issueFiles("other", true)
+		otherKey, _ := ioutil.ReadFile(otherKeyFile)
+		ioutil.WriteFile(keyFile, otherKey, 0600)
+		touch(keyFile)
+
+		hook.Reset()
+		cs.reload(false)
+		assert.Equal(t, serial, certificateSerial(cs.get("alpha")))
+		assert.Equal(t, logErrorReloadingCertificate, hook.LastEntry().Message)
+		reloaded := m.PointsFor("WriteCertificateReloaded")
+		assert.Equal(t, []interface{}{"alpha", false}, reloaded[len(reloaded)-1].Values)
+
+		// as is one which cannot be read at all
+		ioutil.WriteFile(certFile, []byte("not a certificate"), 0600)
+		cs.reload(true)
+		assert.Equal(t, serial, certificateSerial(cs.get("alpha")))
+	})
+
+	t.Run("InvalidFilesRejectedOnStart", func(t *testing.T) {
+		cs := newCertificateStore(logrus.NewEntry(l), m, 0)
+		assert.NotNil(t, cs.add("alpha", "missing.crt", "missing.key", ""))
+		assert.Nil(t, cs.get("alpha"))
+	})
+}
+
+func Test_CertificateReloadListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+	certFile, keyFile := pki.issueFiles("server", true)
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{
+		CertFile:              certFile,
+		KeyFile:               keyFile,
+		CertReloadIntervalSec: -1,
+	})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+	serverSerial := func() int64 {
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	serial := serverSerial()
+
+	// the files are not watched, so the new certificate is only presented once the certificates are reloaded
+	pki.issueFiles("server", true)
+	touch(certFile, keyFile)
+	assert.Equal(t, serial, serverSerial())
+
+	ctx.ReloadCertificates()
+	assert.Equal(t, serial+1, serverSerial())
+}
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+const (
+	logMsgClientCRLLoaded    = "client CRL loaded"
+	logMsgClientCRLOutOfDate = "client CRL has passed its next update; continuing to use it until it is replaced"
+	logErrorReloadingCRL     = "Error reloading client CRL; continuing to use the previous CRL"
+
+	logFieldNextUpdate = "next-update"
+
+	errorClientCAFileRequired     = "a client CA file is required when client certificates are verified"
+	errorReadingClientCAFile      = "error reading client CA file: "
+	errorParsingClientCAFile      = "error parsing client CA file: no certificates found in "
+	errorReadingClientCRLFile     = "error reading client CRL file: "
+	errorParsingClientCRLFile     = "error parsing client CRL file: "
+	errorClientCRLNotSignedByCA   = "client CRL file is not signed by any of the client CAs: "
+	errorClientCRLOutOfDate       = "client CRL file has passed its next update: "
+	errorUnknownClientAuthMode    = "unknown client authentication mode: "
+	errorClientCertificateRevoked = "client certificate has been revoked: serial number "
+)
+
+type (
+	// revocationList holds the serial numbers of the certificates revoked by a single issuer, and the time by which
+	// the issuer will have published a newer list, if any
+	revocationList struct {
+		issuer     []byte
+		serials    map[string]struct{}
+		nextUpdate time.Time
+	}
+)
+
+// configureClientAuth configures the TLS config provided to request and verify client certificates as per the
+// listener settings; any CRL is reloaded by the certificate store provided along with the listener certificates
+func configureClientAuth(config *tls.Config, s application.ListenerSettings, cs *certificateStore) error {
+	switch s.ClientAuth {
+	case application.ClientAuthNone, "":
+		return nil
+	case application.ClientAuthVerifyIfGiven:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case application.ClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return errors.New(errorUnknownClientAuthMode + s.ClientAuth)
+	}
+
+	if s.ClientCAFile == "" {
+		return errors.New(errorClientCAFileRequired)
+	}
+	caCerts, err := loadCertificates(s.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	for _, caCert := range caCerts {
+		config.ClientCAs.AddCert(caCert)
+	}
+
+	if s.ClientCRLFile != "" {
+		if err := cs.setRevocationList(s.ClientCRLFile, caCerts); err != nil {
+			return err
+		}
+		config.VerifyPeerCertificate = cs.verifyPeerCertificate
+	}
+
+	return nil
+}
+
+// loadCertificates loads every PEM-encoded certificate from the file provided
+func loadCertificates(file string) (certs []*x509.Certificate, err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.New(errorReadingClientCAFile + err.Error())
+	}
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New(errorReadingClientCAFile + err.Error())
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New(errorParsingClientCAFile + file)
+	}
+
+	return certs, nil
+}
+
+// loadRevocationList loads the PEM- or DER-encoded CRL from the file provided, checking that it has been signed by one
+// of the CA certificates provided and has not passed its next update
+func loadRevocationList(file string, caCerts []*x509.Certificate) (*revocationList, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.New(errorReadingClientCRLFile + err.Error())
+	}
+
+	// ParseRevocationList only accepts DER, so decode the list first should it be PEM-encoded
+	if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+		data = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, errors.New(errorParsingClientCRLFile + err.Error())
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		return nil, errors.New(errorClientCRLOutOfDate + file)
+	}
+
+	for _, caCert := range caCerts {
+		if crl.CheckSignatureFrom(caCert) != nil {
+			continue
+		}
+
+		rl := &revocationList{
+			issuer:     caCert.RawSubject,
+			serials:    make(map[string]struct{}),
+			nextUpdate: crl.NextUpdate,
+		}
+		for _, revoked := range crl.RevokedCertificateEntries {
+			rl.serials[revoked.SerialNumber.String()] = struct{}{}
+		}
+
+		return rl, nil
+	}
+
+	return nil, errors.New(errorClientCRLNotSignedByCA + file)
+}
+
+// isRevoked returns true if the certificate was issued by the issuer of this list and its serial number is revoked
+func (rl *revocationList) isRevoked(issuer []byte, serialNumber *big.Int) bool {
+	if !bytes.Equal(rl.issuer, issuer) {
+		return false
+	}
+	_, revoked := rl.serials[serialNumber.String()]
+
+	return revoked
+}
+
+// verifyPeerCertificate is called once the client certificate chain has been verified, and rejects it should any
+// certificate within it have been revoked
+func (rl *revocationList) verifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if rl.isRevoked(cert.RawIssuer, cert.SerialNumber) {
+				return errors.New(errorClientCertificateRevoked + cert.SerialNumber.String())
+			}
+		}
+	}
+
+	return nil
+}
+
+// setRevocationList loads the client CRL from the file provided, which is then reloaded along with the certificates
+// and used by verifyPeerCertificate
+func (cs *certificateStore) setRevocationList(file string, caCerts []*x509.Certificate) error {
+	cs.crlFile = file
+	cs.crlCAs = caCerts
+
+	return cs.loadRevocationList()
+}
+
+// loadRevocationList loads the client CRL from its file, replacing the previous CRL should it be valid
+func (cs *certificateStore) loadRevocationList() error {
+	info, err := os.Stat(cs.crlFile)
+	if err != nil {
+		return err
+	}
+	rl, err := loadRevocationList(cs.crlFile, cs.crlCAs)
+	if err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	cs.revocations = rl
+	cs.lock.Unlock()
+	cs.modTimes[cs.crlFile] = info.ModTime()
+	cs.crlOutOfDate = false
+	cs.logger.WithFields(logrus.Fields{
+		logFieldFile:       cs.crlFile,
+		logFieldNextUpdate: rl.nextUpdate,
+	}).Info(logMsgClientCRLLoaded)
+
+	return nil
+}
+
+// reloadRevocationList loads the client CRL again should its file have changed, or regardless if force is true, and
+// warns once the CRL in use has passed its next update; the reload lock must be held
+func (cs *certificateStore) reloadRevocationList(force bool) {
+	if cs.crlFile == "" {
+		return
+	}
+
+	if force || cs.changed(cs.crlFile) {
+		if err := cs.loadRevocationList(); err != nil {
+			log.ErrorEntry(logErrorReloadingCRL, err, cs.logger.WithField(logFieldFile, cs.crlFile))
+		}
+	}
+
+	cs.lock.RLock()
+	nextUpdate := cs.revocations.nextUpdate
+	cs.lock.RUnlock()
+	if !cs.crlOutOfDate && !nextUpdate.IsZero() && time.Now().After(nextUpdate) {
+		cs.crlOutOfDate = true
+		cs.logger.WithFields(logrus.Fields{
+			logFieldFile:       cs.crlFile,
+			logFieldNextUpdate: nextUpdate,
+		}).Warn(logMsgClientCRLOutOfDate)
+	}
+}
+
+// verifyPeerCertificate verifies the client certificate against the CRL currently loaded
+func (cs *certificateStore) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	cs.lock.RLock()
+	rl := cs.revocations
+	cs.lock.RUnlock()
+
+	return rl.verifyPeerCertificate(rawCerts, verifiedChains)
+}
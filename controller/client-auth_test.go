@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// tlsEcho connects to the address using the TLS config provided, sends the message and returns the echoed response
+func tlsEcho(address string, config *tls.Config, message string) (string, error) {
+	conn, err := tls.Dial("tcp", address, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(message)); err != nil {
+		return "", err
+	}
+	if err := conn.CloseWrite(); err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadAll(conn)
+
+	return string(data), err
+}
+
+func Test_ConfigureClientAuth(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	logger, hook := test.NewNullLogger()
+
+	t.Run("NoClientAuth", func(t *testing.T) {
+		config := &tls.Config{}
+		assert.Nil(t, configureClientAuth(config, application.ListenerSettings{}, nil))
+		assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	})
+
+	t.Run("UnknownMode", func(t *testing.T) {
+		err := configureClientAuth(&tls.Config{}, application.ListenerSettings{ClientAuth: "sometimes"}, nil)
+		assert.Equal(t, errorUnknownClientAuthMode+"sometimes", err.Error())
+	})
+
+	t.Run("MissingCAFile", func(t *testing.T) {
+		err := configureClientAuth(&tls.Config{}, application.ListenerSettings{ClientAuth: application.ClientAuthRequire}, nil)
+		assert.Equal(t, errorClientCAFileRequired, err.Error())
+	})
+
+	t.Run("CRLFromAnotherCA", func(t *testing.T) {
+		otherPKI := newTestPKI(t)
+		defer otherPKI.close()
+
+		err := configureClientAuth(&tls.Config{}, application.ListenerSettings{
+			ClientAuth:    application.ClientAuthRequire,
+			ClientCAFile:  pki.caFile(),
+			ClientCRLFile: otherPKI.crlFile(),
+		}, newCertificateStore(logrus.NewEntry(logger), monitor.NoOp{}, 0))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("OutOfDateCRL", func(t *testing.T) {
+		crlFile := pki.crlFileUpdatedBy(time.Now().Add(-time.Minute))
+		err := configureClientAuth(&tls.Config{}, application.ListenerSettings{
+			ClientAuth:    application.ClientAuthRequire,
+			ClientCAFile:  pki.caFile(),
+			ClientCRLFile: crlFile,
+		}, newCertificateStore(logrus.NewEntry(logger), monitor.NoOp{}, 0))
+		assert.Equal(t, errorClientCRLOutOfDate+crlFile, err.Error())
+	})
+
+	t.Run("ReloadCRL", func(t *testing.T) {
+		_, cert := pki.issue("later-revoked", false)
+		chains := [][]*x509.Certificate{{cert, pki.cert}}
+
+		config := &tls.Config{}
+		cs := newCertificateStore(logrus.NewEntry(logger), monitor.NoOp{}, 0)
+		assert.Nil(t, configureClientAuth(config, application.ListenerSettings{
+			ClientAuth:    application.ClientAuthRequire,
+			ClientCAFile:  pki.caFile(),
+			ClientCRLFile: pki.crlFile(),
+		}, cs))
+		assert.Nil(t, config.VerifyPeerCertificate(nil, chains))
+
+		// the certificate is rejected once the CRL revoking it has been reloaded
+		pki.crlFile(cert)
+		touch(cs.crlFile)
+		cs.reload(false)
+		assert.NotNil(t, config.VerifyPeerCertificate(nil, chains))
+
+		// an out of date CRL is not loaded, so the previous CRL continues to be used
+		pki.crlFileUpdatedBy(time.Now().Add(-time.Minute))
+		cs.reload(true)
+		assert.Equal(t, logErrorReloadingCRL, hook.LastEntry().Message)
+		assert.NotNil(t, config.VerifyPeerCertificate(nil, chains))
+	})
+
+	t.Run("CRLPassesNextUpdate", func(t *testing.T) {
+		cs := newCertificateStore(logrus.NewEntry(logger), monitor.NoOp{}, 0)
+		assert.Nil(t, cs.setRevocationList(pki.crlFile(), []*x509.Certificate{pki.cert}))
+
+		// the CRL continues to be used once it has passed its next update, which is warned of only once
+		cs.revocations.nextUpdate = time.Now().Add(-time.Minute)
+		hook.Reset()
+		cs.reload(false)
+		cs.reload(false)
+		assert.Equal(t, 1, len(hook.AllEntries()))
+		assert.Equal(t, logMsgClientCRLOutOfDate, hook.LastEntry().Message)
+		assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	})
+}
+
+func Test_MutualTLSListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	certFile, keyFile := pki.issueFiles("server", true)
+	clientCert, _ := pki.issue("allowed-client", false, "allowed.test")
+	revokedCert, revoked := pki.issue("revoked-client", false)
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		ClientAuth:    application.ClientAuthRequire,
+		ClientCAFile:  pki.caFile(),
+		ClientCRLFile: pki.crlFile(revoked),
+	})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+
+	t.Run("VerifiedClient", func(t *testing.T) {
+		response, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}}, "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", response)
+	})
+
+	t.Run("NoClientCertificate", func(t *testing.T) {
+		_, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots}, "hello")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("RevokedClientCertificate", func(t *testing.T) {
+		_, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{revokedCert}}, "hello")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ClientCertificateFromAnotherCA", func(t *testing.T) {
+		otherPKI := newTestPKI(t)
+		defer otherPKI.close()
+		otherCert, _ := otherPKI.issue("allowed-client", false)
+
+		_, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{otherCert}}, "hello")
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_MutualTLSAllowedClients(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	certFile, keyFile := pki.issueFiles("server", true)
+	allowedCert, _ := pki.issue("allowed-client", false, "allowed.test")
+	otherCert, _ := pki.issue("other-client", false, "other.test")
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientAuth:   application.ClientAuthRequire,
+		ClientCAFile: pki.caFile(),
+	})
+	ctx.Settings.Pool.AllowedClients = []string{"allowed.test"}
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+
+	t.Run("AllowedClient", func(t *testing.T) {
+		response, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{allowedCert}}, "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", response)
+	})
+
+	t.Run("ClientNotAllowed", func(t *testing.T) {
+		response, _ := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{otherCert}}, "hello")
+		assert.Equal(t, "", response)
+	})
+}
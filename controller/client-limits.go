@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cidr"
+	"github.com/nextmetaphor/tcp-proxy-pool/ratelimit"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// LimitConnectionRate is recorded when a client is rejected as it opened connections too quickly
+	LimitConnectionRate = "connection-rate"
+	// LimitMaxSessions is recorded when a client is rejected as it already has the maximum number of sessions open
+	LimitMaxSessions = "max-sessions"
+
+	// clientLimitsPruneInterval is how often the state of clients which are no longer limited is discarded
+	clientLimitsPruneInterval = time.Minute
+
+	logMsgClientLimited = "client connection limited"
+
+	logFieldLimit = "limit"
+)
+
+type (
+	// clientLimits enforces the ClientLimitSettings for each client IP address; clients without an IP address, such
+	// as those of UNIX sockets, are limited together as if they were a single client
+	clientLimits struct {
+		lock      sync.Mutex
+		settings  application.ClientLimitSettings
+		clients   map[string]*clientLimitState
+		lastPrune time.Time
+	}
+
+	// clientLimitState is the state held for a single client IP address, or for all clients without one
+	clientLimitState struct {
+		connections *ratelimit.Bucket
+		sessions    int
+	}
+)
+
+// newClientLimits creates the limits from the settings provided, returning nil if no limits are configured
+func newClientLimits(settings application.ClientLimitSettings) *clientLimits {
+	if settings.ConnectionsPerSec <= 0 && settings.MaxSessions <= 0 {
+		return nil
+	}
+
+	return &clientLimits{
+		settings:  settings,
+		clients:   make(map[string]*clientLimitState),
+		lastPrune: time.Now(),
+	}
+}
+
+// clientKey returns the key under which the state of the client with the address provided is held: its IP address,
+// or the name of its network if it does not have one
+func clientKey(addr net.Addr) string {
+	if ip := cidr.IP(addr); ip != nil {
+		return ip.String()
+	}
+
+	return addr.Network()
+}
+
+// acquire determines whether a connection from the address provided is within the limits, returning the limit which
+// was exceeded or an empty string if it is allowed. Allowed connections must be released once their session is over.
+// All addresses are allowed when l is nil.
+func (l *clientLimits) acquire(addr net.Addr) string {
+	if l == nil {
+		return ""
+	}
+	key := clientKey(addr)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.prune()
+
+	state, ok := l.clients[key]
+	if !ok {
+		state = &clientLimitState{
+			connections: ratelimit.NewBucket(l.settings.ConnectionsPerSec, l.settings.ConnectionBurst),
+		}
+		l.clients[key] = state
+	}
+
+	if l.settings.MaxSessions > 0 && state.sessions >= l.settings.MaxSessions {
+		return LimitMaxSessions
+	}
+	if !state.connections.TryTake(1) {
+		return LimitConnectionRate
+	}
+	state.sessions++
+
+	return ""
+}
+
+// release records that a session previously allowed by acquire is over
+func (l *clientLimits) release(addr net.Addr) {
+	if l == nil {
+		return
+	}
+	key := clientKey(addr)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if state, ok := l.clients[key]; ok && state.sessions > 0 {
+		state.sessions--
+	}
+}
+
+// prune periodically discards the state of clients with no sessions whose connection rate is no longer limited, as
+// this is no different from that of a new client; the lock must be held
+func (l *clientLimits) prune() {
+	if time.Since(l.lastPrune) < clientLimitsPruneInterval {
+		return
+	}
+	l.lastPrune = time.Now()
+
+	for key, state := range l.clients {
+		if state.sessions == 0 && state.connections.Full() {
+			delete(l.clients, key)
+		}
+	}
+}
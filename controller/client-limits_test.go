@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ClientLimits(t *testing.T) {
+	addrA := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+	addrA2 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1001}
+	addrB := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1000}
+
+	t.Run("NoLimits", func(t *testing.T) {
+		l := newClientLimits(application.ClientLimitSettings{})
+		assert.Nil(t, l)
+		assert.Equal(t, "", l.acquire(addrA))
+		l.release(addrA)
+	})
+
+	t.Run("MaxSessions", func(t *testing.T) {
+		l := newClientLimits(application.ClientLimitSettings{MaxSessions: 1})
+
+		assert.Equal(t, "", l.acquire(addrA))
+		assert.Equal(t, LimitMaxSessions, l.acquire(addrA2))
+		assert.Equal(t, "", l.acquire(addrB))
+
+		l.release(addrA)
+		assert.Equal(t, "", l.acquire(addrA2))
+	})
+
+	t.Run("ConnectionRate", func(t *testing.T) {
+		l := newClientLimits(application.ClientLimitSettings{ConnectionsPerSec: 1, ConnectionBurst: 2})
+
+		assert.Equal(t, "", l.acquire(addrA))
+		assert.Equal(t, "", l.acquire(addrA))
+		assert.Equal(t, LimitConnectionRate, l.acquire(addrA))
+		assert.Equal(t, "", l.acquire(addrB))
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		l := newClientLimits(application.ClientLimitSettings{ConnectionsPerSec: 1000, MaxSessions: 1})
+
+		l.acquire(addrA)
+		l.acquire(addrB)
+		l.release(addrB)
+		time.Sleep(10 * time.Millisecond)
+
+		l.lock.Lock()
+		l.lastPrune = time.Time{}
+		l.prune()
+		l.lock.Unlock()
+
+		// only the client with an open session remains
+		assert.Equal(t, 1, len(l.clients))
+	})
+
+	t.Run("UnixAddress", func(t *testing.T) {
+		// clients without an IP address are limited together
+		l := newClientLimits(application.ClientLimitSettings{MaxSessions: 1})
+		assert.Equal(t, "", l.acquire(&net.UnixAddr{Name: "", Net: "unix"}))
+		assert.Equal(t, LimitMaxSessions, l.acquire(&net.UnixAddr{Name: "", Net: "unix"}))
+
+		l.release(&net.UnixAddr{Name: "", Net: "unix"})
+		assert.Equal(t, "", l.acquire(&net.UnixAddr{Name: "", Net: "unix"}))
+	})
+}
+
+func Test_ClientLimitsListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{
+		Mode:         application.ListenerModeTCP,
+		ClientLimits: application.ClientLimitSettings{MaxSessions: 1},
+	})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	first, err := net.Dial("tcp", addr.String())
+	assert.Nil(t, err)
+	defer first.Close()
+	first.SetDeadline(time.Now().Add(5 * time.Second))
+	first.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = first.Read(buf)
+	assert.Nil(t, err)
+
+	cm.Lock()
+	created := cm.created
+	cm.Unlock()
+
+	// a second session from the same address is closed without being proxied, and without scaling up the pool
+	second, err := net.Dial("tcp", addr.String())
+	assert.Nil(t, err)
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(5 * time.Second))
+	second.Write([]byte("ping"))
+	data, _ := ioutil.ReadAll(second)
+	assert.Equal(t, 0, len(data))
+
+	cm.Lock()
+	assert.Equal(t, created, cm.created)
+	cm.Unlock()
+}
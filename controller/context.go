@@ -1,19 +1,56 @@
 package controller
 
 import (
-	"github.com/sirupsen/logrus"
 	"github.com/nextmetaphor/tcp-proxy-pool/application"
-	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
 	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"sync"
 )
 
 type (
 	// Context is a struct representing all the components needed by a listener
 	Context struct {
 		// Logger needs to be a pointer due to MutexWrap
-		Logger        *logrus.Logger
-		Settings      application.Settings
-		MonitorClient monitor.Client
-		ContainerPool *cntrpool.ContainerPool
+		Logger   *logrus.Logger
+		Settings application.Settings
+		// Monitor is written to by every component, and if nil then points are discarded; Metrics holds the metrics
+		// served on the statistics server, and is nil if there are none
+		Monitor monitor.Monitor
+		Metrics *monitor.Metrics
+		// ContainerPools holds every container pool, keyed by name
+		ContainerPools map[string]*cntrpool.ContainerPool
+
+		// the remaining fields are used to coordinate a graceful shutdown and are protected by lock
+		lock             sync.Mutex
+		isShuttingDown   bool
+		listeners        []*proxyListener
+		statisticsServer *http.Server
+		sessions         map[net.Conn]*session
+		sessionsActive   sync.WaitGroup
+
+		// systemd holds any sockets passed by systemd socket activation, which are read when first needed
+		systemd systemdSockets
+
+		// inherited holds the listeners and containers handed over by a previous process on upgrade, and is nil if
+		// there are none; upgrading is set, protected by lock, whilst this process is handing over to a new one.
+		// acceptPaused is closed once the listeners may stop waiting for the handover to complete, and handedOver
+		// set should it have succeeded, both also protected by lock.
+		inherited    *inheritedState
+		upgrading    bool
+		acceptPaused chan struct{}
+		handedOver   bool
 	}
 )
+
+// rootMonitor returns the monitor from which those of every listener and pool are derived, which discards points if
+// no monitor has been set
+func (ctx *Context) rootMonitor() monitor.Monitor {
+	if ctx.Monitor == nil {
+		return monitor.NoOp{}
+	}
+
+	return ctx.Monitor
+}
@@ -1,9 +1,10 @@
 package controller
 
 import (
-	"net"
 	"crypto/tls"
 	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"net"
 )
 
 type (
@@ -15,6 +16,15 @@ type (
 	customTCPConn struct {
 		*tls.Conn
 		InnerConn net.Conn
+
+		// identity holds the verified client identity once the handshake is complete, if a certificate was presented
+		identity *cntr.ClientIdentity
+	}
+
+	// closeWriter is implemented by connections which can close their write side whilst still being able to read,
+	// such as *net.TCPConn and customTCPConn
+	closeWriter interface {
+		CloseWrite() error
 	}
 )
 
@@ -31,6 +41,39 @@ func (l *customTLSListener) Accept() (net.Conn, error) {
 	}, nil
 }
 
+// handshake completes the TLS handshake with the client, and records the identity of the client should a verified
+// certificate have been presented
+func (c *customTCPConn) handshake() error {
+	if err := c.Conn.Handshake(); err != nil {
+		return err
+	}
+
+	if chains := c.Conn.ConnectionState().VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+		c.identity = cntr.IdentityFromCertificate(chains[0][0])
+	}
+
+	return nil
+}
+
+// ClientIdentity returns the verified identity of the client, or nil if no verified certificate was presented
+func (c *customTCPConn) ClientIdentity() *cntr.ClientIdentity {
+	return c.identity
+}
+
+// CloseWrite sends a TLS close_notify alert and then closes the write side of the underlying TCP connection, so that
+// the peer sees the end of the stream whilst data can still be read from it
+func (c *customTCPConn) CloseWrite() error {
+	if err := c.Conn.CloseWrite(); err != nil {
+		return err
+	}
+
+	if cw, ok := c.InnerConn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
 // NewListener creates a net.Listener with TLS config
 func NewListener(inner net.Listener, config *tls.Config) net.Listener {
 	l := new(customTLSListener)
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"net/http"
+)
+
+const (
+	urlHealth = "/healthz"
+	urlReady  = "/readyz"
+
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+
+	healthCheckShutdown = "shutdown"
+	healthCheckListener = "listener/"
+	healthCheckPool     = "pool/"
+	healthCheckManager  = "manager/"
+
+	healthDetailShuttingDown  = "proxy is shutting down"
+	healthDetailNotBound      = "listener has not yet been bound"
+	healthDetailAcceptStopped = "accept loop stopped: "
+	healthDetailNoPool        = "pool has not yet been created"
+)
+
+type (
+	// healthResponse is the JSON body of the liveness and readiness responses. Status is "ok" if every check passed,
+	// and "unavailable" otherwise, in which case the Detail of the failed checks explains why.
+	healthResponse struct {
+		Status string
+		Checks []healthCheck
+	}
+
+	// healthCheck is the result of checking a single listener, pool or container manager
+	healthCheck struct {
+		Name   string
+		OK     bool
+		Detail string `json:",omitempty"`
+	}
+)
+
+// handleHealth responds to liveness probes: the proxy is alive unless the accept loop of any listener has stopped
+// other than on shutdown. Listeners which have not yet been bound, as happens whilst the pools are being
+// initialised, do not make the proxy unhealthy.
+func (ctx *Context) handleHealth(writer http.ResponseWriter, request *http.Request) {
+	ctx.writeHealthResponse(writer, ctx.listenerChecks(false))
+}
+
+// handleReady responds to readiness probes: the proxy is ready once every listener has been bound and is accepting
+// connections, every pool is ready as per cntrpool.ContainerPool.Ready, and every container manager is reachable.
+// It is never ready once shutdown has started.
+func (ctx *Context) handleReady(writer http.ResponseWriter, request *http.Request) {
+	var checks []healthCheck
+	if ctx.shuttingDown() {
+		checks = append(checks, healthCheck{Name: healthCheckShutdown, Detail: healthDetailShuttingDown})
+	}
+	checks = append(checks, ctx.listenerChecks(true)...)
+	checks = append(checks, ctx.poolChecks()...)
+
+	ctx.writeHealthResponse(writer, checks)
+}
+
+// writeHealthResponse writes the checks provided, with 200 OK if all passed, or 503 Service Unavailable otherwise
+func (ctx *Context) writeHealthResponse(writer http.ResponseWriter, checks []healthCheck) {
+	response := healthResponse{Status: healthStatusOK, Checks: checks}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			response.Status = healthStatusUnavailable
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if response.Checks == nil {
+		response.Checks = []healthCheck{}
+	}
+
+	ctx.writeAdminResponse(writer, status, response)
+}
+
+// listenerChecks checks every configured listener. A listener which has not yet been bound only fails the check if
+// bound is true.
+func (ctx *Context) listenerChecks(bound bool) []healthCheck {
+	ctx.lock.Lock()
+	listeners := make(map[string]*proxyListener, len(ctx.listeners))
+	acceptErrs := make(map[string]error, len(ctx.listeners))
+	for _, pl := range ctx.listeners {
+		listeners[pl.name] = pl
+		acceptErrs[pl.name] = pl.acceptErr
+	}
+	ctx.lock.Unlock()
+
+	var checks []healthCheck
+	for _, ls := range ctx.Settings.ListenerSettings() {
+		check := healthCheck{Name: healthCheckListener + ls.Name, OK: true}
+		switch {
+		case listeners[ls.Name] == nil:
+			check.OK = !bound
+			check.Detail = healthDetailNotBound
+		case acceptErrs[ls.Name] != nil:
+			check.OK = false
+			check.Detail = healthDetailAcceptStopped + acceptErrs[ls.Name].Error()
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// poolChecks checks that every configured pool is ready and that its container manager is reachable
+func (ctx *Context) poolChecks() []healthCheck {
+	ctx.lock.Lock()
+	pools := ctx.ContainerPools
+	ctx.lock.Unlock()
+
+	var checks []healthCheck
+	for _, ps := range ctx.Settings.PoolSettings() {
+		name := ps.Name
+		pool := pools[name]
+		if pool == nil {
+			checks = append(checks, healthCheck{Name: healthCheckPool + name, Detail: healthDetailNoPool})
+			continue
+		}
+
+		checks = append(checks, checkResult(healthCheckPool+name, pool.Ready()))
+		checks = append(checks, checkResult(healthCheckManager+name, pool.CheckManager()))
+	}
+
+	return checks
+}
+
+// checkResult returns the check with the name provided, which has failed if err is not nil
+func checkResult(name string, err error) healthCheck {
+	if err != nil {
+		return healthCheck{Name: name, Detail: err.Error()}
+	}
+
+	return healthCheck{Name: name, OK: true}
+}
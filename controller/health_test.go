@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_HealthAndReadiness(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{Name: "alpha", Mode: application.ListenerModeTCP})
+
+	t.Run("BeforeStart", func(t *testing.T) {
+		var health healthResponse
+		assert.Equal(t, http.StatusOK, adminRequest(t, ctx, http.MethodGet, urlHealth, "", &health))
+		assert.Equal(t, healthResponse{
+			Status: healthStatusOK,
+			Checks: []healthCheck{{Name: "listener/alpha", OK: true, Detail: healthDetailNotBound}},
+		}, health)
+
+		var ready healthResponse
+		assert.Equal(t, http.StatusServiceUnavailable, adminRequest(t, ctx, http.MethodGet, urlReady, "", &ready))
+		assert.Equal(t, healthStatusUnavailable, ready.Status)
+		assert.Equal(t, []healthCheck{
+			{Name: "listener/alpha", Detail: healthDetailNotBound},
+			{Name: "pool/default", Detail: healthDetailNoPool},
+		}, ready.Checks)
+	})
+
+	startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	t.Run("Ready", func(t *testing.T) {
+		var ready healthResponse
+		assert.Equal(t, http.StatusOK, adminRequest(t, ctx, http.MethodGet, urlReady, "", &ready))
+		assert.Equal(t, healthResponse{
+			Status: healthStatusOK,
+			Checks: []healthCheck{
+				{Name: "listener/alpha", OK: true},
+				{Name: "pool/default", OK: true},
+				{Name: "manager/default", OK: true},
+			},
+		}, ready)
+	})
+
+	t.Run("AcceptLoopStopped", func(t *testing.T) {
+		ctx.lock.Lock()
+		pl := ctx.listeners[0]
+		ctx.lock.Unlock()
+		pl.netListener.Close()
+
+		var health healthResponse
+		for i := 0; i < 100; i++ {
+			health = healthResponse{}
+			if adminRequest(t, ctx, http.MethodGet, urlHealth, "", &health) == http.StatusServiceUnavailable {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, healthStatusUnavailable, health.Status)
+		assert.False(t, health.Checks[0].OK)
+		assert.Contains(t, health.Checks[0].Detail, healthDetailAcceptStopped)
+	})
+
+	t.Run("ShuttingDown", func(t *testing.T) {
+		ctx.Shutdown()
+
+		var ready healthResponse
+		assert.Equal(t, http.StatusServiceUnavailable, adminRequest(t, ctx, http.MethodGet, urlReady, "", &ready))
+		assert.Equal(t, healthCheck{Name: healthCheckShutdown, Detail: healthDetailShuttingDown}, ready.Checks[0])
+	})
+}
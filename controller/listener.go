@@ -1,169 +1,533 @@
 package controller
 
 import (
-	"net"
-	"io"
 	"crypto/tls"
-	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cidr"
 	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
-	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
-	"github.com/sirupsen/logrus"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
 	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/nextmetaphor/tcp-proxy-pool/proxyproto"
+	"github.com/nextmetaphor/tcp-proxy-pool/ratelimit"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
 )
 
 const (
+	// defaultProxyProtocolTimeout is the time allowed for a trusted proxy to send its PROXY protocol header, unless set
+	defaultProxyProtocolTimeout = 10 * time.Second
+
 	logMsgErrorAssigningContainer = "cannot assign container"
+	logMsgHandshakeFailed         = "TLS handshake with client failed"
+	logMsgClientAuthenticated     = "client authenticated"
+	logMsgProxyHeaderRead         = "PROXY protocol header read"
 
-	logFieldError = "error"
+	logFieldError         = "error"
+	logFieldClientSubject = "client-subject"
+	logFieldClientSANs    = "client-sans"
+	logFieldProxyAddress  = "proxy-address"
+	logFieldListener      = "listener"
 
-	logSecureServerStarting           = "Server starting on address [%s] and port [%s] with a secure configuration: cert[%s] key[%s]"
-	logErrorCreatingListener          = "Error creating customTLSListener"
+	logSecureServerStarting           = "Server starting on [%s] with a secure configuration: cert[%s] key[%s]"
+	logPlainServerStarting            = "Server starting on [%s] with a plain TCP configuration"
+	logErrorCreatingListener          = "Error creating listener"
 	logErrorAcceptingConnection       = "Error accepting connection"
 	logErrorCopying                   = "Error copying"
 	logErrorClosing                   = "Error closing"
 	logErrorLoadingCertificates       = "Error loading certificates"
-	logErrorServerConnNotTCP          = "Error: server connection not TCP"
-	logErrorClientConnNotTCP          = "Error: client connection not TCP"
+	logErrorConnNotHalfClosable       = "Error: connection does not support closing the write side only"
 	logErrorCreatingContainerPool     = "Error creating container pool"
 	logErrorInitialisingContainerPool = "Error initialising container pool"
+	logErrorUnknownListenerMode       = "Error: unknown listener mode"
+	logErrorReadingProxyHeader        = "Error reading PROXY protocol header"
+	logErrorProxyingConnection        = "Error proxying connection"
 
-	logErrorProxyingConnection = "Error proxying connection"
+	errorDuplicateListenerName = "duplicate listener name: "
 )
 
-// StartListener is called when the application is ready to start serving connections from the pool
-func (ctx *Context) StartListener(cm cntrmgr.ContainerManager) bool {
-	cp, e := cntrpool.CreateContainerPool(cm, ctx.Settings.Pool, ctx.Logger, ctx.MonitorClient)
-	if e != nil {
-		log.Error(logErrorCreatingContainerPool, e, ctx.Logger)
+type (
+	// proxyListener holds a single configured listener together with the state which is specific to it: the router
+	// which selects the pool for each of its clients, its monitor and any limits on its clients
+	proxyListener struct {
+		name        string
+		settings    application.ListenerSettings
+		router      *router
+		monitor     monitor.Monitor
+		logger      *logrus.Entry
+		netListener net.Listener
+
+		// socket is the network listener underlying netListener, which is handed over to a new process on upgrade
+		socket net.Listener
+
+		// bandwidth and clientLimits are nil if there are no limits, and accessControl if all clients are allowed
+		bandwidth     *ratelimit.Bucket
+		clientLimits  *clientLimits
+		accessControl *accessControl
+
+		// authenticator is nil if clients need not send a token before being assigned a container
+		authenticator *authenticator
+
+		// acceptErr is the error which stopped the accept loop, protected by the context lock; it remains nil whilst
+		// the loop is running and if it was stopped on shutdown
+		acceptErr error
+	}
+)
+
+// StartListener is called when the application is ready to start serving connections from the pools. A container
+// manager must be provided for every configured pool, keyed by the pool name. Every configured listener is started,
+// each with its own accept loop, and this only returns once all of them have been closed or if any could not be
+// started.
+func (ctx *Context) StartListener(managers map[string]cntrmgr.ContainerManager) bool {
+	if ctx.inherited == nil {
+		ctx.inherited = loadInheritedState(ctx.Logger)
+	}
+
+	r, err := ctx.createPools(managers)
+	if err != nil {
+		log.Error(logErrorCreatingContainerPool, err, ctx.Logger)
 		return false
 	}
 
-	errs := cp.InitialisePool()
-	if (errs != nil) && len(errs) > 0 {
-		for _, e:= range errs {
-			log.Error(logErrorInitialisingContainerPool, e, ctx.Logger)
+	ctx.lock.Lock()
+	ctx.ContainerPools = r.containerPools()
+	ctx.lock.Unlock()
+
+	var listeners []*proxyListener
+	names := make(map[string]bool)
+	for _, ls := range ctx.Settings.ListenerSettings() {
+		if names[ls.Name] {
+			err = errors.New(errorDuplicateListenerName + ls.Name)
+		} else {
+			names[ls.Name] = true
+
+			var pl *proxyListener
+			if pl, err = ctx.createListener(ls, r); err == nil {
+				listeners = append(listeners, pl)
+			}
+		}
+
+		if err != nil {
+			log.ErrorEntry(logErrorCreatingListener, err, ctx.Logger.WithField(logFieldListener, ls.Name))
+			closeListeners(listeners)
+			return false
 		}
 	}
 
-	ctx.ContainerPool = cp
-	ctx.Logger.Infof(logSecureServerStarting,
-		ctx.Settings.Listener.Host,
-		ctx.Settings.Listener.Port,
-		ctx.Settings.Listener.CertFile,
-		ctx.Settings.Listener.KeyFile)
+	// the pools are only initialised once the listeners have been created, so that containers handed over by a
+	// previous process are not adopted unless this process is able to serve their clients
+	if err := ctx.initialisePools(r); err != nil {
+		log.Error(logErrorInitialisingContainerPool, err, ctx.Logger)
+		closeListeners(listeners)
+		return false
+	}
 
-	tcpProtocol := ctx.Settings.Listener.Transport
-	tcpIP := ctx.Settings.Listener.Host
-	tcpPort := ctx.Settings.Listener.Port
+	// make the listeners available so that they can be closed on shutdown; if shutdown has already started then
+	// there is no point in accepting any connections
+	ctx.lock.Lock()
+	if ctx.isShuttingDown {
+		ctx.lock.Unlock()
+		closeListeners(listeners)
+		return true
+	}
+	ctx.listeners = listeners
+	ctx.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pl := range listeners {
+		wg.Add(1)
+		go func(pl *proxyListener) {
+			defer wg.Done()
+			go pl.accessControl.watch()
+			go pl.router.certificates.watch()
+			ctx.handleConnections(pl)
+		}(pl)
+	}
+	wg.Wait()
 
-	cert, err := tls.LoadX509KeyPair(ctx.Settings.Listener.CertFile, ctx.Settings.Listener.KeyFile)
+	return true
+}
+
+// closeListeners closes every listener provided and stops reloading their access control lists and certificates
+func closeListeners(listeners []*proxyListener) {
+	for _, pl := range listeners {
+		// we're not going to act on Close errors, so ignore purposefully
+		pl.netListener.Close()
+		pl.accessControl.close()
+		pl.router.certificates.close()
+	}
+}
+
+// createListener creates the listener described by the settings provided, routing its clients to the pools of the
+// router provided
+func (ctx *Context) createListener(ls application.ListenerSettings, r *router) (*proxyListener, error) {
+	tags := map[string]string{monitor.TagListener: ls.Name}
+
+	lr, err := r.forListener(ls, tags)
 	if err != nil {
-		log.Error(logErrorLoadingCertificates, err, ctx.Logger)
-		return false
+		return nil, err
 	}
 
-	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
-	listener, listenErr := Listen(tcpProtocol, tcpIP+":"+tcpPort, tlsConfig)
-	if listener != nil {
-		defer listener.Close()
+	logger := ctx.Logger.WithField(logFieldListener, ls.Name)
+	ac, err := newAccessControl(ls.AccessControl, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	if listenErr != nil {
-		log.Error(logErrorCreatingListener, err, ctx.Logger)
-		return false
+	auth, err := newAuthenticator(ls.Authentication)
+	if err != nil {
+		ac.close()
+		return nil, err
 	}
 
-	ctx.handleConnections(listener)
+	pl := &proxyListener{
+		name:          ls.Name,
+		settings:      ls,
+		router:        lr,
+		monitor:       ctx.rootMonitor().WithTags(tags),
+		logger:        logger,
+		bandwidth:     ratelimit.NewBucket(ls.Bandwidth.ListenerBytesPerSec, ls.Bandwidth.BurstBytes),
+		clientLimits:  newClientLimits(ls.ClientLimits),
+		accessControl: ac,
+		authenticator: auth,
+	}
 
-	return true
+	if pl.netListener, err = ctx.listen(pl); err != nil {
+		ac.close()
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// listen creates the network listener as per the configured Mode of the listener: either terminating TLS using the
+// certificate of the pool selected by the client, or passing through plain TCP. Should any load balancers be trusted
+// to send PROXY protocol headers then these are expected on their connections before anything else, including the
+// TLS handshake.
+func (ctx *Context) listen(pl *proxyListener) (net.Listener, error) {
+	ls := pl.settings
+
+	trustedProxies, err := cidr.Parse(ls.ProxyProtocolTrustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ls.Mode {
+	case application.ListenerModeTCP:
+		pl.logger.Infof(logPlainServerStarting, listenAddress(ls))
+
+		listener, err := ctx.netListen(ls)
+		if err != nil {
+			return nil, err
+		}
+		pl.socket = listener
+
+		return acceptProxyProtocol(ls, listener, trustedProxies), nil
+
+	case application.ListenerModeTLS, "":
+		pl.logger.Infof(logSecureServerStarting, listenAddress(ls), ls.CertFile, ls.KeyFile)
+
+		if ls.TLSPolicy, err = tlsPolicy(ls); err != nil {
+			return nil, err
+		}
+
+		tlsConfig := &tls.Config{GetCertificate: pl.router.getCertificate}
+		if err := configureTLSPolicy(tlsConfig, ls); err != nil {
+			return nil, err
+		}
+
+		certificates := newCertificateStore(pl.logger, pl.monitor, ls.CertReloadIntervalSec)
+		if err := pl.router.loadCertificates(ctx.Settings, ls, certificates); err != nil {
+			log.ErrorEntry(logErrorLoadingCertificates, err, pl.logger)
+			return nil, err
+		}
+		if ls.TLSPolicy.SessionTicketKeyFile != "" {
+			if err := certificates.setSessionTicketKeys(tlsConfig, ls.TLSPolicy.SessionTicketKeyFile); err != nil {
+				return nil, errors.New(errorInvalidTLSPolicy + err.Error())
+			}
+		}
+
+		protocols, err := pl.router.addProtocols(ls.Protocols)
+		if err != nil {
+			return nil, err
+		}
+		if len(protocols) > 0 {
+			tlsConfig.NextProtos = protocols
+			tlsConfig.GetConfigForClient = pl.router.rejectUnsupportedProtocols(pl.monitor)
+		}
+
+		if err := configureClientAuth(tlsConfig, ls, certificates); err != nil {
+			return nil, err
+		}
+
+		listener, err := ctx.netListen(ls)
+		if err != nil {
+			return nil, err
+		}
+		pl.socket = listener
+
+		return NewListener(acceptProxyProtocol(ls, listener, trustedProxies), tlsConfig), nil
+	}
+
+	return nil, errors.New(logErrorUnknownListenerMode + ": " + ls.Mode)
+}
+
+// acceptProxyProtocol wraps the listener so that connections from the trusted proxies provided are expected to begin
+// with a PROXY protocol header; if there are no trusted proxies then the listener is returned unaltered
+func acceptProxyProtocol(ls application.ListenerSettings, listener net.Listener, trustedProxies cidr.List) net.Listener {
+	if len(trustedProxies) == 0 {
+		return listener
+	}
+
+	timeout := time.Duration(ls.ProxyProtocolTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProxyProtocolTimeout
+	}
+
+	return &proxyproto.Listener{
+		Listener: listener,
+		Trusted:  trustedProxies.ContainsAddr,
+		Timeout:  timeout,
+	}
+}
+
+// readProxyHeader reads the PROXY protocol header from the connection, should it be from a trusted proxy, so that
+// the address of the client is known before anything else happens. Connections not from a trusted proxy are
+// unaffected.
+func (ctx *Context) readProxyHeader(pl *proxyListener, serverConn net.Conn) error {
+	conn := serverConn
+	if tlsConn, ok := serverConn.(*customTCPConn); ok {
+		conn = tlsConn.InnerConn
+	}
+
+	proxyConn, ok := conn.(*proxyproto.Conn)
+	if !ok {
+		return nil
+	}
+
+	if _, err := proxyConn.ReadHeader(); err != nil {
+		return err
+	}
+
+	pl.logger.WithFields(logrus.Fields{
+		logFieldClientAddress: proxyConn.RemoteAddr().String(),
+		logFieldProxyAddress:  proxyConn.ProxyAddr().String(),
+	}).Debug(logMsgProxyHeaderRead)
+
+	return nil
 }
 
 // handleConnections is called when the container pool has been initialised and the listener has been started.
 // A separate goroutine is created to handle each Accept request on the listener.
-func (ctx *Context) handleConnections(listener net.Listener) {
+func (ctx *Context) handleConnections(pl *proxyListener) {
 	for {
-		conn, err := listener.Accept()
+		conn, err := pl.netListener.Accept()
 		if err != nil {
-			log.Error(logErrorAcceptingConnection, err, ctx.Logger)
+			if ctx.awaitHandOver() {
+				continue
+			}
+
+			// the listener is closed on shutdown, and its socket used by the new process once handed over
+			ctx.lock.Lock()
+			shuttingDown := ctx.isShuttingDown || ctx.handedOver
+			if !shuttingDown {
+				pl.acceptErr = err
+			}
+			ctx.lock.Unlock()
+
+			if !shuttingDown {
+				log.ErrorEntry(logErrorAcceptingConnection, err, pl.logger)
+			}
 			return
 		}
 
-		go ctx.clientConnect(conn)
+		go ctx.clientConnect(pl, conn)
 	}
 }
 
 // clientConnect is called in a separate goroutine for every successful Accept request on the server listener.
-func (ctx *Context) clientConnect(serverConn net.Conn) {
-	c, err := ctx.ContainerPool.AssociateClientWithContainer(serverConn)
+func (ctx *Context) clientConnect(pl *proxyListener, serverConn net.Conn) {
+	// the client address is needed for the session, so must be read from any PROXY protocol header first
+	if err := ctx.readProxyHeader(pl, serverConn); err != nil {
+		pl.logger.WithFields(logrus.Fields{
+			logFieldProxyAddress: serverConn.RemoteAddr().String(),
+			logFieldError:        err,
+		}).Warn(logErrorReadingProxyHeader)
+		// we're not going to act on Close errors, so ignore purposefully
+		serverConn.Close()
+		return
+	}
+
+	// denied clients are closed before anything else is done for them, including the TLS handshake
+	if !pl.accessControl.allowed(serverConn.RemoteAddr()) {
+		pl.logger.WithFields(logrus.Fields{
+			logFieldClientAddress: serverConn.RemoteAddr().String(),
+		}).Debug(logMsgConnectionDenied)
+		pl.monitor.WriteConnectionDenied(serverConn)
+		// we're not going to act on Close errors, so ignore purposefully
+		serverConn.Close()
+		return
+	}
+
+	// limit each client before anything else is done for it, so that limited clients cannot consume containers
+	if limit := pl.clientLimits.acquire(serverConn.RemoteAddr()); limit != "" {
+		pl.logger.WithFields(logrus.Fields{
+			logFieldClientAddress: serverConn.RemoteAddr().String(),
+			logFieldLimit:         limit,
+		}).Debug(logMsgClientLimited)
+		pl.monitor.WriteConnectionLimited(serverConn, limit)
+		// we're not going to act on Close errors, so ignore purposefully
+		serverConn.Close()
+		return
+	}
+	defer pl.clientLimits.release(serverConn.RemoteAddr())
+
+	sess := ctx.beginSession(pl, serverConn)
+	if sess == nil {
+		// we're not going to act on Close errors, so ignore purposefully
+		serverConn.Close()
+		return
+	}
+	defer ctx.endSession(sess)
+
+	// complete the TLS handshake before a container is assigned, so that the client identity, requested server
+	// name and negotiated protocol are known, and clients which fail to authenticate do not consume a container
+	serverName, protocol := "", ""
+	if tlsConn, ok := serverConn.(*customTCPConn); ok {
+		if timedOut, err := handshakeWithTimeout(pl.settings, tlsConn); err != nil {
+			if timedOut {
+				ctx.terminateSession(sess, TerminationHandshakeTimeout)
+				return
+			}
+			sess.logger.WithFields(logrus.Fields{logFieldError: err}).Debug(logMsgHandshakeFailed)
+			sess.monitor.WriteHandshakeFailed(serverConn)
+			// we're not going to act on Close errors, so ignore purposefully
+			serverConn.Close()
+			return
+		}
+		state := tlsConn.ConnectionState()
+		serverName, protocol = state.ServerName, state.NegotiatedProtocol
+	}
+
+	// only clients which send a valid token are routed and assigned a container; nothing is sent to those which do
+	// not, so that they learn nothing about why they were rejected
+	subject, err := pl.authenticator.authenticate(serverConn)
+	if err != nil {
+		sess.logger.WithFields(logrus.Fields{logFieldError: err}).Debug(logMsgAuthenticationFailed)
+		sess.monitor.WriteAuthenticationFailed(serverConn)
+		// we're not going to act on Close errors, so ignore purposefully
+		serverConn.Close()
+		return
+	}
+	if subject != "" {
+		ctx.addSessionLogFields(sess, logrus.Fields{logFieldTokenSubject: subject}).Debug(logMsgTokenAuthenticated)
+	}
+
+	route, backendPort := pl.router.route(serverName, protocol)
+	ctx.setSessionRoute(sess, route)
+	sess.logger.WithFields(logrus.Fields{
+		logFieldServerName: serverName,
+		logFieldProtocol:   protocol,
+	}).Debug(logMsgRoutedClient)
+
+	if identity := cntr.IdentityOf(serverConn); identity != nil {
+		ctx.addSessionLogFields(sess, logrus.Fields{logFieldClientSubject: identity.Subject}).WithFields(logrus.Fields{
+			logFieldClientSANs: identity.SubjectAlternativeNames(),
+		}).Debug(logMsgClientAuthenticated)
+	}
+
+	c, err := route.pool.AssociateClientWithContainer(serverConn)
 	if c != nil {
-		defer ctx.ContainerPool.DissociateClientWithContainer(serverConn, c)
+		defer route.pool.DissociateClientWithContainer(serverConn, c)
+		ctx.setSessionContainer(sess, c)
 	}
 
 	if err != nil {
-		ctx.Logger.WithFields(logrus.Fields{logFieldError: err}).Debug(logMsgErrorAssigningContainer)
+		sess.logger.WithFields(logrus.Fields{logFieldError: err}).Debug(logMsgErrorAssigningContainer)
 		// we're not going to act on Close errors, so ignore purposefully
 		serverConn.Close()
 		return
 	}
 
-	if err := cntrpool.ConnectClientToContainer(c); err != nil {
-		log.Error(logErrorProxyingConnection, err, ctx.Logger)
+	if err := route.pool.ConnectClientToContainer(c, backendPort); err != nil {
+		log.ErrorEntry(logErrorProxyingConnection, err, sess.logger)
+		// we're not going to act on Close errors, so ignore purposefully
+		serverConn.Close()
 		return
 	}
+	ctx.setSessionBackend(sess, c.ConnectionToContainer)
 
-	ctx.proxy(c)
+	ctx.proxy(sess)
 }
 
-func (ctx *Context) proxy(c *cntr.Container) {
-	server := c.ConnectionFromClient
-	client := c.ConnectionToContainer
-
-	clientClosedChannel := make(chan struct{}, 1)
-	serverClosedChannel := make(chan struct{}, 1)
-
-	go ctx.connectionCopy(false, server, client, clientClosedChannel)
-	go ctx.connectionCopy(true, client, server, serverClosedChannel)
-
-	var waitChannel chan struct{}
-	select {
-	case <-clientClosedChannel:
-		if customTCPConn, customTCPConnErr := server.(*customTCPConn); customTCPConnErr {
-			if tcpConn, tcpConnErr := customTCPConn.InnerConn.(*net.TCPConn); tcpConnErr {
-				tcpConn.SetLinger(0)
-				tcpConn.CloseRead()
-			} else {
-				ctx.Logger.Warn(logErrorServerConnNotTCP)
-				customTCPConn.InnerConn.Close()
-			}
-		} else {
-			ctx.Logger.Warn(logErrorServerConnNotTCP)
-			server.Close()
-		}
-		waitChannel = serverClosedChannel
+// proxy copies data in both directions between the client and the container until both sides have finished.
+// When one side finishes sending cleanly its write side is closed on the other, so that half-closed connections are
+// supported; should an error occur in either direction then both connections are closed. Should the session become
+// idle or reach its maximum duration then it is terminated.
+func (ctx *Context) proxy(sess *session) {
+	server := sess.container.ConnectionFromClient
+	client := sess.container.ConnectionToContainer
 
-	case <-serverClosedChannel:
-		if tcpConn, tcpConnErr := client.(*net.TCPConn); tcpConnErr {
-			tcpConn.CloseRead()
-		} else {
-			ctx.Logger.Warn(logErrorClientConnNotTCP)
+	copyCompleteChannel := make(chan struct{}, 2)
+	done := make(chan struct{})
+	defer close(done)
+
+	go ctx.watchSession(sess, done)
+	go ctx.connectionCopy(sess, false, server, client, copyCompleteChannel)
+	go ctx.connectionCopy(sess, true, client, server, copyCompleteChannel)
+
+	<-copyCompleteChannel
+	<-copyCompleteChannel
+	sess.monitor.WriteSessionDuration(sess.serverConn, time.Since(sess.start))
+
+	// both directions are complete; we're not going to act on Close errors, so ignore purposefully
+	server.Close()
+	client.Close()
+}
+
+func (ctx *Context) connectionCopy(sess *session, srcIsServer bool, dst, src net.Conn, copyCompleteChannel chan struct{}) {
+	buckets := bandwidthLimits(sess, srcIsServer)
+	bytesCopied, throttled, err := sess.copyData(dst, src, buckets, sess.listener.settings.Bandwidth.MaxSessionBytes)
+
+	sess.monitor.WriteBytesCopied(srcIsServer, bytesCopied, dst, src)
+	if throttled > 0 {
+		sess.monitor.WriteBandwidthThrottled(sess.serverConn, throttled)
+	}
+
+	if err == errSessionByteLimit {
+		ctx.terminateSession(sess, TerminationByteLimit)
+	} else if err != nil {
+		// errors are expected once the session has been terminated, as its connections will have been closed
+		if sess.terminated() == "" {
+			log.ErrorEntry(logErrorCopying, err, sess.logger)
 		}
-		waitChannel = clientClosedChannel
+
+		// something went wrong, so close both connections which will in turn stop the copy in the other direction
+		src.Close()
+		dst.Close()
+	} else {
+		// the source has finished sending, so let the destination know whilst still allowing data to be sent
+		// in the other direction
+		ctx.closeWrite(dst)
 	}
 
-	<-waitChannel
+	copyCompleteChannel <- struct{}{}
 }
 
-func (ctx *Context) connectionCopy(srcIsServer bool, dst, src net.Conn, sourceClosedChannel chan struct{}) {
-	bytesCopied, err := io.Copy(dst, src)
-	if err != nil {
-		log.Error(logErrorCopying, err, ctx.Logger)
+// closeWrite closes the write side of the connection where it supports this, otherwise closing it completely
+func (ctx *Context) closeWrite(conn net.Conn) {
+	if cw, ok := conn.(closeWriter); ok {
+		if err := cw.CloseWrite(); err == nil {
+			return
+		}
+	} else {
+		ctx.Logger.Warn(logErrorConnNotHalfClosable)
 	}
 
-	ctx.MonitorClient.WriteBytesCopied(srcIsServer, bytesCopied, dst, src)
-
-	if err := src.Close(); err != nil {
+	if err := conn.Close(); err != nil {
 		log.Error(logErrorClosing, err, ctx.Logger)
 	}
-
-	sourceClosedChannel <- struct{}{}
 }
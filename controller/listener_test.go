@@ -0,0 +1,299 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// TestEchoContainerManager creates containers which all point at the same in-process echo backend
+	TestEchoContainerManager struct {
+		sync.Mutex
+		backend   net.Listener
+		created   int
+		destroyed int
+	}
+)
+
+func (cm *TestEchoContainerManager) CreateContainer() (*cntr.Container, error) {
+	cm.Lock()
+	defer cm.Unlock()
+
+	cm.created++
+	addr := cm.backend.Addr().(*net.TCPAddr)
+	return &cntr.Container{
+		ExternalID: strconv.Itoa(cm.created),
+		StartTime:  time.Now(),
+		IPAddress:  addr.IP.String(),
+		Port:       addr.Port,
+	}, nil
+}
+
+func (cm *TestEchoContainerManager) DestroyContainer(externalID string) error {
+	cm.Lock()
+	defer cm.Unlock()
+
+	cm.destroyed++
+	return nil
+}
+
+// startEchoBackend starts a TCP server which echoes everything it receives, closing its write side once the client
+// has closed its own
+func startEchoBackend(t *testing.T) net.Listener {
+	return startBannerBackend(t, "")
+}
+
+// startBannerBackend starts a TCP server which sends the banner provided to each client and then behaves as per
+// startEchoBackend; this allows tests to determine which backend they have been connected to
+func startBannerBackend(t *testing.T, banner string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.Write([]byte(banner))
+				buf := make([]byte, 1024)
+				for {
+					n, err := conn.Read(buf)
+					if n > 0 {
+						conn.Write(buf[:n])
+					}
+					if err != nil {
+						conn.(*net.TCPConn).CloseWrite()
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return l
+}
+
+// managersFor returns a map using the container manager provided for every pool configured in the context
+func managersFor(ctx *Context, cm cntrmgr.ContainerManager) map[string]cntrmgr.ContainerManager {
+	managers := make(map[string]cntrmgr.ContainerManager)
+	for _, ps := range ctx.Settings.PoolSettings() {
+		managers[ps.Name] = cm
+	}
+
+	return managers
+}
+
+// startTestListener starts the listeners for the context in a separate goroutine, returning the address that the
+// first is listening on once they are ready
+func startTestListener(t *testing.T, ctx *Context, managers map[string]cntrmgr.ContainerManager) net.Addr {
+	go ctx.StartListener(managers)
+
+	for i := 0; i < 100; i++ {
+		ctx.lock.Lock()
+		listeners := ctx.listeners
+		ctx.lock.Unlock()
+
+		if len(listeners) > 0 {
+			return listeners[0].netListener.Addr()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("listener did not start")
+	return nil
+}
+
+func createTestContext(listenerSettings application.ListenerSettings) *Context {
+	logger, _ := test.NewNullLogger()
+
+	listenerSettings.Host = "127.0.0.1"
+	listenerSettings.Port = "0"
+	listenerSettings.Transport = "tcp4"
+
+	metrics := monitor.NewMetrics()
+
+	return &Context{
+		Logger: logger,
+		Settings: application.Settings{
+			Listener: listenerSettings,
+			Pool:     cntrpool.Settings{InitialSize: 2, MaximumSize: 4, TargetFreeSize: 1},
+		},
+		Monitor: monitor.NewMultiMonitor(monitor.NewRecorder(), metrics.Monitor()),
+		Metrics: metrics,
+	}
+}
+
+func Test_PlainTCPListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+
+	t.Run("EchoWithHalfClose", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello, world"))
+		assert.Nil(t, err)
+
+		// only close our write side; the echoed data should still be received followed by the end of the stream
+		assert.Nil(t, conn.(*net.TCPConn).CloseWrite())
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, err := ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello, world", string(data))
+	})
+
+	t.Run("ConcurrentSessions", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				conn, err := net.Dial("tcp", addr.String())
+				assert.Nil(t, err)
+				defer conn.Close()
+
+				message := "session " + strconv.Itoa(i)
+				conn.Write([]byte(message))
+				conn.(*net.TCPConn).CloseWrite()
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				data, err := ioutil.ReadAll(conn)
+				assert.Nil(t, err)
+				assert.Equal(t, message, string(data))
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("ShutdownDestroysContainers", func(t *testing.T) {
+		ctx.Shutdown()
+
+		cm.Lock()
+		defer cm.Unlock()
+		assert.True(t, cm.created > 0)
+		assert.Equal(t, cm.created, cm.destroyed)
+
+		_, err := net.DialTimeout("tcp", addr.String(), time.Second)
+		assert.NotNil(t, err)
+	})
+}
+
+// listenerAddr returns the address of the named listener, which must have been started
+func listenerAddr(t *testing.T, ctx *Context, name string) net.Addr {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	for _, pl := range ctx.listeners {
+		if pl.name == name {
+			return pl.netListener.Addr()
+		}
+	}
+
+	t.Fatal("listener not found: " + name)
+	return nil
+}
+
+func Test_MultipleListeners(t *testing.T) {
+	alphaBackend := startBannerBackend(t, "alpha:")
+	defer alphaBackend.Close()
+	betaBackend := startBannerBackend(t, "beta:")
+	defer betaBackend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+	certFile, keyFile := pki.issueFiles("server", true)
+
+	poolSettings := cntrpool.Settings{InitialSize: 1, MaximumSize: 2, TargetFreeSize: 1}
+	ctx := createTestContext(application.ListenerSettings{})
+	ctx.Settings.Pools = []application.PoolSettings{
+		{Name: "alpha", Pool: poolSettings},
+		{Name: "beta", Pool: poolSettings},
+	}
+	ctx.Settings.Listeners = []application.ListenerSettings{
+		{Name: "external", Host: "127.0.0.1", Port: "0", Transport: "tcp4", CertFile: certFile, KeyFile: keyFile},
+		{Name: "internal", Host: "127.0.0.1", Port: "0", Transport: "tcp4", Mode: application.ListenerModeTCP, Pool: "beta"},
+	}
+
+	startTestListener(t, ctx, map[string]cntrmgr.ContainerManager{
+		"alpha": &TestEchoContainerManager{backend: alphaBackend},
+		"beta":  &TestEchoContainerManager{backend: betaBackend},
+	})
+
+	t.Run("ExternalTLSListenerUsesDefaultPool", func(t *testing.T) {
+		roots := x509.NewCertPool()
+		roots.AddCert(pki.cert)
+		response, err := tlsEcho(listenerAddr(t, ctx, "external").String(), &tls.Config{RootCAs: roots}, "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "alpha:hello", response)
+	})
+
+	t.Run("InternalPlainListenerUsesItsPool", func(t *testing.T) {
+		conn, err := net.Dial("tcp", listenerAddr(t, ctx, "internal").String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("hello"))
+		conn.(*net.TCPConn).CloseWrite()
+		data, err := ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "beta:hello", string(data))
+	})
+
+	t.Run("ShutdownClosesAllListeners", func(t *testing.T) {
+		external := listenerAddr(t, ctx, "external")
+		internal := listenerAddr(t, ctx, "internal")
+		ctx.Shutdown()
+
+		_, err := net.DialTimeout("tcp", external.String(), time.Second)
+		assert.NotNil(t, err)
+		_, err = net.DialTimeout("tcp", internal.String(), time.Second)
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_InvalidListeners(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+	cm := &TestEchoContainerManager{backend: backend}
+
+	t.Run("DuplicateName", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{})
+		ctx.Settings.Listeners = []application.ListenerSettings{
+			{Name: "a", Host: "127.0.0.1", Port: "0", Transport: "tcp4", Mode: application.ListenerModeTCP},
+			{Name: "a", Host: "127.0.0.1", Port: "0", Transport: "tcp4", Mode: application.ListenerModeTCP},
+		}
+		assert.False(t, ctx.StartListener(managersFor(ctx, cm)))
+	})
+
+	t.Run("UnknownPool", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{})
+		ctx.Settings.Listeners = []application.ListenerSettings{
+			{Name: "a", Host: "127.0.0.1", Port: "0", Transport: "tcp4", Mode: application.ListenerModeTCP, Pool: "missing"},
+		}
+		assert.False(t, ctx.StartListener(managersFor(ctx, cm)))
+	})
+}
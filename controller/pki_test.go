@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type (
+	// testPKI is a throwaway certificate authority used to issue server and client certificates for tests
+	testPKI struct {
+		t      *testing.T
+		dir    string
+		key    *ecdsa.PrivateKey
+		cert   *x509.Certificate
+		serial int64
+	}
+)
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "tcp-proxy-pool-pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pki := &testPKI{t: t, dir: dir, serial: 1}
+	pki.key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(pki.serial),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &pki.key.PublicKey, pki.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.cert, _ = x509.ParseCertificate(der)
+
+	return pki
+}
+
+func (pki *testPKI) close() {
+	os.RemoveAll(pki.dir)
+}
+
+// writeFile writes the PEM block provided to a file in the PKI directory, returning its path
+func (pki *testPKI) writeFile(name, blockType string, der []byte) string {
+	file := filepath.Join(pki.dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		pki.t.Fatal(err)
+	}
+
+	return file
+}
+
+// caFile writes the CA certificate to a file, returning its path
+func (pki *testPKI) caFile() string {
+	return pki.writeFile("ca.crt", "CERTIFICATE", pki.cert.Raw)
+}
+
+// issue creates a certificate signed by the CA for the common name provided; server certificates are valid for
+// 127.0.0.1 and the DNS names provided
+func (pki *testPKI) issue(commonName string, server bool, dnsNames ...string) (tls.Certificate, *x509.Certificate) {
+	pki.serial++
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(pki.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, pki.cert, &key.PublicKey, pki.key)
+	if err != nil {
+		pki.t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+// issueFiles issues a certificate as per issue, writing the certificate and key to files and returning their paths
+func (pki *testPKI) issueFiles(name string, server bool, dnsNames ...string) (certFile, keyFile string) {
+	cert, _ := pki.issue(name, server, dnsNames...)
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		pki.t.Fatal(err)
+	}
+
+	return pki.writeFile(name+".crt", "CERTIFICATE", cert.Certificate[0]),
+		pki.writeFile(name+".key", "EC PRIVATE KEY", keyDER)
+}
+
+// crlFile creates a CRL signed by the CA revoking the certificates provided, returning its path
+func (pki *testPKI) crlFile(revoked ...*x509.Certificate) string {
+	return pki.crlFileUpdatedBy(time.Now().Add(time.Hour), revoked...)
+}
+
+// crlFileUpdatedBy creates a CRL as per crlFile which is to be updated by the time provided
+func (pki *testPKI) crlFileUpdatedBy(nextUpdate time.Time, revoked ...*x509.Certificate) string {
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: nextUpdate.Add(-2 * time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, cert := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, pki.cert, pki.key)
+	if err != nil {
+		pki.t.Fatal(err)
+	}
+
+	return pki.writeFile("ca.crl", "X509 CRL", der)
+}
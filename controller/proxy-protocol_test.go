@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cidr"
+	"github.com/nextmetaphor/tcp-proxy-pool/proxyproto"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// proxyEcho connects to the address, sends the header followed by the message and returns the echoed response
+func proxyEcho(address string, header []byte, message string) (string, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(append(header, message...)); err != nil {
+		return "", err
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	data, err := ioutil.ReadAll(conn)
+
+	return string(data), err
+}
+
+// proxiedClientAddresses returns the client addresses logged for each PROXY protocol header read
+func proxiedClientAddresses(hook *test.Hook) []string {
+	var addresses []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == logMsgProxyHeaderRead {
+			addresses = append(addresses, entry.Data[logFieldClientAddress].(string))
+		}
+	}
+
+	return addresses
+}
+
+func Test_InboundProxyProtocol(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	v1Header := []byte("PROXY TCP4 203.0.113.7 192.0.2.1 40000 443\r\n")
+
+	t.Run("TrustedProxy", func(t *testing.T) {
+		cm := &TestEchoContainerManager{backend: backend}
+		ctx := createTestContext(application.ListenerSettings{
+			Mode:                      application.ListenerModeTCP,
+			ProxyProtocolTrustedCIDRs: []string{"127.0.0.0/8"},
+			ProxyProtocolTimeoutSec:   1,
+		})
+		logger, hook := test.NewNullLogger()
+		logger.SetLevel(logrus.DebugLevel)
+		ctx.Logger = logger
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		response, err := proxyEcho(addr.String(), v1Header, "hello v1")
+		assert.Nil(t, err)
+		assert.Equal(t, "hello v1", response)
+
+		v2 := &proxyproto.Header{
+			Version:     proxyproto.Version2,
+			Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 40001},
+			Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+		}
+		var v2Header bytes.Buffer
+		v2.WriteTo(&v2Header)
+		response, err = proxyEcho(addr.String(), v2Header.Bytes(), "hello v2")
+		assert.Nil(t, err)
+		assert.Equal(t, "hello v2", response)
+
+		assert.Equal(t, []string{"203.0.113.7:40000", "[2001:db8::7]:40001"}, proxiedClientAddresses(hook))
+
+		// trusted proxies must send a header, so a connection without one is closed without reaching a container
+		response, _ = proxyEcho(addr.String(), nil, "no header")
+		assert.Equal(t, "", response)
+	})
+
+	t.Run("UntrustedProxy", func(t *testing.T) {
+		cm := &TestEchoContainerManager{backend: backend}
+		ctx := createTestContext(application.ListenerSettings{
+			Mode:                      application.ListenerModeTCP,
+			ProxyProtocolTrustedCIDRs: []string{"10.0.0.0/8"},
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		// the header is not interpreted, so is passed through to the container as data
+		response, err := proxyEcho(addr.String(), v1Header, "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, string(v1Header)+"hello", response)
+	})
+
+	t.Run("HeaderBeforeTLSHandshake", func(t *testing.T) {
+		pki := newTestPKI(t)
+		defer pki.close()
+		certFile, keyFile := pki.issueFiles("server", true)
+
+		cm := &TestEchoContainerManager{backend: backend}
+		ctx := createTestContext(application.ListenerSettings{
+			CertFile:                  certFile,
+			KeyFile:                   keyFile,
+			ProxyProtocolTrustedCIDRs: []string{"127.0.0.1"},
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write(v1Header)
+		assert.Nil(t, err)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(pki.cert)
+		tlsConn := tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+		_, err = tlsConn.Write([]byte("hello"))
+		assert.Nil(t, err)
+		tlsConn.CloseWrite()
+		data, err := ioutil.ReadAll(tlsConn)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("InvalidTrustedCIDRs", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{
+			Mode:                      application.ListenerModeTCP,
+			ProxyProtocolTrustedCIDRs: []string{"not-a-cidr"},
+		})
+		assert.False(t, ctx.StartListener(managersFor(ctx, &TestEchoContainerManager{backend: backend})))
+	})
+	t.Run("DefaultTimeout", func(t *testing.T) {
+		trusted, _ := cidr.Parse([]string{"127.0.0.0/8"})
+		ls := application.ListenerSettings{}
+		assert.Equal(t, defaultProxyProtocolTimeout, acceptProxyProtocol(ls, nil, trusted).(*proxyproto.Listener).Timeout)
+
+		ls.ProxyProtocolTimeoutSec = 3
+		assert.Equal(t, 3*time.Second, acceptProxyProtocol(ls, nil, trusted).(*proxyproto.Listener).Timeout)
+	})
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"strings"
+)
+
+const (
+	logMsgRoutedClient = "client routed to pool"
+
+	logFieldPool       = "pool"
+	logFieldServerName = "server-name"
+	logFieldProtocol   = "protocol"
+
+	errorDuplicatePoolName      = "duplicate pool name: "
+	errorDuplicateServerName    = "server name is configured for more than one pool: "
+	errorUnknownDefaultPool     = "default pool does not exist: "
+	errorNoDefaultCertificate   = "no certificate configured for the listener or the default pool"
+	errorLoadingPoolCertificate = "error loading certificate for pool "
+	errorPoolOCSPWithoutCert    = "an OCSP staple file requires a certificate for pool "
+	errorDuplicateProtocol      = "duplicate application protocol: "
+	errorUnknownProtocolPool    = "application protocol refers to a pool which does not exist: "
+	errorUnsupportedProtocols   = "client offered no supported application protocols"
+	errorUnknownListenerPool    = "listener refers to a pool which does not exist: "
+)
+
+type (
+	// poolRoute holds a named container pool together with the monitor used for sessions which select it
+	poolRoute struct {
+		name    string
+		pool    *cntrpool.ContainerPool
+		monitor monitor.Monitor
+	}
+
+	// protocolRoute holds the pool and backend port selected by an application protocol negotiated using ALPN; a nil
+	// route means that the pool is selected using SNI
+	protocolRoute struct {
+		route       *poolRoute
+		backendPort int
+	}
+
+	// router selects the pool that serves a client connection from the application protocol (ALPN) negotiated with
+	// it or the TLS server name (SNI) that it requested, falling back to the default pool. Each listener has its own
+	// router, as its protocols, certificates and default pool are specific to it.
+	router struct {
+		routes       map[string]*poolRoute
+		serverNames  map[string]*poolRoute
+		protocols    map[string]protocolRoute
+		defaultRoute *poolRoute
+
+		// certificates holds the certificate presented to clients selecting each pool; it is nil unless the listener
+		// terminates TLS
+		certificates *certificateStore
+	}
+)
+
+// createPools creates every configured pool, using the container manager with the same name as each pool, and
+// returns a router for them. The pools are not initialised until initialisePools is called.
+func (ctx *Context) createPools(managers map[string]cntrmgr.ContainerManager) (*router, error) {
+	r := &router{
+		routes:      make(map[string]*poolRoute),
+		serverNames: make(map[string]*poolRoute),
+		protocols:   make(map[string]protocolRoute),
+	}
+
+	for _, ps := range ctx.Settings.PoolSettings() {
+		if _, exists := r.routes[ps.Name]; exists {
+			return nil, errors.New(errorDuplicatePoolName + ps.Name)
+		}
+
+		poolSettings := ps.Pool
+		poolSettings.Name = ps.Name
+		poolMonitor := ctx.rootMonitor().WithTags(map[string]string{monitor.TagPool: ps.Name})
+
+		cp, err := cntrpool.CreateContainerPool(managers[ps.Name], poolSettings, ctx.Logger, poolMonitor)
+		if err != nil {
+			return nil, err
+		}
+
+		route := &poolRoute{name: ps.Name, pool: cp, monitor: poolMonitor}
+		r.routes[ps.Name] = route
+		for _, serverName := range ps.ServerNames {
+			serverName = strings.ToLower(serverName)
+			if _, exists := r.serverNames[serverName]; exists {
+				return nil, errors.New(errorDuplicateServerName + serverName)
+			}
+			r.serverNames[serverName] = route
+		}
+	}
+
+	defaultPool := ctx.Settings.DefaultPoolName()
+	if r.defaultRoute = r.routes[defaultPool]; r.defaultRoute == nil {
+		return nil, errors.New(errorUnknownDefaultPool + defaultPool)
+	}
+
+	return r, nil
+}
+
+// initialisePools initialises every pool of the router, first adopting any containers handed over by a previous
+// process on upgrade so that only the remainder need to be created. Errors initialising a pool are logged but do not
+// prevent it from being used, as it will be scaled up as clients connect.
+func (ctx *Context) initialisePools(r *router) error {
+	if err := ctx.inherited.adoptContainers(r.containerPools()); err != nil {
+		return err
+	}
+
+	for _, route := range r.routes {
+		for _, e := range route.pool.InitialisePool() {
+			log.ErrorEntry(logErrorInitialisingContainerPool, e, ctx.Logger.WithField(logFieldPool, route.name))
+		}
+	}
+
+	return nil
+}
+
+// forListener returns a router for the listener provided, routing to the same pools as r but with its own default
+// pool, should the listener name one, and without any protocols or certificates. Sessions routed by it are written
+// to the monitor with the tags provided in addition to those of the pool.
+func (r *router) forListener(ls application.ListenerSettings, tags map[string]string) (*router, error) {
+	lr := &router{
+		routes:      make(map[string]*poolRoute, len(r.routes)),
+		serverNames: make(map[string]*poolRoute, len(r.serverNames)),
+		protocols:   make(map[string]protocolRoute),
+	}
+
+	for name, route := range r.routes {
+		lr.routes[name] = &poolRoute{name: name, pool: route.pool, monitor: route.monitor.WithTags(tags)}
+	}
+	for serverName, route := range r.serverNames {
+		lr.serverNames[serverName] = lr.routes[route.name]
+	}
+
+	lr.defaultRoute = lr.routes[r.defaultRoute.name]
+	if ls.Pool != "" {
+		if lr.defaultRoute = lr.routes[ls.Pool]; lr.defaultRoute == nil {
+			return nil, errors.New(errorUnknownListenerPool + ls.Pool)
+		}
+	}
+
+	return lr, nil
+}
+
+// addProtocols adds the application protocols provided to the router, returning the protocol names in the order in
+// which they should be advertised
+func (r *router) addProtocols(protocols []application.ProtocolSettings) (names []string, err error) {
+	for _, protocol := range protocols {
+		if _, exists := r.protocols[protocol.Name]; exists {
+			return nil, errors.New(errorDuplicateProtocol + protocol.Name)
+		}
+
+		pr := protocolRoute{backendPort: protocol.BackendPort}
+		if protocol.Pool != "" {
+			if pr.route = r.routes[protocol.Pool]; pr.route == nil {
+				return nil, errors.New(errorUnknownProtocolPool + protocol.Pool)
+			}
+		}
+
+		r.protocols[protocol.Name] = pr
+		names = append(names, protocol.Name)
+	}
+
+	return names, nil
+}
+
+// rejectUnsupportedProtocols returns a function to be used as the tls.Config GetConfigForClient callback, which
+// rejects clients offering application protocols of which none are supported. Clients which do not offer any
+// application protocols are accepted.
+func (r *router) rejectUnsupportedProtocols(m monitor.Monitor) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if len(hello.SupportedProtos) == 0 {
+			return nil, nil
+		}
+
+		for _, protocol := range hello.SupportedProtos {
+			if _, ok := r.protocols[protocol]; ok {
+				return nil, nil
+			}
+		}
+
+		m.WriteProtocolRejected(hello.Conn)
+		return nil, errors.New(errorUnsupportedProtocols)
+	}
+}
+
+// loadCertificates loads the certificate of each pool which has one configured into the certificate store provided,
+// which is then used by the router. The default pool uses the listener certificate if one is configured, otherwise its
+// own; one of these must be present. Any OCSP response in the TLS policy of the listener is stapled to the listener
+// certificate, and that of each pool to its own certificate, so that clients selecting a pool using SNI are also
+// given a staple.
+func (r *router) loadCertificates(s application.Settings, ls application.ListenerSettings, cs *certificateStore) error {
+	for _, ps := range s.PoolSettings() {
+		if ps.CertFile == "" && ps.KeyFile == "" {
+			if ps.OCSPStapleFile != "" {
+				return errors.New(errorPoolOCSPWithoutCert + ps.Name)
+			}
+			continue
+		}
+
+		if err := cs.add(ps.Name, ps.CertFile, ps.KeyFile, ps.OCSPStapleFile); err != nil {
+			return errors.New(errorLoadingPoolCertificate + ps.Name + ": " + err.Error())
+		}
+	}
+
+	if ls.CertFile != "" || ls.KeyFile != "" {
+		if err := cs.add(r.defaultRoute.name, ls.CertFile, ls.KeyFile, ls.TLSPolicy.OCSPStapleFile); err != nil {
+			return err
+		}
+	}
+
+	if cs.get(r.defaultRoute.name) == nil {
+		return errors.New(errorNoDefaultCertificate)
+	}
+	r.certificates = cs
+
+	return nil
+}
+
+// routeForServerName returns the route for the server name provided, matching a wildcard server name such as
+// *.example.com if there is no exact match, and falling back to the default route if there is no match at all
+func (r *router) routeForServerName(serverName string) *poolRoute {
+	serverName = strings.ToLower(serverName)
+	if route, ok := r.serverNames[serverName]; ok {
+		return route
+	}
+
+	if i := strings.Index(serverName, "."); i > 0 {
+		if route, ok := r.serverNames["*"+serverName[i:]]; ok {
+			return route
+		}
+	}
+
+	return r.defaultRoute
+}
+
+// route returns the route for the client connection, together with the port on the container to connect to (zero
+// if the container port should be used), from the application protocol negotiated and server name requested
+func (r *router) route(serverName, protocol string) (*poolRoute, int) {
+	if pr, ok := r.protocols[protocol]; ok {
+		if pr.route != nil {
+			return pr.route, pr.backendPort
+		}
+		return r.routeForServerName(serverName), pr.backendPort
+	}
+
+	return r.routeForServerName(serverName), 0
+}
+
+// getCertificate is used as the tls.Config GetCertificate callback, returning the certificate of the pool selected by
+// the server name requested by the client
+func (r *router) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := r.certificates.get(r.routeForServerName(hello.ServerName).name); cert != nil {
+		return cert, nil
+	}
+
+	return r.certificates.get(r.defaultRoute.name), nil
+}
+
+// containerPools returns a map of every pool, keyed by name
+func (r *router) containerPools() map[string]*cntrpool.ContainerPool {
+	pools := make(map[string]*cntrpool.ContainerPool, len(r.routes))
+	for name, route := range r.routes {
+		pools[name] = route.pool
+	}
+
+	return pools
+}
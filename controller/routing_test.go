@@ -0,0 +1,295 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrmgr"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_RouteForServerName(t *testing.T) {
+	alpha := &poolRoute{name: "alpha"}
+	beta := &poolRoute{name: "beta"}
+	r := &router{
+		serverNames: map[string]*poolRoute{
+			"alpha.test":  alpha,
+			"*.beta.test": beta,
+		},
+		defaultRoute: alpha,
+	}
+
+	t.Run("ExactMatch", func(t *testing.T) {
+		assert.Equal(t, alpha, r.routeForServerName("alpha.test"))
+	})
+
+	t.Run("CaseInsensitiveMatch", func(t *testing.T) {
+		assert.Equal(t, alpha, r.routeForServerName("ALPHA.test"))
+	})
+
+	t.Run("WildcardMatch", func(t *testing.T) {
+		assert.Equal(t, beta, r.routeForServerName("one.beta.test"))
+	})
+
+	t.Run("WildcardDoesNotMatchParent", func(t *testing.T) {
+		assert.Equal(t, alpha, r.routeForServerName("beta.test"))
+	})
+
+	t.Run("NoServerName", func(t *testing.T) {
+		assert.Equal(t, alpha, r.routeForServerName(""))
+	})
+
+	t.Run("UnknownServerName", func(t *testing.T) {
+		assert.Equal(t, alpha, r.routeForServerName("gamma.test"))
+	})
+}
+
+func Test_CreatePools(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+	cm := &TestEchoContainerManager{backend: backend}
+
+	t.Run("DuplicatePoolName", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{})
+		ctx.Settings.Pools = []application.PoolSettings{{Name: "alpha"}, {Name: "alpha"}}
+		_, err := ctx.createPools(managersFor(ctx, cm))
+		assert.Equal(t, errorDuplicatePoolName+"alpha", err.Error())
+	})
+
+	t.Run("DuplicateServerName", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{})
+		ctx.Settings.Pools = []application.PoolSettings{
+			{Name: "alpha", ServerNames: []string{"test"}},
+			{Name: "beta", ServerNames: []string{"TEST"}},
+		}
+		_, err := ctx.createPools(managersFor(ctx, cm))
+		assert.Equal(t, errorDuplicateServerName+"test", err.Error())
+	})
+
+	t.Run("UnknownDefaultPool", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{})
+		ctx.Settings.Pools = []application.PoolSettings{{Name: "alpha"}}
+		ctx.Settings.DefaultPool = "beta"
+		_, err := ctx.createPools(managersFor(ctx, cm))
+		assert.Equal(t, errorUnknownDefaultPool+"beta", err.Error())
+	})
+
+	t.Run("PoolOCSPStapleWithoutCertificate", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{})
+		ctx.Settings.Pools = []application.PoolSettings{{Name: "alpha", OCSPStapleFile: "staple.der"}}
+		cs := newCertificateStore(logrus.NewEntry(ctx.Logger), ctx.Monitor, -1)
+		err := (&router{defaultRoute: &poolRoute{name: "alpha"}}).loadCertificates(ctx.Settings, ctx.Settings.Listener, cs)
+		assert.Equal(t, errorPoolOCSPWithoutCert+"alpha", err.Error())
+	})
+
+	t.Run("MissingContainerManager", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{})
+		ctx.Settings.Pools = []application.PoolSettings{{Name: "alpha"}}
+		_, err := ctx.createPools(map[string]cntrmgr.ContainerManager{})
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_SNIRouting(t *testing.T) {
+	alphaBackend := startBannerBackend(t, "alpha:")
+	defer alphaBackend.Close()
+	betaBackend := startBannerBackend(t, "beta:")
+	defer betaBackend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+	alphaCertFile, alphaKeyFile := pki.issueFiles("alpha", true, "alpha.test")
+	betaCertFile, betaKeyFile := pki.issueFiles("beta", true, "*.beta.test")
+	betaStaple := []byte{0x30, 0x03, 0x0a, 0x01, 0x00}
+	betaStapleFile := filepath.Join(pki.dir, "beta.ocsp")
+	ioutil.WriteFile(betaStapleFile, betaStaple, 0600)
+
+	poolSettings := cntrpool.Settings{InitialSize: 1, MaximumSize: 2, TargetFreeSize: 1}
+	ctx := createTestContext(application.ListenerSettings{})
+	ctx.Settings.Pools = []application.PoolSettings{
+		{Name: "alpha", ServerNames: []string{"alpha.test"}, CertFile: alphaCertFile, KeyFile: alphaKeyFile, Pool: poolSettings},
+		{Name: "beta", ServerNames: []string{"*.beta.test"}, CertFile: betaCertFile, KeyFile: betaKeyFile,
+			OCSPStapleFile: betaStapleFile, Pool: poolSettings},
+	}
+	ctx.Settings.DefaultPool = "alpha"
+
+	addr := startTestListener(t, ctx, map[string]cntrmgr.ContainerManager{
+		"alpha": &TestEchoContainerManager{backend: alphaBackend},
+		"beta":  &TestEchoContainerManager{backend: betaBackend},
+	})
+	defer ctx.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+
+	t.Run("AlphaServerName", func(t *testing.T) {
+		response, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, ServerName: "alpha.test"}, "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "alpha:hello", response)
+	})
+
+	t.Run("BetaWildcardServerName", func(t *testing.T) {
+		response, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, ServerName: "one.beta.test"}, "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "beta:hello", response)
+	})
+
+	t.Run("PoolOCSPStaple", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: roots, ServerName: "one.beta.test"})
+		assert.Nil(t, err)
+		defer conn.Close()
+		assert.Equal(t, betaStaple, conn.ConnectionState().OCSPResponse)
+
+		conn, err = tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: roots, ServerName: "alpha.test"})
+		assert.Nil(t, err)
+		defer conn.Close()
+		assert.Nil(t, conn.ConnectionState().OCSPResponse)
+	})
+
+	t.Run("UnknownServerNameUsesDefaultPool", func(t *testing.T) {
+		response, err := tlsEcho(addr.String(), &tls.Config{InsecureSkipVerify: true, ServerName: "gamma.test"}, "hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "alpha:hello", response)
+	})
+
+	t.Run("StatisticsKeyedByPool", func(t *testing.T) {
+		assert.Equal(t, 2, len(ctx.ContainerPools))
+		assert.NotNil(t, ctx.ContainerPools["alpha"])
+		assert.NotNil(t, ctx.ContainerPools["beta"])
+	})
+}
+
+func Test_AddProtocols(t *testing.T) {
+	alpha := &poolRoute{name: "alpha"}
+	newRouter := func() *router {
+		return &router{
+			routes:       map[string]*poolRoute{"alpha": alpha},
+			serverNames:  map[string]*poolRoute{},
+			protocols:    map[string]protocolRoute{},
+			defaultRoute: alpha,
+		}
+	}
+
+	t.Run("ProtocolsInOrder", func(t *testing.T) {
+		names, err := newRouter().addProtocols([]application.ProtocolSettings{{Name: "b"}, {Name: "a", Pool: "alpha"}})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"b", "a"}, names)
+	})
+
+	t.Run("DuplicateProtocol", func(t *testing.T) {
+		_, err := newRouter().addProtocols([]application.ProtocolSettings{{Name: "a"}, {Name: "a"}})
+		assert.Equal(t, errorDuplicateProtocol+"a", err.Error())
+	})
+
+	t.Run("UnknownPool", func(t *testing.T) {
+		_, err := newRouter().addProtocols([]application.ProtocolSettings{{Name: "a", Pool: "beta"}})
+		assert.Equal(t, errorUnknownProtocolPool+"beta", err.Error())
+	})
+
+	t.Run("ProtocolWithoutPoolUsesServerName", func(t *testing.T) {
+		r := newRouter()
+		r.addProtocols([]application.ProtocolSettings{{Name: "a", BackendPort: 1234}})
+		route, port := r.route("any.test", "a")
+		assert.Equal(t, alpha, route)
+		assert.Equal(t, 1234, port)
+	})
+}
+
+func Test_ALPNRouting(t *testing.T) {
+	alphaBackend := startBannerBackend(t, "alpha:")
+	defer alphaBackend.Close()
+	betaBackend := startBannerBackend(t, "beta:")
+	defer betaBackend.Close()
+	otherBackend := startBannerBackend(t, "other:")
+	defer otherBackend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+	certFile, keyFile := pki.issueFiles("server", true)
+
+	poolSettings := cntrpool.Settings{InitialSize: 1, MaximumSize: 2, TargetFreeSize: 1}
+	ctx := createTestContext(application.ListenerSettings{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Protocols: []application.ProtocolSettings{
+			{Name: "alpha/1", Pool: "alpha"},
+			{Name: "beta/1", Pool: "beta"},
+			{Name: "alpha/2", Pool: "alpha", BackendPort: otherBackend.Addr().(*net.TCPAddr).Port},
+		},
+	})
+	ctx.Settings.Pools = []application.PoolSettings{
+		{Name: "alpha", Pool: poolSettings},
+		{Name: "beta", Pool: poolSettings},
+	}
+
+	addr := startTestListener(t, ctx, map[string]cntrmgr.ContainerManager{
+		"alpha": &TestEchoContainerManager{backend: alphaBackend},
+		"beta":  &TestEchoContainerManager{backend: betaBackend},
+	})
+	defer ctx.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+
+	negotiate := func(protocols ...string) (string, string, error) {
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: roots, NextProtos: protocols})
+		if err != nil {
+			return "", "", err
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("hello"))
+		conn.CloseWrite()
+		data, err := ioutil.ReadAll(conn)
+
+		return conn.ConnectionState().NegotiatedProtocol, string(data), err
+	}
+
+	t.Run("AlphaProtocol", func(t *testing.T) {
+		protocol, response, err := negotiate("alpha/1")
+		assert.Nil(t, err)
+		assert.Equal(t, "alpha/1", protocol)
+		assert.Equal(t, "alpha:hello", response)
+	})
+
+	t.Run("BetaProtocol", func(t *testing.T) {
+		protocol, response, err := negotiate("beta/1")
+		assert.Nil(t, err)
+		assert.Equal(t, "beta/1", protocol)
+		assert.Equal(t, "beta:hello", response)
+	})
+
+	t.Run("ServerPreferenceOrder", func(t *testing.T) {
+		protocol, response, err := negotiate("beta/1", "alpha/1")
+		assert.Nil(t, err)
+		assert.Equal(t, "alpha/1", protocol)
+		assert.Equal(t, "alpha:hello", response)
+	})
+
+	t.Run("BackendPortOverride", func(t *testing.T) {
+		protocol, response, err := negotiate("alpha/2")
+		assert.Nil(t, err)
+		assert.Equal(t, "alpha/2", protocol)
+		assert.Equal(t, "other:hello", response)
+	})
+
+	t.Run("NoProtocolUsesDefaultPool", func(t *testing.T) {
+		protocol, response, err := negotiate()
+		assert.Nil(t, err)
+		assert.Equal(t, "", protocol)
+		assert.Equal(t, "alpha:hello", response)
+	})
+
+	t.Run("UnknownProtocolRejected", func(t *testing.T) {
+		_, _, err := negotiate("gamma/1")
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/nextmetaphor/tcp-proxy-pool/ratelimit"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	logFieldClientAddress = "client-address"
+)
+
+type (
+	// session holds the state of a single client connection from the point it is accepted until it is closed
+	session struct {
+		serverConn net.Conn
+		listener   *proxyListener
+
+		// route is the pool selected by the client; until it is known the monitor and logger are not pool-specific.
+		// These are protected by the Context lock as they are read when the session is terminated, but may be read
+		// without it by the goroutine servicing the session, as this is the only one to write them.
+		route   *poolRoute
+		monitor monitor.Monitor
+		logger  *logrus.Entry
+
+		// container and backendConn are protected by the Context lock as they are read when sessions are forcibly
+		// closed; backendConn is the connection to the container, which is nil until it has been established
+		container   *cntr.Container
+		backendConn net.Conn
+
+		// start is when the client connection was accepted; lastActivity and terminationReason are protected by
+		// lock as they are updated whilst data is being copied
+		start             time.Time
+		lock              sync.Mutex
+		lastActivity      time.Time
+		terminationReason string
+
+		// bytesCopied is the total copied in both directions, protected by lock; bandwidth limits the rate of the
+		// session in both directions combined and is nil if there is no limit
+		bytesCopied int64
+		bandwidth   *ratelimit.Bucket
+
+		// done is closed once the session has been terminated or forcibly closed, so that copies waiting on the
+		// bandwidth limits stop doing so
+		done     chan struct{}
+		doneOnce sync.Once
+	}
+)
+
+// newSession creates a session for the client connection provided, which was accepted by the listener provided
+func (ctx *Context) newSession(pl *proxyListener, serverConn net.Conn) *session {
+	now := time.Now()
+
+	return &session{
+		serverConn:   serverConn,
+		start:        now,
+		lastActivity: now,
+		listener:     pl,
+		bandwidth:    ratelimit.NewBucket(pl.settings.Bandwidth.SessionBytesPerSec, pl.settings.Bandwidth.BurstBytes),
+		monitor:      pl.monitor,
+		logger:       pl.logger.WithField(logFieldClientAddress, serverConn.RemoteAddr().String()),
+		done:         make(chan struct{}),
+	}
+}
+
+// stop closes the done channel of the session, should it not already have been closed
+func (sess *session) stop() {
+	sess.doneOnce.Do(func() {
+		close(sess.done)
+	})
+}
+
+// setSessionRoute records the pool selected by the client, so that subsequent monitor points and logs are tagged
+// with it
+func (ctx *Context) setSessionRoute(sess *session, route *poolRoute) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	sess.route = route
+	sess.monitor = route.monitor
+	sess.logger = sess.logger.WithField(logFieldPool, route.name)
+}
+
+// addSessionLogFields adds the fields provided to every subsequent log of the session, returning its new logger
+func (ctx *Context) addSessionLogFields(sess *session, fields logrus.Fields) *logrus.Entry {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	sess.logger = sess.logger.WithFields(fields)
+
+	return sess.logger
+}
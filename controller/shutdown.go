@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+const (
+	// forceCloseGracePeriod is the time given for sessions to finish once their connections have been forcibly closed
+	forceCloseGracePeriod = 5 * time.Second
+	// statisticsShutdownTimeout is the time given for the statistics server to complete outstanding requests
+	statisticsShutdownTimeout = 5 * time.Second
+
+	logMsgShutdownStarted      = "shutdown started; no longer accepting connections"
+	logMsgSessionsDrained      = "all sessions drained"
+	logMsgForceClosingSessions = "drain timeout reached; forcibly closing remaining sessions"
+	logMsgSessionsNotClosed    = "sessions still active after being forcibly closed"
+	logMsgShutdownComplete     = "shutdown complete"
+
+	logFieldActiveSessions = "active-sessions"
+	logFieldDrainTimeout   = "drain-timeout"
+
+	logErrorClosingListener    = "Error closing listener"
+	logErrorDestroyingPool     = "Error destroying container pool"
+	logErrorStoppingStatistics = "Error stopping statistics server"
+)
+
+// shuttingDown returns true once Shutdown has been called
+func (ctx *Context) shuttingDown() bool {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	return ctx.isShuttingDown
+}
+
+// beginSession registers a newly-accepted client connection so that it can be drained on shutdown. It returns nil
+// if the server is shutting down, in which case the connection should not be serviced.
+func (ctx *Context) beginSession(pl *proxyListener, serverConn net.Conn) *session {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if ctx.isShuttingDown {
+		return nil
+	}
+	if ctx.sessions == nil {
+		ctx.sessions = make(map[net.Conn]*session)
+	}
+	sess := ctx.newSession(pl, serverConn)
+	ctx.sessions[serverConn] = sess
+	ctx.sessionsActive.Add(1)
+
+	return sess
+}
+
+// setSessionContainer records the container which has been associated with the session, so that both sides of the
+// session can be closed should this be forced on shutdown
+func (ctx *Context) setSessionContainer(sess *session, c *cntr.Container) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	sess.container = c
+}
+
+// setSessionBackend records the connection to the container of the session once it has been established, so that
+// it can be closed should this be forced on shutdown
+func (ctx *Context) setSessionBackend(sess *session, conn net.Conn) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	sess.backendConn = conn
+}
+
+// endSession is called once a client connection has been completely serviced
+func (ctx *Context) endSession(sess *session) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	delete(ctx.sessions, sess.serverConn)
+	ctx.sessionsActive.Done()
+}
+
+// waitForSessions waits for all active sessions to end, returning false if they did not do so within the timeout
+func (ctx *Context) waitForSessions(timeout time.Duration) bool {
+	drained := make(chan struct{})
+	go func() {
+		ctx.sessionsActive.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// forceCloseSessions terminates every session which is still active, closing both its client and container connections
+func (ctx *Context) forceCloseSessions() {
+	ctx.lock.Lock()
+	sessions := make([]*session, 0, len(ctx.sessions))
+	for _, sess := range ctx.sessions {
+		sessions = append(sessions, sess)
+	}
+	ctx.lock.Unlock()
+
+	ctx.Logger.WithFields(logrus.Fields{logFieldActiveSessions: len(sessions)}).Warn(logMsgForceClosingSessions)
+
+	for _, sess := range sessions {
+		ctx.terminateSession(sess, TerminationShutdown)
+	}
+}
+
+// drainTimeout returns the time given for active sessions to complete on shutdown, which is the longest of the
+// DrainTimeoutSec settings of the listeners
+func (ctx *Context) drainTimeout() time.Duration {
+	var timeout time.Duration
+	for _, ls := range ctx.Settings.ListenerSettings() {
+		if t := time.Duration(ls.DrainTimeoutSec) * time.Second; t > timeout {
+			timeout = t
+		}
+	}
+
+	return timeout
+}
+
+// Shutdown stops the listeners from accepting any further connections, allows active sessions to drain for up to
+// the longest DrainTimeoutSec of the listeners before forcibly closing them, and then destroys every container in every pool.
+// Containers handed over to a new process by Upgrade are no longer part of the pools, so are left running.
+// Finally the statistics server is stopped. The monitor connection is left open for the caller to close, so that
+// any points written during shutdown can be flushed.
+func (ctx *Context) Shutdown() {
+	ctx.lock.Lock()
+	ctx.isShuttingDown = true
+	listeners := ctx.listeners
+	pools := ctx.ContainerPools
+	ctx.lock.Unlock()
+
+	drainTimeout := ctx.drainTimeout()
+	ctx.Logger.WithFields(logrus.Fields{logFieldDrainTimeout: drainTimeout}).Warn(logMsgShutdownStarted)
+
+	for _, pl := range listeners {
+		pl.accessControl.close()
+		pl.router.certificates.close()
+		if err := pl.netListener.Close(); err != nil {
+			log.ErrorEntry(logErrorClosingListener, err, pl.logger)
+		}
+	}
+
+	if ctx.waitForSessions(drainTimeout) {
+		ctx.Logger.Info(logMsgSessionsDrained)
+	} else {
+		ctx.forceCloseSessions()
+		if !ctx.waitForSessions(forceCloseGracePeriod) {
+			ctx.Logger.Warn(logMsgSessionsNotClosed)
+		}
+	}
+
+	for name, pool := range pools {
+		for _, err := range pool.DestroyPool() {
+			log.ErrorEntry(logErrorDestroyingPool, err, ctx.Logger.WithField(logFieldPool, name))
+		}
+	}
+
+	ctx.stopStatistics()
+
+	ctx.Logger.Warn(logMsgShutdownComplete)
+}
+
+// stopStatistics stops the statistics server, should it be running, giving it time to complete outstanding requests.
+// It returns true if the server was running.
+func (ctx *Context) stopStatistics() bool {
+	ctx.lock.Lock()
+	statisticsServer := ctx.statisticsServer
+	ctx.statisticsServer = nil
+	ctx.lock.Unlock()
+
+	if statisticsServer == nil {
+		return false
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), statisticsShutdownTimeout)
+	defer cancel()
+	if err := statisticsServer.Shutdown(shutdownCtx); err != nil {
+		log.Error(logErrorStoppingStatistics, err, ctx.Logger)
+	}
+
+	return true
+}
+
+// restartStatistics starts the statistics server again once it has been stopped by stopStatistics, logging any error
+// as the settings have not changed since it was last started
+func (ctx *Context) restartStatistics() {
+	if err := ctx.StartStatistics(); err != nil {
+		log.Error(logErrorStartingStatistics, err, ctx.Logger)
+	}
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// loggedMessages returns whether each of the messages provided has been logged
+func loggedMessages(hook *test.Hook, messages ...string) []bool {
+	logged := make([]bool, len(messages))
+	for _, entry := range hook.AllEntries() {
+		for i, msg := range messages {
+			if entry.Message == msg {
+				logged[i] = true
+			}
+		}
+	}
+
+	return logged
+}
+
+func Test_Shutdown(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	t.Run("Drain", func(t *testing.T) {
+		ctx, hook := createTimeoutTestContext(application.ListenerSettings{
+			Mode:            application.ListenerModeTCP,
+			DrainTimeoutSec: 10,
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, &TestEchoContainerManager{backend: backend}))
+
+		conn := openSession(t, addr.String())
+
+		// the session is still serviced whilst draining, and shutdown completes as soon as the client closes it
+		shutdown := make(chan struct{})
+		go func() {
+			ctx.Shutdown()
+			close(shutdown)
+		}()
+		for !ctx.shuttingDown() {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		conn.Write([]byte("pong"))
+		response := make([]byte, 4)
+		_, err := conn.Read(response)
+		assert.Nil(t, err)
+		assert.Equal(t, "pong", string(response))
+		conn.Close()
+
+		select {
+		case <-shutdown:
+		case <-time.After(5 * time.Second):
+			t.Fatal("shutdown did not complete once the session was closed")
+		}
+		assert.Equal(t, []bool{true, false}, loggedMessages(hook, logMsgSessionsDrained, logMsgForceClosingSessions))
+	})
+
+	t.Run("ForceClose", func(t *testing.T) {
+		ctx, hook := createTimeoutTestContext(application.ListenerSettings{
+			Mode:            application.ListenerModeTCP,
+			DrainTimeoutSec: 1,
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, &TestEchoContainerManager{backend: backend}))
+
+		conn := openSession(t, addr.String())
+		defer conn.Close()
+
+		// the session is left open, so is forcibly closed once the drain timeout is reached
+		started := time.Now()
+		ctx.Shutdown()
+		assert.True(t, time.Since(started) < forceCloseGracePeriod)
+
+		data, err := ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(data))
+		assert.Equal(t, []bool{false, true, false},
+			loggedMessages(hook, logMsgSessionsDrained, logMsgForceClosingSessions, logMsgSessionsNotClosed))
+
+		// the session is recorded as terminated by the shutdown, rather than its copy failing
+		assert.Equal(t, []bool{true, false}, loggedMessages(hook, logMsgSessionTerminated, logErrorCopying))
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == logMsgSessionTerminated {
+				assert.Equal(t, TerminationShutdown, entry.Data[logFieldTerminationReason])
+			}
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+
+	// systemdFirstFD is the first file descriptor passed by systemd; the rest follow consecutively
+	systemdFirstFD = 3
+
+	errorSocketPathRequired     = "a socket path is required for a unix listener"
+	errorInvalidSocketMode      = "invalid socket mode: "
+	errorSocketPathInUse        = "socket path exists and is not a socket: "
+	errorNoSystemdSockets       = "no sockets were passed by systemd"
+	errorInvalidSystemdFDs      = "invalid " + envListenFDs + ": "
+	errorSystemdNameRequired    = "a systemd name is required as more than one socket was passed by systemd"
+	errorUnknownSystemdSocket   = "no socket was passed by systemd with the name: "
+	errorSystemdSocketInUse     = "socket passed by systemd is already used by another listener: "
+	errorSystemdSocketNotStream = "socket passed by systemd is not a stream socket: "
+)
+
+type (
+	// systemdSockets holds the sockets passed to the process by systemd socket activation, each of which may be used
+	// by only one listener
+	systemdSockets struct {
+		lock    sync.Mutex
+		loaded  bool
+		err     error
+		names   []string
+		files   []*os.File
+		claimed []bool
+	}
+)
+
+// netListen creates the network listener for the listener settings provided: a TCP socket on the host and port, a
+// Unix domain socket at the socket path, or a socket passed by systemd. A socket handed over by a previous process on
+// upgrade is used in preference to any of these.
+func (ctx *Context) netListen(ls application.ListenerSettings) (net.Listener, error) {
+	if listener, err := ctx.inherited.listener(ls); listener != nil || err != nil {
+		return listener, err
+	}
+
+	switch ls.Transport {
+	case application.TransportUnix:
+		return listenUnix(ls)
+	case application.TransportSystemd:
+		return ctx.systemd.listener(ls.SystemdName)
+	}
+
+	return net.Listen(ls.Transport, ls.Host+":"+ls.Port)
+}
+
+// listenAddress describes the address that the listener settings provided listen on, for logging purposes
+func listenAddress(ls application.ListenerSettings) string {
+	switch ls.Transport {
+	case application.TransportUnix:
+		return ls.Transport + ":" + ls.SocketPath
+	case application.TransportSystemd:
+		return ls.Transport + ":" + ls.SystemdName
+	}
+
+	return ls.Transport + ":" + ls.Host + ":" + ls.Port
+}
+
+// listenUnix creates a Unix domain socket at the socket path, replacing any socket left behind by a previous process,
+// creating it with the permissions of the socket mode if there is one
+func listenUnix(ls application.ListenerSettings) (net.Listener, error) {
+	if ls.SocketPath == "" {
+		return nil, errors.New(errorSocketPathRequired)
+	}
+
+	var mode os.FileMode
+	if ls.SocketMode != "" {
+		m, err := strconv.ParseUint(ls.SocketMode, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, errors.New(errorInvalidSocketMode + ls.SocketMode)
+		}
+		mode = os.FileMode(m)
+	}
+
+	// a socket which already exists cannot be bound to, so remove it; anything else is left well alone
+	if info, err := os.Lstat(ls.SocketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(errorSocketPathInUse + ls.SocketPath)
+		}
+		if err := os.Remove(ls.SocketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen(application.TransportUnix, ls.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mode is set once the socket exists rather than by changing the umask, as that is process-wide and would
+	// affect any other files being created at the same time
+	if ls.SocketMode != "" {
+		if err := os.Chmod(ls.SocketPath, mode); err != nil {
+			// we're not going to act on Close errors, so ignore purposefully
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// parseSystemdEnv returns the number of sockets passed by systemd to the process with the pid provided, together with
+// their names, using the environment variables of the socket activation protocol
+func parseSystemdEnv(getenv func(string) string, pid int) (int, []string, error) {
+	if getenv(envListenPID) != strconv.Itoa(pid) {
+		return 0, nil, errors.New(errorNoSystemdSockets)
+	}
+
+	count, err := strconv.Atoi(getenv(envListenFDs))
+	if err != nil || count < 0 {
+		return 0, nil, errors.New(errorInvalidSystemdFDs + getenv(envListenFDs))
+	}
+	if count == 0 {
+		return 0, nil, errors.New(errorNoSystemdSockets)
+	}
+
+	names := make([]string, count)
+	if fdNames := getenv(envListenFDNames); fdNames != "" {
+		if split := strings.Split(fdNames, ":"); len(split) == count {
+			copy(names, split)
+		}
+	}
+
+	return count, names, nil
+}
+
+// load reads the sockets passed by systemd, if it has not already done so, and removes the socket activation
+// environment variables so that they are not inherited by any child process; the lock must be held
+func (s *systemdSockets) load() error {
+	if s.loaded {
+		return s.err
+	}
+	s.loaded = true
+
+	count, names, err := parseSystemdEnv(os.Getenv, os.Getpid())
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenFDNames)
+	if err != nil {
+		s.err = err
+		return err
+	}
+
+	s.names = names
+	s.claimed = make([]bool, count)
+	for i := 0; i < count; i++ {
+		s.files = append(s.files, os.NewFile(uintptr(systemdFirstFD+i), names[i]))
+	}
+
+	return nil
+}
+
+// listener returns a listener for the socket passed by systemd with the name provided; if name is empty then exactly
+// one socket must have been passed
+func (s *systemdSockets) listener(name string) (net.Listener, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	index := -1
+	if name == "" {
+		if len(s.files) != 1 {
+			return nil, errors.New(errorSystemdNameRequired)
+		}
+		index = 0
+	} else {
+		for i, n := range s.names {
+			if n == name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, errors.New(errorUnknownSystemdSocket + name)
+		}
+	}
+
+	if s.claimed[index] {
+		return nil, errors.New(errorSystemdSocketInUse + s.names[index])
+	}
+
+	listener, err := net.FileListener(s.files[index])
+	if err != nil {
+		return nil, errors.New(errorSystemdSocketNotStream + s.names[index] + ": " + err.Error())
+	}
+	s.claimed[index] = true
+
+	return listener, nil
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ParseSystemdEnv(t *testing.T) {
+	env := func(values map[string]string) func(string) string {
+		return func(key string) string { return values[key] }
+	}
+
+	t.Run("NamedSockets", func(t *testing.T) {
+		count, names, err := parseSystemdEnv(env(map[string]string{
+			envListenPID:     "42",
+			envListenFDs:     "2",
+			envListenFDNames: "external:internal",
+		}), 42)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, []string{"external", "internal"}, names)
+	})
+
+	t.Run("UnnamedSockets", func(t *testing.T) {
+		count, names, err := parseSystemdEnv(env(map[string]string{envListenPID: "42", envListenFDs: "1"}), 42)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+		assert.Equal(t, []string{""}, names)
+	})
+
+	t.Run("AnotherProcess", func(t *testing.T) {
+		_, _, err := parseSystemdEnv(env(map[string]string{envListenPID: "41", envListenFDs: "1"}), 42)
+		assert.Equal(t, errorNoSystemdSockets, err.Error())
+	})
+
+	t.Run("InvalidCount", func(t *testing.T) {
+		_, _, err := parseSystemdEnv(env(map[string]string{envListenPID: "42", envListenFDs: "x"}), 42)
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_SystemdSocketsNotPassed(t *testing.T) {
+	os.Unsetenv(envListenPID)
+
+	var s systemdSockets
+	_, err := s.listener("")
+	assert.Equal(t, errorNoSystemdSockets, err.Error())
+}
+
+func Test_UnixSocketListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	dir, _ := ioutil.TempDir("", "unix-listener")
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "proxy.sock")
+
+	// a stale socket left behind by a previous process is replaced
+	stale, err := net.Listen("unix", socketPath)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+	ctx.Settings.Listener.Transport = application.TransportUnix
+	ctx.Settings.Listener.SocketPath = socketPath
+	ctx.Settings.Listener.SocketMode = "0600"
+	startTestListener(t, ctx, managersFor(ctx, cm))
+
+	info, err := os.Stat(socketPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", socketPath)
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello"))
+	conn.(*net.UnixConn).CloseWrite()
+	data, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	ctx.Shutdown()
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_ListenUnixErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "unix-listener")
+	defer os.RemoveAll(dir)
+
+	_, err := listenUnix(application.ListenerSettings{})
+	assert.Equal(t, errorSocketPathRequired, err.Error())
+
+	_, err = listenUnix(application.ListenerSettings{SocketPath: filepath.Join(dir, "a.sock"), SocketMode: "999"})
+	assert.Equal(t, errorInvalidSocketMode+"999", err.Error())
+
+	// a regular file is never removed
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, []byte("data"), 0600)
+	_, err = listenUnix(application.ListenerSettings{SocketPath: file})
+	assert.Equal(t, errorSocketPathInUse+file, err.Error())
+}
+
+func Test_ListenUnixMode(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "unix-listener")
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "proxy.sock")
+
+	// the mode is applied to the socket in full, whatever the umask of the process, and the umask is left unchanged
+	umask := syscall.Umask(0022)
+	defer syscall.Umask(umask)
+
+	l, err := listenUnix(application.ListenerSettings{SocketPath: socketPath, SocketMode: "0666"})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	info, err := os.Stat(socketPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0666), info.Mode().Perm())
+	assert.Equal(t, 0022, syscall.Umask(0022))
+}
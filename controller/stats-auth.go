@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/preamble"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// roleNone, roleReadOnly and roleAdmin are the roles of statistics clients, each of which may do everything
+	// that the roles before it may
+	roleNone statisticsRole = iota
+	roleReadOnly
+	roleAdmin
+
+	// defaultStatisticsAddress is the address of the statistics server, unless set
+	defaultStatisticsAddress = "localhost:8080"
+
+	bearerPrefix = "Bearer "
+
+	logMsgStatisticsRequest = "statistics request"
+
+	logFieldMethod        = "method"
+	logFieldPath          = "path"
+	logFieldStatus        = "status"
+	logFieldRemoteAddress = "remote-address"
+	logFieldDurationMs    = "duration-ms"
+
+	errorStatisticsCertRequired     = "statistics server: a certificate and key are required to verify client certificates"
+	errorStatisticsClientCARequired = "statistics server: a client CA file is required to authenticate clients by certificate"
+	errorStatisticsAuthRequired     = "statistics server: authentication is required unless listening on a loopback address"
+	errorUnauthenticated            = "authentication required"
+	errorForbidden                  = "client does not have the role required"
+)
+
+type (
+	// statisticsRole is the role of a client of the statistics server
+	statisticsRole int
+
+	// statisticsAuth authenticates requests to the statistics server, determining the role of each client
+	statisticsAuth struct {
+		readOnlyTokens  *preamble.TokenList
+		adminTokens     *preamble.TokenList
+		readOnlyClients []string
+		adminClients    []string
+	}
+
+	// statusRecorder records the status written to the response, so that it can be logged
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+// statisticsAddress returns the address that the statistics server should listen on
+func statisticsAddress(s application.StatisticsSettings) string {
+	if s.Address == "" {
+		return defaultStatisticsAddress
+	}
+
+	return s.Address
+}
+
+// isLoopbackAddress returns true if the address provided only listens on a loopback interface. An address without a
+// host listens on every interface, so is not.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// statisticsTLSConfig returns the TLS config of the statistics server, or nil if TLS is not used. If a client CA
+// file is configured then client certificates are verified against it, although they are not required as clients
+// may authenticate using a token instead.
+func statisticsTLSConfig(s application.StatisticsSettings) (*tls.Config, error) {
+	if s.CertFile == "" && s.KeyFile == "" {
+		if s.ClientCAFile != "" {
+			return nil, errors.New(errorStatisticsCertRequired)
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if s.ClientCAFile != "" {
+		caCerts, err := loadCertificates(s.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		for _, caCert := range caCerts {
+			config.ClientCAs.AddCert(caCert)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// newStatisticsAuth creates the authentication described by the settings provided, reading any token files, and
+// returns nil if requests are not to be authenticated
+func newStatisticsAuth(s application.StatisticsSettings) (*statisticsAuth, error) {
+	if s.ReadOnlyTokenFile == "" && s.AdminTokenFile == "" && len(s.ReadOnlyClients) == 0 && len(s.AdminClients) == 0 {
+		return nil, nil
+	}
+	if (len(s.ReadOnlyClients) > 0 || len(s.AdminClients) > 0) && s.ClientCAFile == "" {
+		return nil, errors.New(errorStatisticsClientCARequired)
+	}
+
+	a := &statisticsAuth{readOnlyClients: s.ReadOnlyClients, adminClients: s.AdminClients}
+
+	var err error
+	if s.ReadOnlyTokenFile != "" {
+		if a.readOnlyTokens, err = preamble.ReadTokenFile(s.ReadOnlyTokenFile); err != nil {
+			return nil, err
+		}
+	}
+	if s.AdminTokenFile != "" {
+		if a.adminTokens, err = preamble.ReadTokenFile(s.AdminTokenFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// validToken returns true if the token provided is in the list, should there be one
+func validToken(list *preamble.TokenList, token string) bool {
+	if list == nil {
+		return false
+	}
+
+	_, err := list.Validate(token)
+	return err == nil
+}
+
+// role returns the role of the client making the request, and whether it authenticated at all. A bearer token is
+// used in preference to a client certificate; a client presenting an unknown token is not authenticated. Every
+// client has the admin role if there is no authentication, which is only allowed on a loopback address.
+func (a *statisticsAuth) role(request *http.Request) (statisticsRole, bool) {
+	if a == nil {
+		return roleAdmin, true
+	}
+
+	if header := request.Header.Get("Authorization"); header != "" {
+		if !strings.HasPrefix(header, bearerPrefix) {
+			return roleNone, false
+		}
+
+		token := strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix))
+		switch {
+		case validToken(a.adminTokens, token):
+			return roleAdmin, true
+		case validToken(a.readOnlyTokens, token):
+			return roleReadOnly, true
+		}
+		return roleNone, false
+	}
+
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+		identity := cntr.IdentityFromCertificate(request.TLS.VerifiedChains[0][0])
+		switch {
+		case identity.Matches(a.adminClients):
+			return roleAdmin, true
+		case identity.Matches(a.readOnlyClients):
+			return roleReadOnly, true
+		}
+		return roleNone, true
+	}
+
+	return roleNone, false
+}
+
+// requireRole wraps the handler provided so that it is only called for clients with at least the role provided.
+// Clients which have not authenticated are sent 401 Unauthorized, and those without the role 403 Forbidden.
+func (ctx *Context) requireRole(a *statisticsAuth, required statisticsRole, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		role, authenticated := a.role(request)
+		if !authenticated {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			ctx.writeAdminError(writer, http.StatusUnauthorized, errors.New(errorUnauthenticated))
+			return
+		}
+		if role < required {
+			ctx.writeAdminError(writer, http.StatusForbidden, errors.New(errorForbidden))
+			return
+		}
+
+		handler(writer, request)
+	}
+}
+
+// WriteHeader records the status before writing it
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// accessLog wraps the handler provided so that every request is logged using the application logger
+func (ctx *Context) accessLog(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+
+		handler.ServeHTTP(recorder, request)
+
+		ctx.Logger.WithFields(logrus.Fields{
+			logFieldMethod:        request.Method,
+			logFieldPath:          request.URL.Path,
+			logFieldStatus:        recorder.status,
+			logFieldRemoteAddress: request.RemoteAddr,
+			logFieldDurationMs:    time.Since(start).Nanoseconds() / int64(time.Millisecond),
+		}).Info(logMsgStatisticsRequest)
+	})
+}
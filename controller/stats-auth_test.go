@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// statisticsStatus makes a request to the router, using the token provided if it is not empty, and returns the status
+func statisticsStatus(ctx *Context, auth *statisticsAuth, method, url, token string) int {
+	request := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	ctx.statisticsRouter(auth).ServeHTTP(recorder, request)
+
+	return recorder.Code
+}
+
+func Test_StatisticsTokenAuth(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	readOnlyFile := filepath.Join(pki.dir, "read-only.tokens")
+	ioutil.WriteFile(readOnlyFile, []byte("viewer\n"), 0600)
+	adminFile := filepath.Join(pki.dir, "admin.tokens")
+	ioutil.WriteFile(adminFile, []byte("operator\n"), 0600)
+
+	auth, err := newStatisticsAuth(application.StatisticsSettings{ReadOnlyTokenFile: readOnlyFile, AdminTokenFile: adminFile})
+	assert.Nil(t, err)
+
+	logger, _ := test.NewNullLogger()
+	ctx := &Context{Logger: logger}
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		ctx.statisticsRouter(auth).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, urlMonitor, nil))
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+
+		assert.Equal(t, http.StatusUnauthorized, statisticsStatus(ctx, auth, http.MethodGet, urlMonitor, "unknown"))
+
+		request := httptest.NewRequest(http.MethodGet, urlMonitor, nil)
+		request.SetBasicAuth("viewer", "viewer")
+		recorder = httptest.NewRecorder()
+		ctx.statisticsRouter(auth).ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, statisticsStatus(ctx, auth, http.MethodGet, urlMonitor, "viewer"))
+		assert.Equal(t, http.StatusNotFound, statisticsStatus(ctx, auth, http.MethodGet, "/pools/missing/containers", "viewer"))
+		assert.Equal(t, http.StatusForbidden, statisticsStatus(ctx, auth, http.MethodPost, "/pools/missing/health-check", "viewer"))
+		assert.Equal(t, http.StatusForbidden, statisticsStatus(ctx, auth, http.MethodDelete, "/pools/missing/containers/a", "viewer"))
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, statisticsStatus(ctx, auth, http.MethodGet, urlMonitor, "operator"))
+		assert.Equal(t, http.StatusNotFound, statisticsStatus(ctx, auth, http.MethodPost, "/pools/missing/health-check", "operator"))
+	})
+
+	t.Run("NoAuthentication", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, statisticsStatus(ctx, nil, http.MethodPost, "/pools/missing/health-check", ""))
+	})
+}
+
+func Test_StatisticsClientCertificateAuth(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+	certFile, keyFile := pki.issueFiles("server", true)
+
+	s := application.StatisticsSettings{
+		CertFile:        certFile,
+		KeyFile:         keyFile,
+		ClientCAFile:    pki.caFile(),
+		ReadOnlyClients: []string{"viewer"},
+		AdminClients:    []string{"operator"},
+	}
+	auth, err := newStatisticsAuth(s)
+	assert.Nil(t, err)
+	tlsConfig, err := statisticsTLSConfig(s)
+	assert.Nil(t, err)
+
+	logger, _ := test.NewNullLogger()
+	ctx := &Context{Logger: logger}
+
+	server := httptest.NewUnstartedServer(ctx.statisticsRouter(auth))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+	status := func(method, path, commonName string) int {
+		config := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+		if commonName != "" {
+			cert, _ := pki.issue(commonName, false)
+			config.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+
+		request, _ := http.NewRequest(method, server.URL+path, nil)
+		response, err := client.Do(request)
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		response.Body.Close()
+
+		return response.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, status(http.MethodGet, urlMonitor, ""))
+	assert.Equal(t, http.StatusForbidden, status(http.MethodGet, urlMonitor, "stranger"))
+	assert.Equal(t, http.StatusOK, status(http.MethodGet, urlMonitor, "viewer"))
+	assert.Equal(t, http.StatusForbidden, status(http.MethodPost, "/pools/missing/health-check", "viewer"))
+	assert.Equal(t, http.StatusNotFound, status(http.MethodPost, "/pools/missing/health-check", "operator"))
+}
+
+func Test_StatisticsSettings(t *testing.T) {
+	assert.Equal(t, defaultStatisticsAddress, statisticsAddress(application.StatisticsSettings{}))
+	assert.Equal(t, "0.0.0.0:9090", statisticsAddress(application.StatisticsSettings{Address: "0.0.0.0:9090"}))
+
+	config, err := statisticsTLSConfig(application.StatisticsSettings{})
+	assert.Nil(t, err)
+	assert.Nil(t, config)
+
+	_, err = statisticsTLSConfig(application.StatisticsSettings{ClientCAFile: "ca.crt"})
+	assert.Equal(t, errorStatisticsCertRequired, err.Error())
+
+	auth, err := newStatisticsAuth(application.StatisticsSettings{})
+	assert.Nil(t, err)
+	assert.Nil(t, auth)
+
+	_, err = newStatisticsAuth(application.StatisticsSettings{AdminClients: []string{"operator"}})
+	assert.Equal(t, errorStatisticsClientCARequired, err.Error())
+
+	_, err = newStatisticsAuth(application.StatisticsSettings{AdminTokenFile: "missing.tokens"})
+	assert.NotNil(t, err)
+
+	assert.True(t, isLoopbackAddress("localhost:8080"))
+	assert.True(t, isLoopbackAddress("127.0.0.1:8080"))
+	assert.True(t, isLoopbackAddress("[::1]:8080"))
+	assert.False(t, isLoopbackAddress(":8080"))
+	assert.False(t, isLoopbackAddress("0.0.0.0:8080"))
+	assert.False(t, isLoopbackAddress("10.0.0.1:8080"))
+	assert.False(t, isLoopbackAddress("localhost"))
+}
+
+func Test_StartStatistics(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+
+	t.Run("AuthenticationRequired", func(t *testing.T) {
+		ctx := &Context{Logger: logger}
+		ctx.Settings.Statistics.Address = ":0"
+
+		err := ctx.StartStatistics()
+		assert.Equal(t, errorStatisticsAuthRequired, err.Error())
+		assert.Nil(t, ctx.statisticsServer)
+	})
+
+	t.Run("InvalidSettings", func(t *testing.T) {
+		ctx := &Context{Logger: logger}
+		ctx.Settings.Statistics.AdminTokenFile = "missing.tokens"
+
+		assert.NotNil(t, ctx.StartStatistics())
+		assert.Nil(t, ctx.statisticsServer)
+	})
+
+	t.Run("Loopback", func(t *testing.T) {
+		ctx := &Context{Logger: logger}
+		ctx.Settings.Statistics.Address = "127.0.0.1:0"
+
+		assert.Nil(t, ctx.StartStatistics())
+		assert.True(t, ctx.stopStatistics())
+	})
+
+	t.Run("AddressInUse", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		defer listener.Close()
+
+		ctx := &Context{Logger: logger}
+		ctx.Settings.Statistics.Address = listener.Addr().String()
+
+		assert.NotNil(t, ctx.StartStatistics())
+		assert.Nil(t, ctx.statisticsServer)
+	})
+}
+
+func Test_StatisticsAccessLog(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	ctx := &Context{Logger: logger}
+
+	recorder := httptest.NewRecorder()
+	ctx.accessLog(ctx.statisticsRouter(nil)).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	entry := hook.LastEntry()
+	assert.Equal(t, logMsgStatisticsRequest, entry.Message)
+	assert.Equal(t, http.MethodGet, entry.Data[logFieldMethod])
+	assert.Equal(t, "/unknown", entry.Data[logFieldPath])
+	assert.Equal(t, http.StatusNotFound, entry.Data[logFieldStatus])
+}
@@ -1,42 +1,125 @@
 package controller
 
 import (
-	"net/http"
-	"github.com/gorilla/mux"
-	"github.com/gorilla/handlers"
 	"encoding/json"
-	"strconv"
-	"os"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
 	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"net"
+	"net/http"
 )
 
 const (
 	logCannotEncodeConnectionPool = "Cannot JSON encode connection pool"
-	logContainerPoolIsNil = "Container pool is nil"
-	urlMonitor = "/monitor"
+	logContainerPoolIsNil         = "Container pool is nil"
+	logStatisticsServerStarting   = "Statistics server starting on [%s]"
+	logErrorStartingStatistics    = "Error starting statistics server"
+	logCannotWriteMetrics         = "Cannot write metrics"
+	urlMonitor                    = "/monitor"
+	urlMetrics                    = "/metrics"
 )
 
-// StartStatistics is called when the application is ready to start the statistics service
-func (ctx *Context) StartStatistics() {
-	r := mux.NewRouter()
+// StartStatistics is called when the application is ready to start the statistics service. It is served as per
+// the Statistics settings in the background, and an error is returned should the settings be invalid or the server
+// be unable to listen.
+func (ctx *Context) StartStatistics() error {
+	s := ctx.Settings.Statistics
+
+	auth, err := newStatisticsAuth(s)
+	if err != nil {
+		return err
+	}
+	address := statisticsAddress(s)
+	if auth == nil && !isLoopbackAddress(address) {
+		return errors.New(errorStatisticsAuthRequired)
+	}
+	tlsConfig, err := statisticsTLSConfig(s)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
 	server := &http.Server{
-		Addr:    "localhost:" + strconv.Itoa(8080),
-		Handler: handlers.LoggingHandler(os.Stdout, r),
+		Addr:      address,
+		Handler:   ctx.accessLog(ctx.statisticsRouter(auth)),
+		TLSConfig: tlsConfig,
+	}
+
+	// make the server available so that it can be stopped on shutdown
+	ctx.lock.Lock()
+	if ctx.isShuttingDown {
+		ctx.lock.Unlock()
+		// we're not going to act on Close errors, so ignore purposefully
+		listener.Close()
+		return nil
 	}
+	ctx.statisticsServer = server
+	ctx.lock.Unlock()
 
-	r.HandleFunc(urlMonitor, ctx.handleStatisticsRequest).Methods(http.MethodGet)
+	ctx.Logger.Infof(logStatisticsServerStarting, listener.Addr())
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			// the certificate has already been loaded into the TLS config
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != http.ErrServerClosed {
+			log.Error(logErrorStartingStatistics, err, ctx.Logger)
+		}
+	}()
 
-	ctx.Logger.Error(server.ListenAndServe())
+	return nil
+}
 
+// statisticsRouter returns the router serving the statistics and metrics endpoints and the admin API, authenticating
+// requests using the auth provided; if this is nil then requests are not authenticated. The liveness and readiness
+// endpoints are also served, and are never authenticated so that they can be used by probes.
+func (ctx *Context) statisticsRouter(auth *statisticsAuth) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc(urlHealth, ctx.handleHealth).Methods(http.MethodGet)
+	r.HandleFunc(urlReady, ctx.handleReady).Methods(http.MethodGet)
+	r.HandleFunc(urlMonitor, ctx.requireRole(auth, roleReadOnly, ctx.handleStatisticsRequest)).Methods(http.MethodGet)
+	r.HandleFunc(urlMetrics, ctx.requireRole(auth, roleReadOnly, ctx.handleMetricsRequest)).Methods(http.MethodGet)
+	ctx.addAdminRoutes(r, auth)
+
+	return r
 }
 
+// handleStatisticsRequest responds with a JSON object holding a cntrpool.Snapshot of every container pool, keyed by
+// the pool name
 func (ctx *Context) handleStatisticsRequest(writer http.ResponseWriter, request *http.Request) {
-	if ctx.ContainerPool != nil {
-		if err := json.NewEncoder(writer).Encode(ctx.ContainerPool); err != nil {
+	ctx.lock.Lock()
+	pools := ctx.ContainerPools
+	ctx.lock.Unlock()
+
+	if pools != nil {
+		snapshots := make(map[string]cntrpool.Snapshot, len(pools))
+		for name, pool := range pools {
+			snapshots[name] = pool.Snapshot()
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(snapshots); err != nil {
 			log.Error(logCannotEncodeConnectionPool, err, ctx.Logger)
 			writer.WriteHeader(http.StatusInternalServerError)
 		}
 	} else {
 		ctx.Logger.Error(logContainerPoolIsNil)
 	}
-}
\ No newline at end of file
+}
+
+// handleMetricsRequest responds with the metrics accumulated by the monitor, in the Prometheus text format
+func (ctx *Context) handleMetricsRequest(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", monitor.MetricsContentType)
+	if _, err := ctx.Metrics.WriteTo(writer); err != nil {
+		log.Error(logCannotWriteMetrics, err, ctx.Logger)
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_StatisticsRequest(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	snapshots := func() map[string]cntrpool.Snapshot {
+		recorder := httptest.NewRecorder()
+		ctx.handleStatisticsRequest(recorder, httptest.NewRequest(http.MethodGet, urlMonitor, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+		var decoded map[string]cntrpool.Snapshot
+		assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&decoded))
+		return decoded
+	}
+
+	// keep a session open, having sent some data through it, whilst the snapshot is taken
+	conn, err := net.Dial("tcp", addr.String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello"))
+	response := make([]byte, 5)
+	_, err = conn.Read(response)
+	assert.Nil(t, err)
+
+	for name := range ctx.ContainerPools {
+		snapshot := snapshots()[name]
+		assert.Equal(t, cntrpool.SnapshotVersion, snapshot.Version)
+		assert.Equal(t, 1, snapshot.Totals.Busy)
+		assert.Equal(t, int64(10), snapshot.Totals.BytesTransferred)
+
+		for _, cs := range snapshot.Containers {
+			if cs.Busy {
+				assert.Equal(t, conn.LocalAddr().String(), cs.ClientAddress)
+				assert.NotNil(t, cs.SessionStart)
+			}
+		}
+	}
+}
+
+func Test_MetricsRequest(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	// complete a session so that every metric has been written
+	conn, err := net.Dial("tcp", addr.String())
+	assert.Nil(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello"))
+	conn.(*net.TCPConn).CloseWrite()
+	response, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(response))
+	conn.Close()
+
+	metrics := func() string {
+		recorder := httptest.NewRecorder()
+		ctx.statisticsRouter(nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, urlMetrics, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, monitor.MetricsContentType, recorder.Header().Get("Content-Type"))
+		return recorder.Body.String()
+	}
+
+	// the session duration is written once the proxy has closed both connections, and the container is returned to
+	// the pool after that
+	var text string
+	for i := 0; i < 100; i++ {
+		text = metrics()
+		if strings.Contains(text, `tcp_proxy_pool_session_duration_seconds_count{pool="default"} 1`) &&
+			strings.Contains(text, `tcp_proxy_pool_containers_used{pool="default"} 0`) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, sample := range []string{
+		`tcp_proxy_pool_connections_accepted_total{pool="default"} 1`,
+		`tcp_proxy_pool_bytes_copied_total{pool="default",direction="to-client"} 5`,
+		`tcp_proxy_pool_bytes_copied_total{pool="default",direction="to-container"} 5`,
+		`tcp_proxy_pool_containers_used{pool="default"} 0`,
+		`tcp_proxy_pool_containers_starting{pool="default"} 0`,
+		`tcp_proxy_pool_container_create_seconds_count{pool="default"} 2`,
+		`tcp_proxy_pool_backend_dial_seconds_count{pool="default"} 1`,
+		`tcp_proxy_pool_session_duration_seconds_count{pool="default"} 1`,
+	} {
+		assert.Contains(t, text, sample+"\n")
+	}
+}
+
+func Test_MetricsRequestDenied(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	cm := &TestEchoContainerManager{backend: backend}
+	ctx := createTestContext(application.ListenerSettings{
+		Mode:          application.ListenerModeTCP,
+		AccessControl: application.AccessControlSettings{Deny: []string{"127.0.0.0/8"}},
+	})
+	addr := startTestListener(t, ctx, managersFor(ctx, cm))
+	defer ctx.Shutdown()
+
+	conn, err := net.Dial("tcp", addr.String())
+	assert.Nil(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	conn.Close()
+
+	// the connection is denied before being routed to a pool, so is only labelled with its listener
+	recorder := httptest.NewRecorder()
+	ctx.statisticsRouter(nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, urlMetrics, nil))
+	assert.Contains(t, recorder.Body.String(),
+		`tcp_proxy_pool_connections_rejected_total{pool="",listener="default",reason="denied"} 1`+"\n")
+}
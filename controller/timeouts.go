@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"net"
+	"time"
+)
+
+const (
+	// TerminationIdleTimeout is recorded when a session is closed as no data was sent in either direction for the
+	// configured idle timeout
+	TerminationIdleTimeout = "idle-timeout"
+	// TerminationMaxDuration is recorded when a session is closed as it reached the configured maximum duration
+	TerminationMaxDuration = "max-duration"
+	// TerminationHandshakeTimeout is recorded when a client did not complete the TLS handshake within the
+	// configured timeout
+	TerminationHandshakeTimeout = "handshake-timeout"
+	// TerminationShutdown is recorded when a session is forcibly closed as it did not drain before shutdown
+	TerminationShutdown = "shutdown"
+
+	logMsgSessionTerminated = "session terminated"
+
+	logFieldTerminationReason = "termination-reason"
+)
+
+// markActivity records that data has just been sent in one direction of the session
+func (sess *session) markActivity() {
+	sess.lock.Lock()
+	sess.lastActivity = time.Now()
+	sess.lock.Unlock()
+}
+
+// terminated returns the reason that the session was terminated by the proxy, or an empty string if it has not been
+func (sess *session) terminated() string {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	return sess.terminationReason
+}
+
+// terminateSession closes both sides of the session for the reason provided, recording this in the log and the
+// monitor. Only the first reason is recorded should the session be terminated more than once.
+func (ctx *Context) terminateSession(sess *session, reason string) {
+	sess.lock.Lock()
+	if sess.terminationReason != "" {
+		sess.lock.Unlock()
+		return
+	}
+	sess.terminationReason = reason
+	sess.lock.Unlock()
+	sess.stop()
+
+	ctx.lock.Lock()
+	logger, m, backendConn := sess.logger, sess.monitor, sess.backendConn
+	ctx.lock.Unlock()
+
+	logger.WithField(logFieldTerminationReason, reason).Info(logMsgSessionTerminated)
+	m.WriteSessionTerminated(sess.serverConn, reason)
+
+	// we're not going to act on Close errors, so ignore purposefully
+	sess.serverConn.Close()
+	if backendConn != nil {
+		backendConn.Close()
+	}
+}
+
+// nextDeadline returns the time at which the session should be terminated, together with the reason for doing so,
+// based on its current activity; if neither timeout is configured then the zero time is returned
+func (sess *session) nextDeadline(idleTimeout, maxDuration time.Duration) (time.Time, string) {
+	var deadline time.Time
+	var reason string
+
+	if idleTimeout > 0 {
+		sess.lock.Lock()
+		deadline, reason = sess.lastActivity.Add(idleTimeout), TerminationIdleTimeout
+		sess.lock.Unlock()
+	}
+	if maxDuration > 0 {
+		if end := sess.start.Add(maxDuration); deadline.IsZero() || end.Before(deadline) {
+			deadline, reason = end, TerminationMaxDuration
+		}
+	}
+
+	return deadline, reason
+}
+
+// watchSession terminates the session should it become idle or reach its maximum duration, as per the listener
+// settings, returning once the session is terminated or done is closed
+func (ctx *Context) watchSession(sess *session, done <-chan struct{}) {
+	idleTimeout := time.Duration(sess.listener.settings.IdleTimeoutSec) * time.Second
+	maxDuration := time.Duration(sess.listener.settings.MaxSessionDurationSec) * time.Second
+
+	deadline, reason := sess.nextDeadline(idleTimeout, maxDuration)
+	if deadline.IsZero() {
+		return
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			// activity may have moved the deadline on since the timer was set, in which case wait for the new one
+			deadline, reason = sess.nextDeadline(idleTimeout, maxDuration)
+			if wait := time.Until(deadline); wait > 0 {
+				timer.Reset(wait)
+				continue
+			}
+
+			ctx.terminateSession(sess, reason)
+			return
+		}
+	}
+}
+
+// handshakeWithTimeout completes the TLS handshake with the client within the handshake timeout of the listener, if
+// there is one. The returned bool is true if the handshake failed due to the timeout.
+func handshakeWithTimeout(ls application.ListenerSettings, tlsConn *customTCPConn) (bool, error) {
+	timeout := time.Duration(ls.HandshakeTimeoutSec) * time.Second
+	if timeout <= 0 {
+		return false, tlsConn.handshake()
+	}
+
+	tlsConn.InnerConn.SetDeadline(time.Now().Add(timeout))
+	err := tlsConn.handshake()
+	tlsConn.InnerConn.SetDeadline(time.Time{})
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true, err
+	}
+
+	return false, err
+}
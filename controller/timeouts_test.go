@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// terminationReasons returns the reasons logged for each session terminated by the proxy
+func terminationReasons(hook *test.Hook) []string {
+	var reasons []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == logMsgSessionTerminated {
+			reasons = append(reasons, entry.Data[logFieldTerminationReason].(string))
+		}
+	}
+
+	return reasons
+}
+
+// createTimeoutTestContext creates a context as per createTestContext, with a logger whose entries can be inspected
+func createTimeoutTestContext(listenerSettings application.ListenerSettings) (*Context, *test.Hook) {
+	ctx := createTestContext(listenerSettings)
+	logger, hook := test.NewNullLogger()
+	ctx.Logger = logger
+
+	return ctx, hook
+}
+
+func Test_NextDeadline(t *testing.T) {
+	start := time.Now()
+	sess := &session{start: start, lastActivity: start.Add(time.Minute)}
+
+	deadline, _ := sess.nextDeadline(0, 0)
+	assert.True(t, deadline.IsZero())
+
+	deadline, reason := sess.nextDeadline(time.Minute, 0)
+	assert.Equal(t, start.Add(2*time.Minute), deadline)
+	assert.Equal(t, TerminationIdleTimeout, reason)
+
+	deadline, reason = sess.nextDeadline(time.Minute, 90*time.Second)
+	assert.Equal(t, start.Add(90*time.Second), deadline)
+	assert.Equal(t, TerminationMaxDuration, reason)
+
+	deadline, reason = sess.nextDeadline(time.Minute, time.Hour)
+	assert.Equal(t, TerminationIdleTimeout, reason)
+}
+
+func Test_SessionTimeouts(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		ctx, hook := createTimeoutTestContext(application.ListenerSettings{
+			Mode:           application.ListenerModeTCP,
+			IdleTimeoutSec: 1,
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, &TestEchoContainerManager{backend: backend}))
+		defer ctx.Shutdown()
+
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		// activity keeps the session alive beyond the idle timeout
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		buf := make([]byte, 4)
+		for i := 0; i < 3; i++ {
+			conn.Write([]byte("ping"))
+			_, err := conn.Read(buf)
+			assert.Nil(t, err)
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		// once idle, the session is closed by the proxy
+		started := time.Now()
+		data, err := ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(data))
+		assert.True(t, time.Since(started) < 5*time.Second)
+		assert.Equal(t, []string{TerminationIdleTimeout}, terminationReasons(hook))
+	})
+
+	t.Run("MaxSessionDuration", func(t *testing.T) {
+		ctx, hook := createTimeoutTestContext(application.ListenerSettings{
+			Mode:                  application.ListenerModeTCP,
+			IdleTimeoutSec:        10,
+			MaxSessionDurationSec: 1,
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, &TestEchoContainerManager{backend: backend}))
+		defer ctx.Shutdown()
+
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		// despite continual activity, the session is closed once it reaches its maximum duration
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		buf := make([]byte, 4)
+		for {
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				break
+			}
+			if _, err := conn.Read(buf); err != nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		assert.Equal(t, []string{TerminationMaxDuration}, terminationReasons(hook))
+	})
+
+	t.Run("HandshakeTimeout", func(t *testing.T) {
+		pki := newTestPKI(t)
+		defer pki.close()
+		certFile, keyFile := pki.issueFiles("server", true)
+
+		ctx, hook := createTimeoutTestContext(application.ListenerSettings{
+			CertFile:            certFile,
+			KeyFile:             keyFile,
+			HandshakeTimeoutSec: 1,
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, &TestEchoContainerManager{backend: backend}))
+		defer ctx.Shutdown()
+
+		// connect without ever starting the handshake
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		_, err = ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{TerminationHandshakeTimeout}, terminationReasons(hook))
+	})
+}
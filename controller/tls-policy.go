@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"os"
+	"strings"
+)
+
+const (
+	errorInvalidTLSPolicy       = "invalid TLS policy: "
+	errorUnknownTLSVersion      = "unknown TLS version: "
+	errorTLSVersionRange        = "minimum TLS version is greater than the maximum"
+	errorUnknownCipherSuite     = "unknown cipher suite: "
+	errorTLS13CipherSuite       = "TLS 1.3 cipher suites cannot be configured: "
+	errorUnknownCurve           = "unknown curve: "
+	errorOCSPStapleWithoutCert  = "an OCSP staple file requires a listener certificate"
+	errorInvalidSessionTicket   = "invalid session ticket key in "
+	errorNoSessionTicketKeys    = "no session ticket keys in "
+	errorSessionTicketsDisabled = "a session ticket key file cannot be used when session tickets are disabled"
+)
+
+var (
+	// tlsVersions maps the TLS versions which may be configured to their identifiers
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	// tlsCurves maps the elliptic curves which may be configured to their identifiers
+	tlsCurves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P256":   tls.CurveP256,
+		"P384":   tls.CurveP384,
+		"P521":   tls.CurveP521,
+	}
+)
+
+// tlsPolicy returns the TLS policy of the listener, reading it from the policy file should one be configured
+func tlsPolicy(ls application.ListenerSettings) (application.TLSPolicySettings, error) {
+	if ls.TLSPolicyFile == "" {
+		return ls.TLSPolicy, nil
+	}
+
+	policy, err := application.LoadTLSPolicy(ls.TLSPolicyFile)
+	if err != nil {
+		return application.TLSPolicySettings{}, errors.New(errorInvalidTLSPolicy + ls.TLSPolicyFile + ": " + err.Error())
+	}
+
+	return *policy, nil
+}
+
+// configureTLSPolicy applies the versions, cipher suites, curves and session ticket settings of the policy provided
+// to the TLS config; the session ticket keys themselves are loaded along with the certificates. Any invalid value is
+// reported as an error.
+func configureTLSPolicy(config *tls.Config, ls application.ListenerSettings) error {
+	policy := ls.TLSPolicy
+
+	var err error
+	if config.MinVersion, err = parseTLSVersion(policy.MinVersion); err != nil {
+		return errors.New(errorInvalidTLSPolicy + err.Error())
+	}
+	if config.MaxVersion, err = parseTLSVersion(policy.MaxVersion); err != nil {
+		return errors.New(errorInvalidTLSPolicy + err.Error())
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return errors.New(errorInvalidTLSPolicy + errorTLSVersionRange)
+	}
+
+	if config.CipherSuites, err = parseCipherSuites(policy.CipherSuites); err != nil {
+		return errors.New(errorInvalidTLSPolicy + err.Error())
+	}
+	if config.CurvePreferences, err = parseCurves(policy.Curves); err != nil {
+		return errors.New(errorInvalidTLSPolicy + err.Error())
+	}
+
+	if policy.OCSPStapleFile != "" && ls.CertFile == "" {
+		return errors.New(errorInvalidTLSPolicy + errorOCSPStapleWithoutCert)
+	}
+	if policy.SessionTicketsDisabled && policy.SessionTicketKeyFile != "" {
+		return errors.New(errorInvalidTLSPolicy + errorSessionTicketsDisabled)
+	}
+	config.SessionTicketsDisabled = policy.SessionTicketsDisabled
+
+	return nil
+}
+
+// parseTLSVersion returns the identifier of the TLS version provided, or zero if it is empty
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+
+	id, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.New(errorUnknownTLSVersion + version)
+	}
+
+	return id, nil
+}
+
+// parseCipherSuites returns the identifiers of the cipher suites named, which must be for TLS 1.2 or earlier as TLS
+// 1.3 suites cannot be configured
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := make(map[string]*tls.CipherSuite)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		suite, ok := suites[name]
+		if !ok {
+			return nil, errors.New(errorUnknownCipherSuite + name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, errors.New(errorTLS13CipherSuite + name)
+		}
+		ids = append(ids, suite.ID)
+	}
+
+	return ids, nil
+}
+
+// parseCurves returns the identifiers of the elliptic curves named, in the same order
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	var curves []tls.CurveID
+	for _, name := range names {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			return nil, errors.New(errorUnknownCurve + name)
+		}
+		curves = append(curves, curve)
+	}
+
+	return curves, nil
+}
+
+// readSessionTicketKeys reads the session ticket keys from the file provided, which holds one key per line as 64
+// hexadecimal characters; blank lines and those starting with # are ignored
+func readSessionTicketKeys(file string) ([][32]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys [][32]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var key [32]byte
+		decoded, err := hex.DecodeString(line)
+		if err != nil || len(decoded) != len(key) {
+			return nil, errors.New(errorInvalidSessionTicket + file)
+		}
+		copy(key[:], decoded)
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New(errorNoSessionTicketKeys + file)
+	}
+
+	return keys, nil
+}
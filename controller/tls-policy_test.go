@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ConfigureTLSPolicy(t *testing.T) {
+	t.Run("ValidPolicy", func(t *testing.T) {
+		config := &tls.Config{}
+		err := configureTLSPolicy(config, application.ListenerSettings{TLSPolicy: application.TLSPolicySettings{
+			MinVersion:   "1.2",
+			MaxVersion:   "1.3",
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			Curves:       []string{"X25519", "P256"},
+		}})
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+		assert.Equal(t, uint16(tls.VersionTLS13), config.MaxVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			config.CipherSuites)
+		assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, config.CurvePreferences)
+	})
+
+	t.Run("EmptyPolicyUsesDefaults", func(t *testing.T) {
+		config := &tls.Config{}
+		assert.Nil(t, configureTLSPolicy(config, application.ListenerSettings{}))
+		assert.Equal(t, uint16(0), config.MinVersion)
+		assert.Equal(t, uint16(0), config.MaxVersion)
+		assert.Nil(t, config.CipherSuites)
+		assert.Nil(t, config.CurvePreferences)
+		assert.False(t, config.SessionTicketsDisabled)
+	})
+
+	invalid := map[string]application.TLSPolicySettings{
+		errorUnknownTLSVersion + "1.4":                   {MinVersion: "1.4"},
+		errorUnknownTLSVersion + "TLS1.2":                {MaxVersion: "TLS1.2"},
+		errorTLSVersionRange:                             {MinVersion: "1.3", MaxVersion: "1.2"},
+		errorUnknownCipherSuite + "TLS_MADE_UP":          {CipherSuites: []string{"TLS_MADE_UP"}},
+		errorTLS13CipherSuite + "TLS_AES_128_GCM_SHA256": {CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+		errorUnknownCurve + "P224":                       {Curves: []string{"P224"}},
+		errorOCSPStapleWithoutCert:                       {OCSPStapleFile: "staple.der"},
+		errorSessionTicketsDisabled:                      {SessionTicketsDisabled: true, SessionTicketKeyFile: "keys"},
+	}
+	for expected, policy := range invalid {
+		t.Run(expected, func(t *testing.T) {
+			err := configureTLSPolicy(&tls.Config{}, application.ListenerSettings{TLSPolicy: policy})
+			assert.Equal(t, errorInvalidTLSPolicy+expected, err.Error())
+		})
+	}
+}
+
+func Test_ReadSessionTicketKeys(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	keyFile := func(content string) string {
+		file := filepath.Join(pki.dir, "ticket.keys")
+		ioutil.WriteFile(file, []byte(content), 0600)
+		return file
+	}
+
+	t.Run("ValidKeys", func(t *testing.T) {
+		keys, err := readSessionTicketKeys(keyFile("# current key first\n" + strings.Repeat("01", 32) + "\n\n" +
+			strings.Repeat("ab", 32) + "\n"))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(keys))
+		assert.Equal(t, byte(0x01), keys[0][31])
+		assert.Equal(t, byte(0xab), keys[1][0])
+	})
+
+	t.Run("ShortKey", func(t *testing.T) {
+		file := keyFile(strings.Repeat("01", 16))
+		_, err := readSessionTicketKeys(file)
+		assert.Equal(t, errorInvalidSessionTicket+file, err.Error())
+	})
+
+	t.Run("NoKeys", func(t *testing.T) {
+		file := keyFile("# nothing here\n")
+		_, err := readSessionTicketKeys(file)
+		assert.Equal(t, errorNoSessionTicketKeys+file, err.Error())
+	})
+}
+
+func Test_TLSPolicyListener(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+
+	pki := newTestPKI(t)
+	defer pki.close()
+	certFile, keyFile := pki.issueFiles("server", true)
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+
+	cm := &TestEchoContainerManager{backend: backend}
+
+	t.Run("MinimumVersion", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{
+			CertFile:  certFile,
+			KeyFile:   keyFile,
+			TLSPolicy: application.TLSPolicySettings{MinVersion: "1.3"},
+		})
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		_, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, ServerName: "127.0.0.1", MaxVersion: tls.VersionTLS12}, "old")
+		assert.NotNil(t, err)
+
+		response, err := tlsEcho(addr.String(), &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}, "new")
+		assert.Nil(t, err)
+		assert.Equal(t, "new", response)
+	})
+
+	t.Run("PolicyFileWithOCSPStaple", func(t *testing.T) {
+		staple := []byte{0x30, 0x03, 0x0a, 0x01, 0x00}
+		stapleFile := filepath.Join(pki.dir, "staple.der")
+		ioutil.WriteFile(stapleFile, staple, 0600)
+		policyFile := filepath.Join(pki.dir, "policy.json")
+		ioutil.WriteFile(policyFile, []byte(`{"OCSPStapleFile": "`+stapleFile+`"}`), 0600)
+
+		ctx := createTestContext(application.ListenerSettings{CertFile: certFile, KeyFile: keyFile, TLSPolicyFile: policyFile})
+		addr := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+		assert.Nil(t, err)
+		defer conn.Close()
+		assert.Equal(t, staple, conn.ConnectionState().OCSPResponse)
+	})
+
+	t.Run("SessionResumptionAcrossListeners", func(t *testing.T) {
+		ticketKeyFile := filepath.Join(pki.dir, "ticket.keys")
+		ioutil.WriteFile(ticketKeyFile, []byte(strings.Repeat("5a", 32)+"\n"), 0600)
+		listenerSettings := application.ListenerSettings{
+			CertFile:  certFile,
+			KeyFile:   keyFile,
+			TLSPolicy: application.TLSPolicySettings{SessionTicketKeyFile: ticketKeyFile},
+		}
+
+		first := createTestContext(listenerSettings)
+		firstAddr := startTestListener(t, first, managersFor(first, cm))
+		defer first.Shutdown()
+		second := createTestContext(listenerSettings)
+		secondAddr := startTestListener(t, second, managersFor(second, cm))
+		defer second.Shutdown()
+
+		// a session established with one replica can be resumed with the other, as both share the ticket keys
+		config := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1", ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+		_, err := tlsEcho(firstAddr.String(), config, "first")
+		assert.Nil(t, err)
+
+		conn, err := tls.Dial("tcp", secondAddr.String(), config)
+		assert.Nil(t, err)
+		defer conn.Close()
+		assert.True(t, conn.ConnectionState().DidResume)
+	})
+
+	t.Run("InvalidPolicy", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{
+			CertFile:  certFile,
+			KeyFile:   keyFile,
+			TLSPolicy: application.TLSPolicySettings{Curves: []string{"P999"}},
+		})
+		assert.False(t, ctx.StartListener(managersFor(ctx, cm)))
+	})
+}
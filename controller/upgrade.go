@@ -0,0 +1,504 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntrpool"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	// envUpgrade is set in the environment of the new process started on upgrade, which takes over the listener
+	// sockets and containers of the old process using the file descriptors below
+	envUpgrade = "TCP_PROXY_POOL_UPGRADE"
+
+	// upgradeStateFD is read by the new process for the description of the listeners and then the containers handed
+	// over to it, and upgradeReplyFD written by it to tell the old process how far it has got; the listener sockets
+	// follow consecutively from upgradeFirstListenerFD
+	upgradeStateFD         = 3
+	upgradeReplyFD         = 4
+	upgradeFirstListenerFD = 5
+
+	// upgradeStageTimeout is the time given for the new process to complete each stage of the handover
+	upgradeStageTimeout = 30 * time.Second
+
+	logMsgUpgradeStarted    = "upgrade started; handing over listeners to new process"
+	logMsgUpgradeListening  = "new process is listening; handing over unused containers"
+	logMsgUpgradeComplete   = "upgrade complete; new process has adopted the containers handed over"
+	logMsgInheritedListener = "using listener socket handed over by previous process"
+	logMsgAdoptedContainers = "adopted containers handed over by previous process"
+
+	logFieldPID               = "pid"
+	logFieldExecutable        = "executable"
+	logFieldAdoptedContainers = "adopted-containers"
+
+	logErrorReadingUpgradeState = "Error reading state handed over by previous process"
+	logErrorStoppingUpgrade     = "Error stopping new process after failed upgrade"
+
+	errorUpgradeInProgress     = "an upgrade is already in progress"
+	errorUpgradeShuttingDown   = "cannot upgrade once shutdown has started"
+	errorUpgradeNotListening   = "cannot upgrade before the listeners have started"
+	errorListenerNotHandedOver = "listener socket cannot be handed over: "
+	errorUpgradeTimeout        = "timed out waiting for new process"
+	errorUpgradeProcessExited  = "new process exited during upgrade"
+)
+
+type (
+	// upgradeListener describes a listener socket handed over on upgrade. The new process only uses it for the
+	// listener with the same name and address, so that listeners whose settings have changed are created afresh.
+	upgradeListener struct {
+		Name    string
+		Address string
+	}
+
+	// upgradeListening is written by the new process once its listeners have been created; only then does the old
+	// process release its unused containers to it
+	upgradeListening struct {
+		PID int
+	}
+
+	// upgradeAdopted is written by the new process once it has adopted the containers handed over, listing the IDs
+	// of those adopted by each pool; any others remain with the old process, which destroys them as normal
+	upgradeAdopted struct {
+		Containers map[string][]string
+	}
+
+	// inheritedState holds the listener sockets handed over to this process by a previous process on upgrade, together
+	// with the pipes used to take over its containers. It is only used whilst the listeners are being started.
+	inheritedState struct {
+		logger    *logrus.Logger
+		listeners []upgradeListener
+		files     []*os.File
+		state     *json.Decoder
+		stateFile io.Closer
+		reply     io.WriteCloser
+	}
+
+	// fileListener is implemented by network listeners whose socket can be duplicated, such as *net.TCPListener and
+	// *net.UnixListener
+	fileListener interface {
+		File() (*os.File, error)
+	}
+
+	// deadlineListener is implemented by network listeners whose Accept can be interrupted, such as *net.TCPListener
+	// and *net.UnixListener
+	deadlineListener interface {
+		SetDeadline(time.Time) error
+	}
+)
+
+// loadInheritedState returns the state handed over by a previous process should this process have been started by it
+// on upgrade, otherwise nil. The upgrade environment variable is removed so that it is not inherited by any further
+// process.
+func loadInheritedState(logger *logrus.Logger) *inheritedState {
+	if os.Getenv(envUpgrade) == "" {
+		return nil
+	}
+	os.Unsetenv(envUpgrade)
+
+	s, err := newInheritedState(logger,
+		os.NewFile(upgradeStateFD, "upgrade-state"),
+		os.NewFile(upgradeReplyFD, "upgrade-reply"),
+		func(i int) *os.File {
+			return os.NewFile(uintptr(upgradeFirstListenerFD+i), "upgrade-listener")
+		})
+	if err != nil {
+		log.Error(logErrorReadingUpgradeState, err, logger)
+		return nil
+	}
+
+	return s
+}
+
+// newInheritedState reads the description of the listeners handed over from the state provided, using listenerFile to
+// open the socket of each in turn
+func newInheritedState(logger *logrus.Logger, state io.ReadCloser, reply io.WriteCloser, listenerFile func(int) *os.File) (*inheritedState, error) {
+	s := &inheritedState{
+		logger:    logger,
+		state:     json.NewDecoder(state),
+		stateFile: state,
+		reply:     reply,
+	}
+
+	if err := s.state.Decode(&s.listeners); err != nil {
+		// we're not going to act on Close errors, so ignore purposefully
+		state.Close()
+		reply.Close()
+		return nil, err
+	}
+	for i := range s.listeners {
+		s.files = append(s.files, listenerFile(i))
+	}
+
+	return s, nil
+}
+
+// listener returns a listener for the socket handed over for the listener settings provided, or nil if there is none
+func (s *inheritedState) listener(ls application.ListenerSettings) (net.Listener, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	address := listenAddress(ls)
+	for i, ul := range s.listeners {
+		if ul.Name != ls.Name || ul.Address != address || s.files[i] == nil {
+			continue
+		}
+
+		file := s.files[i]
+		s.files[i] = nil
+
+		// the listener holds its own copy of the socket; we're not going to act on Close errors, so ignore purposefully
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		s.logger.WithFields(logrus.Fields{logFieldListener: ls.Name}).Info(logMsgInheritedListener)
+		return listener, nil
+	}
+
+	return nil, nil
+}
+
+// adoptContainers tells the previous process that the listeners have been created, and then adopts the containers
+// that it hands over into the pools with the same names, telling it which have been adopted. Any listener sockets
+// handed over which have not been used are closed.
+func (s *inheritedState) adoptContainers(pools map[string]*cntrpool.ContainerPool) error {
+	if s == nil {
+		return nil
+	}
+	defer s.close()
+
+	if err := json.NewEncoder(s.reply).Encode(upgradeListening{PID: os.Getpid()}); err != nil {
+		return err
+	}
+
+	var containers map[string][]*cntr.Container
+	if err := s.state.Decode(&containers); err != nil {
+		return err
+	}
+
+	adopted := upgradeAdopted{Containers: make(map[string][]string)}
+	for name, pool := range pools {
+		for _, c := range pool.AdoptContainers(containers[name]) {
+			adopted.Containers[name] = append(adopted.Containers[name], c.ExternalID)
+		}
+
+		s.logger.WithFields(logrus.Fields{
+			logFieldPool:              name,
+			logFieldAdoptedContainers: len(adopted.Containers[name]),
+		}).Info(logMsgAdoptedContainers)
+	}
+
+	return json.NewEncoder(s.reply).Encode(adopted)
+}
+
+// close closes the pipes to the previous process together with any listener sockets which have not been used
+func (s *inheritedState) close() {
+	// we're not going to act on Close errors, so ignore purposefully
+	for _, file := range s.files {
+		if file != nil {
+			file.Close()
+		}
+	}
+	s.files = nil
+	s.stateFile.Close()
+	s.reply.Close()
+}
+
+// Upgrade hands over to a new process, started from the current executable with the same arguments, without dropping
+// any client connections. The new process is given the listener sockets, and once it is listening on them this process
+// stops accepting connections and the unused containers of every pool are released to it. Should it fail to take these
+// over then it is stopped and this process carries on as before, returning the error. Once Upgrade returns
+// successfully the caller should call Shutdown, which drains the remaining sessions of this process and destroys only
+// the containers which were not handed over.
+//
+// The statistics server is stopped before the new process is started, as only one process can listen on its address,
+// and is only restarted should the upgrade fail. Once the new process is serving every new client the drain of this
+// process is therefore not observable through /healthz or /metrics; its sessions and their containers are still
+// logged and written to the monitor as they end, with the new process reporting on everything else.
+func (ctx *Context) Upgrade() error {
+	ctx.lock.Lock()
+	switch {
+	case ctx.isShuttingDown:
+		ctx.lock.Unlock()
+		return errors.New(errorUpgradeShuttingDown)
+	case ctx.upgrading:
+		ctx.lock.Unlock()
+		return errors.New(errorUpgradeInProgress)
+	case ctx.listeners == nil:
+		ctx.lock.Unlock()
+		return errors.New(errorUpgradeNotListening)
+	}
+	ctx.upgrading = true
+	listeners := ctx.listeners
+	pools := ctx.ContainerPools
+	ctx.lock.Unlock()
+
+	defer func() {
+		ctx.lock.Lock()
+		ctx.upgrading = false
+		ctx.lock.Unlock()
+	}()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	described, files, err := listenerFiles(listeners)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+
+	stateReader, stateWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stateWriter.Close()
+	replyReader, replyWriter, err := os.Pipe()
+	if err != nil {
+		// we're not going to act on Close errors, so ignore purposefully
+		stateReader.Close()
+		return err
+	}
+	defer replyReader.Close()
+
+	// the listeners are small enough to describe before the new process has started without blocking
+	if err := json.NewEncoder(stateWriter).Encode(described); err != nil {
+		// we're not going to act on Close errors, so ignore purposefully
+		stateReader.Close()
+		replyWriter.Close()
+		return err
+	}
+
+	// the statistics server is stopped so that the new process is able to start its own
+	restartStatistics := ctx.stopStatistics()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), envUpgrade+"=1")
+	cmd.ExtraFiles = append([]*os.File{stateReader, replyWriter}, files...)
+
+	err = cmd.Start()
+	// only the new process should hold its ends of the pipes, so that they are closed should it exit; we're not going
+	// to act on Close errors, so ignore purposefully
+	stateReader.Close()
+	replyWriter.Close()
+	if err != nil {
+		if restartStatistics {
+			ctx.restartStatistics()
+		}
+		return err
+	}
+
+	ctx.Logger.WithFields(logrus.Fields{
+		logFieldExecutable: executable,
+		logFieldPID:        cmd.Process.Pid,
+	}).Warn(logMsgUpgradeStarted)
+
+	exited := make(chan struct{})
+	go func() {
+		// we're not going to act on the exit status, as any failure to hand over has already been detected
+		cmd.Wait()
+		close(exited)
+	}()
+
+	if err := ctx.handOver(listeners, pools, stateWriter, replyReader, exited, upgradeStageTimeout); err != nil {
+		select {
+		case <-exited:
+		default:
+			if err := cmd.Process.Kill(); err != nil {
+				log.Error(logErrorStoppingUpgrade, err, ctx.Logger)
+			}
+		}
+		if restartStatistics {
+			ctx.restartStatistics()
+		}
+		return err
+	}
+
+	// the sockets are now used by the new process, so must not be removed once this process has closed them
+	for _, pl := range listeners {
+		if ul, ok := pl.socket.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	ctx.Logger.Warn(logMsgUpgradeComplete)
+	return nil
+}
+
+// handOver takes the side of the old process in an upgrade once the new process has been started: waiting for it to
+// create its listeners, pausing those provided so that every new connection is accepted by the new process, releasing
+// the unused containers of every pool to it and then returning to the pools any that it did not adopt. Should the new
+// process exit, or not complete each stage within the timeout, then every container released is returned to its pool,
+// the listeners resume accepting connections and the error returned.
+func (ctx *Context) handOver(listeners []*proxyListener, pools map[string]*cntrpool.ContainerPool, state io.Writer, replies io.Reader, exited <-chan struct{}, timeout time.Duration) (err error) {
+	decoder := json.NewDecoder(replies)
+
+	var listening upgradeListening
+	if err := awaitReply(decoder, &listening, exited, timeout); err != nil {
+		return err
+	}
+	ctx.Logger.WithFields(logrus.Fields{logFieldPID: listening.PID}).Info(logMsgUpgradeListening)
+
+	ctx.pauseAccepting(listeners)
+	defer func() {
+		ctx.resumeAccepting(listeners, err == nil)
+	}()
+
+	released := make(map[string][]*cntr.Container, len(pools))
+	for name, pool := range pools {
+		released[name] = pool.ReleaseUnusedContainers()
+	}
+
+	var adopted upgradeAdopted
+	err = json.NewEncoder(state).Encode(released)
+	if err == nil {
+		err = awaitReply(decoder, &adopted, exited, timeout)
+	}
+	if err != nil {
+		adopted = upgradeAdopted{}
+	}
+
+	// anything not adopted by the new process is returned to its pool, so that it is either used should the upgrade
+	// have failed or destroyed on shutdown
+	for name, pool := range pools {
+		isAdopted := make(map[string]bool)
+		for _, id := range adopted.Containers[name] {
+			isAdopted[id] = true
+		}
+
+		var remaining []*cntr.Container
+		for _, c := range released[name] {
+			if !isAdopted[c.ExternalID] {
+				remaining = append(remaining, c)
+			}
+		}
+		if len(remaining) > 0 {
+			pool.AdoptContainers(remaining)
+		}
+	}
+
+	return err
+}
+
+// pauseAccepting stops the listeners provided from accepting connections, which instead queue on the sockets until
+// accepted by the new process. The sockets are left open so that accepting can resume should the handover fail.
+func (ctx *Context) pauseAccepting(listeners []*proxyListener) {
+	ctx.lock.Lock()
+	ctx.acceptPaused = make(chan struct{})
+	ctx.lock.Unlock()
+
+	// interrupt any Accept which is waiting, which then waits in turn for the handover to complete
+	for _, pl := range listeners {
+		if dl, ok := pl.socket.(deadlineListener); ok {
+			// we're not going to act on SetDeadline errors, so ignore purposefully
+			dl.SetDeadline(time.Unix(1, 0))
+		}
+	}
+}
+
+// resumeAccepting ends the pause started by pauseAccepting: the listeners either accept connections once more or, if
+// they have been handed over, stop accepting altogether
+func (ctx *Context) resumeAccepting(listeners []*proxyListener, handedOver bool) {
+	if !handedOver {
+		for _, pl := range listeners {
+			if dl, ok := pl.socket.(deadlineListener); ok {
+				// we're not going to act on SetDeadline errors, so ignore purposefully
+				dl.SetDeadline(time.Time{})
+			}
+		}
+	}
+
+	ctx.lock.Lock()
+	ctx.handedOver = handedOver
+	close(ctx.acceptPaused)
+	ctx.acceptPaused = nil
+	ctx.lock.Unlock()
+}
+
+// awaitHandOver is called by the accept loop of a listener once Accept has failed. Should accepting have been paused
+// by pauseAccepting then it waits for the handover to complete, returning true if the listener should carry on
+// accepting connections. Once the listeners have been handed over, or if they were not paused, it returns false.
+func (ctx *Context) awaitHandOver() bool {
+	ctx.lock.Lock()
+	paused := ctx.acceptPaused
+	ctx.lock.Unlock()
+
+	if paused == nil {
+		return false
+	}
+	<-paused
+
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	return !ctx.handedOver
+}
+
+// awaitReply decodes the next reply from the new process into v, returning an error should the process exit or not
+// reply within the timeout
+func awaitReply(decoder *json.Decoder, v interface{}, exited <-chan struct{}, timeout time.Duration) error {
+	decoded := make(chan error, 1)
+	go func() {
+		decoded <- decoder.Decode(v)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-decoded:
+		return err
+	case <-exited:
+		return errors.New(errorUpgradeProcessExited)
+	case <-timer.C:
+		return errors.New(errorUpgradeTimeout)
+	}
+}
+
+// listenerFiles returns a duplicate of the socket of every listener provided, to be handed over to a new process,
+// together with a description of each
+func listenerFiles(listeners []*proxyListener) ([]upgradeListener, []*os.File, error) {
+	var described []upgradeListener
+	var files []*os.File
+
+	for _, pl := range listeners {
+		fl, ok := pl.socket.(fileListener)
+		if !ok {
+			closeFiles(files)
+			return nil, nil, errors.New(errorListenerNotHandedOver + pl.name)
+		}
+
+		file, err := fl.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+
+		files = append(files, file)
+		described = append(described, upgradeListener{Name: pl.name, Address: listenAddress(pl.settings)})
+	}
+
+	return described, files, nil
+}
+
+// closeFiles closes every file provided
+func closeFiles(files []*os.File) {
+	// we're not going to act on Close errors, so ignore purposefully
+	for _, file := range files {
+		file.Close()
+	}
+}
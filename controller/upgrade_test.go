@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/nextmetaphor/tcp-proxy-pool/application"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// unusedContainers returns the number of unused containers in every pool of the context
+func unusedContainers(ctx *Context) int {
+	unused := 0
+	for _, pool := range ctx.ContainerPools {
+		released := pool.ReleaseUnusedContainers()
+		pool.AdoptContainers(released)
+		unused += len(released)
+	}
+
+	return unused
+}
+
+func Test_UpgradeHandOver(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+	cm := &TestEchoContainerManager{backend: backend}
+
+	oldCtx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+	address := startTestListener(t, oldCtx, managersFor(oldCtx, cm))
+
+	// a session which is active during the upgrade stays with the old process, as does its container
+	conn, err := net.Dial("tcp", address.String())
+	assert.Nil(t, err)
+	conn.Write([]byte("before"))
+	buf := make([]byte, 6)
+	_, err = conn.Read(buf)
+	assert.Nil(t, err)
+
+	described, files, err := listenerFiles(oldCtx.listeners)
+	assert.Nil(t, err)
+	stateReader, stateWriter, _ := os.Pipe()
+	replyReader, replyWriter, _ := os.Pipe()
+	defer stateWriter.Close()
+	defer replyReader.Close()
+	json.NewEncoder(stateWriter).Encode(described)
+
+	newCtx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+	newCtx.inherited, err = newInheritedState(newCtx.Logger, stateReader, replyWriter, func(i int) *os.File {
+		return files[i]
+	})
+	assert.Nil(t, err)
+
+	// the new process only finishes starting once the old one has handed over its containers
+	handedOver := make(chan error, 1)
+	go func() {
+		handedOver <- oldCtx.handOver(oldCtx.listeners, oldCtx.ContainerPools, stateWriter, replyReader, make(chan struct{}), 5*time.Second)
+	}()
+	startTestListener(t, newCtx, managersFor(newCtx, cm))
+	assert.Nil(t, <-handedOver)
+	assert.Equal(t, 0, unusedContainers(oldCtx))
+	assert.Equal(t, 2, unusedContainers(newCtx))
+
+	// the old process stops accepting connections as soon as it has handed over, even before it has shut down, as it
+	// has no containers left to serve them with
+	for i := 0; i < 5; i++ {
+		response, err := proxyEcho(address.String(), nil, "handed-over")
+		assert.Nil(t, err)
+		assert.Equal(t, "handed-over", response)
+	}
+
+	// the old process drains its session and destroys only its own container
+	conn.Close()
+	oldCtx.Shutdown()
+	cm.Lock()
+	assert.Equal(t, 1, cm.destroyed)
+	cm.Unlock()
+
+	// the listener socket is still open in the new process, which now serves every client
+	response, err := proxyEcho(address.String(), nil, "after")
+	assert.Nil(t, err)
+	assert.Equal(t, "after", response)
+
+	newCtx.Shutdown()
+	cm.Lock()
+	assert.Equal(t, 3, cm.destroyed)
+	cm.Unlock()
+}
+
+func Test_UpgradeHandOverFailure(t *testing.T) {
+	backend := startEchoBackend(t)
+	defer backend.Close()
+	cm := &TestEchoContainerManager{backend: backend}
+
+	t.Run("NewProcessExitsBeforeListening", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+		startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		exited := make(chan struct{})
+		close(exited)
+		replyReader, replyWriter, _ := os.Pipe()
+		defer replyReader.Close()
+		defer replyWriter.Close()
+
+		err := ctx.handOver(ctx.listeners, ctx.ContainerPools, ioutil.Discard, replyReader, exited, 5*time.Second)
+		assert.Equal(t, errorUpgradeProcessExited, err.Error())
+		assert.Equal(t, 2, unusedContainers(ctx))
+	})
+
+	t.Run("NewProcessDoesNotAdopt", func(t *testing.T) {
+		ctx := createTestContext(application.ListenerSettings{Mode: application.ListenerModeTCP})
+		address := startTestListener(t, ctx, managersFor(ctx, cm))
+		defer ctx.Shutdown()
+
+		stateReader, stateWriter, _ := os.Pipe()
+		replyReader, replyWriter, _ := os.Pipe()
+		defer stateReader.Close()
+		defer stateWriter.Close()
+		defer replyReader.Close()
+		defer replyWriter.Close()
+
+		// the new process starts listening and is handed the containers, but never says that it has adopted them
+		handedOver := make(chan map[string][]*cntr.Container, 1)
+		go func() {
+			json.NewEncoder(replyWriter).Encode(upgradeListening{PID: 42})
+			var containers map[string][]*cntr.Container
+			json.NewDecoder(stateReader).Decode(&containers)
+			handedOver <- containers
+		}()
+
+		err := ctx.handOver(ctx.listeners, ctx.ContainerPools, stateWriter, replyReader, make(chan struct{}), 100*time.Millisecond)
+		assert.Equal(t, errorUpgradeTimeout, err.Error())
+		assert.Equal(t, 2, len((<-handedOver)[application.DefaultPoolName]))
+		assert.Equal(t, 2, unusedContainers(ctx))
+
+		// the listener accepts connections once more
+		response, err := proxyEcho(address.String(), nil, "resumed")
+		assert.Nil(t, err)
+		assert.Equal(t, "resumed", response)
+	})
+}
+
+func Test_UpgradeNotListening(t *testing.T) {
+	ctx := createTestContext(application.ListenerSettings{})
+	assert.Equal(t, errorUpgradeNotListening, ctx.Upgrade().Error())
+
+	ctx.Shutdown()
+	assert.Equal(t, errorUpgradeShuttingDown, ctx.Upgrade().Error())
+}
@@ -7,6 +7,13 @@ export NAMESPACE=tcp-pool-proxy
 
 kubectl create namespace ${NAMESPACE}
 
+# add the listener certificate and the tokens used to authenticate to the statistics server of tcp-pool-proxy
+openssl req -x509 -newkey rsa:2048 -nodes -days 365 -subj "/CN=tcp-pool-proxy" -keyout /tmp/tcp-pool-proxy.key -out /tmp/tcp-pool-proxy.crt
+kubectl create secret tls tcp-pool-proxy-tls --namespace ${NAMESPACE} --cert=/tmp/tcp-pool-proxy.crt --key=/tmp/tcp-pool-proxy.key
+rm /tmp/tcp-pool-proxy.key /tmp/tcp-pool-proxy.crt
+kubectl create secret generic tcp-pool-proxy-tokens --namespace ${NAMESPACE} \
+  --from-literal=read-only.tokens=`openssl rand -hex 32` --from-literal=admin.tokens=`openssl rand -hex 32`
+
 # add sample backend API
 kubectl create -f _k8s/sample-api/deployment.yaml --namespace ${NAMESPACE}
 kubectl create -f _k8s/sample-api/service.yaml --namespace ${NAMESPACE}
@@ -1,27 +1,35 @@
-module nextmetaphor/tcp-proxy-pool
+// The module path is that of the repository, rather than nextmetaphor/tcp-proxy-pool, so that the go command resolves
+// the packages of this module from the working tree instead of fetching the 2018 snapshot published under the old path.
+module github.com/nextmetaphor/tcp-proxy-pool
 
-go 1.14
+// Go 1.21 is required for x509.ParseRevocationList, RevocationList.CheckSignatureFrom and
+// RevocationList.RevokedCertificateEntries, which are used to check client certificates against a CRL.
+go 1.21
 
 require (
 	github.com/alecthomas/kingpin v2.2.6+incompatible
+	github.com/aws/aws-sdk-go v1.13.57
+	github.com/gorilla/handlers v1.3.0
+	github.com/gorilla/mux v1.6.2
+	github.com/influxdata/influxdb v1.5.3
+	github.com/sirupsen/logrus v1.0.5
+	github.com/stretchr/testify v1.2.1
+)
+
+require (
 	github.com/alecthomas/template v0.0.0-20160405071501-a0175ee3bccc // indirect
 	github.com/alecthomas/units v0.0.0-20151022065526-2efee857e7cf // indirect
-	github.com/aws/aws-sdk-go v1.13.57
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/go-ini/ini v1.37.0 // indirect
 	github.com/gorilla/context v1.1.1 // indirect
-	github.com/gorilla/handlers v1.3.0
-	github.com/gorilla/mux v1.6.2
-	github.com/influxdata/influxdb v1.5.3
 	github.com/jmespath/go-jmespath v0.0.0-20160202185014-0b12d6b521d8 // indirect
-	github.com/nextmetaphor/tcp-proxy-pool v0.0.0-20180730190201-49e170d45b84
 	github.com/onsi/ginkgo v1.12.1 // indirect
 	github.com/onsi/gomega v1.10.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/sirupsen/logrus v1.0.5
 	github.com/smartystreets/goconvey v1.6.4 // indirect
-	github.com/stretchr/testify v1.2.1
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2 // indirect
 	golang.org/x/net v0.0.0-20200513185701-a91f0712d120 // indirect
+	golang.org/x/sys v0.0.0-20200323222414-85ca7c5b95cd // indirect
 	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
 	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
 	gopkg.in/ini.v1 v1.56.0 // indirect
@@ -10,7 +10,7 @@ const (
 	// LevelWarning specifies the log level for warning logging
 	LevelWarning = "warn"
 	// LevelDebug specifies the log level for debug logging
-	LevelDebug   = "debug"
+	LevelDebug = "debug"
 
 	logFieldErrorCause = "rootError"
 )
@@ -44,4 +44,13 @@ func Error(description string, err error, logger *logrus.Logger) {
 		logger.WithFields(logrus.Fields{
 			logFieldErrorCause: err}).Error(description)
 	}
-}
\ No newline at end of file
+}
+
+// ErrorEntry is a helper function which logs an error message with the specified log entry, so that any fields
+// already added to the entry are also logged
+func ErrorEntry(description string, err error, entry *logrus.Entry) {
+	if entry != nil {
+		entry.WithFields(logrus.Fields{
+			logFieldErrorCause: err}).Error(description)
+	}
+}
@@ -1,11 +1,11 @@
 package log
 
 import (
+	"errors"
 	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"testing"
-	"github.com/sirupsen/logrus/hooks/test"
-	"errors"
 )
 
 func TestGet(t *testing.T) {
@@ -29,8 +29,8 @@ func TestGet(t *testing.T) {
 
 func TestError(t *testing.T) {
 	const (
-		errDescription =  "some error"
-		errDetails = "my new error"
+		errDescription = "some error"
+		errDetails     = "my new error"
 	)
 
 	logger, hook := test.NewNullLogger()
@@ -40,4 +40,23 @@ func TestError(t *testing.T) {
 	assert.Equal(t, 1, len(hook.AllEntries()))
 	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
 	assert.Equal(t, errDescription, hook.LastEntry().Message)
-}
\ No newline at end of file
+}
+
+func TestErrorEntry(t *testing.T) {
+	const (
+		errDescription = "some error"
+		errDetails     = "my new error"
+		fieldName      = "field"
+		fieldValue     = "value"
+	)
+
+	logger, hook := test.NewNullLogger()
+
+	e := errors.New(errDetails)
+	ErrorEntry(errDescription, e, logger.WithField(fieldName, fieldValue))
+	assert.Equal(t, 1, len(hook.AllEntries()))
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Equal(t, errDescription, hook.LastEntry().Message)
+	assert.Equal(t, fieldValue, hook.LastEntry().Data[fieldName])
+	assert.Equal(t, e, hook.LastEntry().Data[logFieldErrorCause])
+}
@@ -7,13 +7,20 @@ import (
 	"github.com/nextmetaphor/tcp-proxy-pool/log"
 	"github.com/nextmetaphor/tcp-proxy-pool/monitor"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 const (
 	// command-line flags
-	logSignalReceived           = "Signal [%s] received, shutting down server"
-	settingsFilename            = "tcp-proxy-pool.json"
-	logErrorLoadingSettingsFile = "Error loading settings file"
+	logSignalReceived                = "Signal [%s] received, shutting down server"
+	logUpgradeSignalReceived         = "Signal [%s] received, upgrading server"
+	logErrorUpgrading                = "Error upgrading server; carrying on"
+	logReloadSignalReceived          = "Signal [%s] received, reloading certificates"
+	settingsFilename                 = "tcp-proxy-pool.json"
+	logErrorLoadingSettingsFile      = "Error loading settings file"
+	logErrorCreatingContainerManager = "Error creating container manager"
+	logErrorStartingStatistics       = "Error starting statistics server"
 )
 
 func main() {
@@ -38,23 +45,68 @@ func main() {
 
 	// TODO overrride settings with flags
 
-	//// start the appropriate monitor service
-	monitorClient := monitor.CreateMonitor(ctx.Settings.Monitor, ctx.Logger)
-	defer monitorClient.CloseMonitorConnection()
-	ctx.MonitorClient = *monitorClient
+	// create the appropriate container manager for each pool; a pool cannot be served without one, so exit before
+	// anything else is started should any fail
+	managers := make(map[string]cntrmgr.ContainerManager)
+	for _, ps := range ctx.Settings.PoolSettings() {
+		cm, err := cntrmgr.CreateContainerManager(ps.Manager, ps.ECS, ctx.Logger)
+		if err != nil {
+			log.Error(logErrorCreatingContainerManager, err, ctx.Logger)
+			os.Exit(1)
+		}
+		managers[ps.Name] = cm
+	}
+
+	//// start the appropriate monitor service, together with the metrics served by the statistics service
+	influx := monitor.CreateMonitor(ctx.Settings.Monitor, ctx.Logger)
+	ctx.Metrics = monitor.NewMetrics()
+	ctx.Metrics.CountDroppedPoints(influx)
+	ctx.Monitor = monitor.NewMultiMonitor(influx, ctx.Metrics.Monitor())
+	defer ctx.Monitor.CloseMonitorConnection()
+
+	// start the statistics service; the probes and admin API depend upon it, so exit should it not start
+	if err := ctx.StartStatistics(); err != nil {
+		log.Error(logErrorStartingStatistics, err, ctx.Logger)
+		ctx.Monitor.CloseMonitorConnection()
+		os.Exit(1)
+	}
+
+	// shut down gracefully when asked to terminate, hand over to a new process when asked to upgrade, and reload the
+	// certificates when asked to
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2, syscall.SIGHUP)
 
-	// start the statistics service
-	go ctx.StartStatistics()
+	// start a listener; this only returns once the listener has been closed or could not be started
+	listenerStopped := make(chan bool, 1)
+	go func() {
+		listenerStopped <- ctx.StartListener(managers)
+	}()
 
-	// create the appropriate container manager
-	cm := cntrmgr.DummyContainerManager{}
-	//cm := cntrmgr.ECS{
-	//	logger: *ctx.logger,
-	//	Conf:   ctx.settings.ECS,
-	//}
-	//cm.InitialiseECSService()
+	for running := true; running; {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				ctx.Logger.Warnf(logReloadSignalReceived, sig)
+				ctx.ReloadCertificates()
+				break
+			}
+			if sig != syscall.SIGUSR2 {
+				ctx.Logger.Warnf(logSignalReceived, sig)
+				running = false
+				break
+			}
 
-	// start a listener
-	ctx.StartListener(cm)
+			// once the new process has taken over, this one drains its remaining sessions and exits
+			ctx.Logger.Warnf(logUpgradeSignalReceived, sig)
+			if err := ctx.Upgrade(); err != nil {
+				log.Error(logErrorUpgrading, err, ctx.Logger)
+				break
+			}
+			running = false
+		case <-listenerStopped:
+			running = false
+		}
+	}
 
+	ctx.Shutdown()
 }
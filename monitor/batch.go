@@ -0,0 +1,250 @@
+package monitor
+
+import (
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/nextmetaphor/tcp-proxy-pool/log"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize        = 10000
+	defaultBatchSize        = 1000
+	defaultFlushIntervalSec = 10
+	defaultMaxRetries       = 3
+
+	// httpWriteTimeout is the time allowed for each batch to be written
+	httpWriteTimeout = 10 * time.Second
+	// retryBackoff is the delay before a failed batch is first retried; this is doubled after each failure
+	retryBackoff = time.Second
+	// flushTimeout is the time allowed for every point still queued to be written once the writer is closed
+	flushTimeout = 15 * time.Second
+
+	logMsgRetryingBatch = "retrying monitor batch"
+	logMsgPointsDropped = "monitor points dropped"
+	logMsgFlushTimedOut = "monitor points not written before the flush timeout"
+
+	logFieldAttempt       = "attempt"
+	logFieldPoints        = "points"
+	logFieldDroppedPoints = "dropped-points"
+	logFieldError         = "error"
+
+	logErrorWritingBatch = "Error writing monitor batch; dropping its points"
+)
+
+type (
+	// batchWriter buffers points in a bounded queue and writes them to InfluxDB in batches, either once a batch is
+	// full or at a regular interval. Points are dropped, and counted, should the queue be full, a batch still not
+	// have been written once it has been retried, or the points queued not have been written within the flush timeout
+	// once the writer is closed.
+	batchWriter struct {
+		influx        client.Client
+		config        client.BatchPointsConfig
+		logger        *logrus.Logger
+		batchSize     int
+		flushInterval time.Duration
+		maxRetries    int
+		retryBackoff  time.Duration
+		flushTimeout  time.Duration
+
+		queue chan *client.Point
+
+		// dropped is the total number of points dropped, and reported the number which have been logged; both are
+		// accessed atomically
+		dropped  int64
+		reported int64
+
+		// done is closed once the writer is closed, expired once the flush timeout has then passed, and stopped once
+		// the writer has stopped
+		closeOnce sync.Once
+		done      chan struct{}
+		expired   chan struct{}
+		stopped   chan struct{}
+	}
+)
+
+// valueOrDefault returns the value, or the default provided if it is not positive
+func valueOrDefault(value, defaultValue int) int {
+	if value <= 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// newBatchWriter creates a writer which writes points to the InfluxDB client provided as per the settings, and
+// starts writing them
+func newBatchWriter(influx client.Client, ms Settings, l *logrus.Logger) *batchWriter {
+	bw := &batchWriter{
+		influx: influx,
+		config: client.BatchPointsConfig{
+			Database:        ms.Database,
+			RetentionPolicy: ms.RetentionPolicy,
+			Precision:       "ns",
+		},
+		logger:        l,
+		batchSize:     valueOrDefault(ms.BatchSize, defaultBatchSize),
+		flushInterval: time.Duration(valueOrDefault(ms.FlushIntervalSec, defaultFlushIntervalSec)) * time.Second,
+		maxRetries:    valueOrDefault(ms.MaxRetries, defaultMaxRetries),
+		retryBackoff:  retryBackoff,
+		flushTimeout:  flushTimeout,
+		queue:         make(chan *client.Point, valueOrDefault(ms.QueueSize, defaultQueueSize)),
+		done:          make(chan struct{}),
+		expired:       make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	go bw.run()
+
+	return bw
+}
+
+// add queues the point to be written, dropping it if the queue is full. It never blocks.
+func (bw *batchWriter) add(pt *client.Point) {
+	select {
+	case bw.queue <- pt:
+	default:
+		atomic.AddInt64(&bw.dropped, 1)
+	}
+}
+
+// droppedPoints returns the number of points which have been dropped
+func (bw *batchWriter) droppedPoints() int64 {
+	return atomic.LoadInt64(&bw.dropped)
+}
+
+// run collects points from the queue into batches and writes them until the writer is closed, at which point every
+// point still queued is flushed
+func (bw *batchWriter) run() {
+	defer close(bw.stopped)
+
+	ticker := time.NewTicker(bw.flushInterval)
+	defer ticker.Stop()
+
+	var points []*client.Point
+	for {
+		select {
+		case pt := <-bw.queue:
+			// a batch interrupted by the writer being closed is kept, to be flushed
+			if points = append(points, pt); len(points) >= bw.batchSize && bw.write(points, bw.done) {
+				points = nil
+			}
+
+		case <-ticker.C:
+			if bw.write(points, bw.done) {
+				points = nil
+			}
+
+		case <-bw.done:
+			bw.flush(points)
+			return
+		}
+	}
+}
+
+// flush writes the points provided together with every point still queued, dropping any which have not been written
+// once the flush timeout has expired
+func (bw *batchWriter) flush(points []*client.Point) {
+	for {
+		select {
+		case <-bw.expired:
+			bw.drop(len(points) + len(bw.queue))
+			return
+		case pt := <-bw.queue:
+			if points = append(points, pt); len(points) < bw.batchSize {
+				continue
+			}
+		default:
+			if !bw.write(points, bw.expired) {
+				bw.drop(len(points) + len(bw.queue))
+			}
+			return
+		}
+
+		if !bw.write(points, bw.expired) {
+			bw.drop(len(points) + len(bw.queue))
+			return
+		}
+		points = nil
+	}
+}
+
+// write writes the points as a single batch, retrying should this fail, and reports any points dropped since the
+// last were reported. The points are dropped should they still not have been written once they have been retried;
+// false is returned, without dropping them, should stop be closed whilst waiting to retry.
+func (bw *batchWriter) write(points []*client.Point, stop <-chan struct{}) bool {
+	defer bw.reportDropped()
+
+	if len(points) == 0 {
+		return true
+	}
+
+	bp, err := client.NewBatchPoints(bw.config)
+	if err != nil {
+		log.Error(logErrorCreatingMonitorBatch, err, bw.logger)
+		atomic.AddInt64(&bw.dropped, int64(len(points)))
+		return true
+	}
+	bp.AddPoints(points)
+
+	backoff := bw.retryBackoff
+	for attempt := 0; ; attempt++ {
+		if err = bw.influx.Write(bp); err == nil {
+			return true
+		}
+		if attempt >= bw.maxRetries {
+			break
+		}
+
+		bw.logger.WithFields(logrus.Fields{
+			logFieldAttempt: attempt + 1,
+			logFieldPoints:  len(points),
+			logFieldError:   err,
+		}).Warn(logMsgRetryingBatch)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return false
+		}
+		backoff *= 2
+	}
+
+	log.Error(logErrorWritingBatch, err, bw.logger)
+	atomic.AddInt64(&bw.dropped, int64(len(points)))
+	return true
+}
+
+// drop counts the number of points provided as dropped, as they were not written before the flush timeout
+func (bw *batchWriter) drop(points int) {
+	bw.logger.WithFields(logrus.Fields{logFieldPoints: points}).Warn(logMsgFlushTimedOut)
+	atomic.AddInt64(&bw.dropped, int64(points))
+	bw.reportDropped()
+}
+
+// reportDropped logs the total number of points dropped, should any have been dropped since this was last logged
+func (bw *batchWriter) reportDropped() {
+	dropped := atomic.LoadInt64(&bw.dropped)
+	if atomic.SwapInt64(&bw.reported, dropped) != dropped {
+		bw.logger.WithFields(logrus.Fields{logFieldDroppedPoints: dropped}).Warn(logMsgPointsDropped)
+	}
+}
+
+// close writes every point still queued and stops the writer, returning once they have been written or the flush
+// timeout has expired; points added afterwards are never written. It may be called more than once.
+func (bw *batchWriter) close() {
+	bw.closeOnce.Do(func() {
+		close(bw.done)
+		time.AfterFunc(bw.flushTimeout, func() {
+			close(bw.expired)
+		})
+	})
+
+	select {
+	case <-bw.stopped:
+	case <-bw.expired:
+	}
+}
@@ -0,0 +1,227 @@
+package monitor
+
+import (
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// testInfluxServer records the batches written to it using the HTTP write API, failing the number of writes
+	// provided before any succeed
+	testInfluxServer struct {
+		*httptest.Server
+		sync.Mutex
+		failures int
+		batches  []string
+		requests []*http.Request
+	}
+)
+
+func startTestInfluxServer(failures int) *testInfluxServer {
+	s := &testInfluxServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+
+		s.Lock()
+		defer s.Unlock()
+
+		s.requests = append(s.requests, request)
+		if s.failures != 0 {
+			s.failures--
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.batches = append(s.batches, string(body))
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+
+	return s
+}
+
+// lines returns the number of points in each batch written
+func (s *testInfluxServer) lines() []int {
+	s.Lock()
+	defer s.Unlock()
+
+	var lines []int
+	for _, b := range s.batches {
+		lines = append(lines, strings.Count(b, "\n"))
+	}
+
+	return lines
+}
+
+// waitForLines waits for the total number of points written to reach that provided
+func (s *testInfluxServer) waitForLines(t *testing.T, total int) []int {
+	var lines []int
+	for i := 0; i < 100; i++ {
+		lines = s.lines()
+		sum := 0
+		for _, l := range lines {
+			sum += l
+		}
+		if sum >= total {
+			return lines
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("points written %v did not reach %d", lines, total)
+	return nil
+}
+
+// requestCount returns the number of writes made to the server, whether or not they succeeded
+func (s *testInfluxServer) requestCount() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.requests)
+}
+
+// startTestBatchWriter starts a batch writer to the server provided, which retries after the backoff provided and
+// allows a second to flush
+func startTestBatchWriter(t *testing.T, s *testInfluxServer, batchSize, maxRetries int, interval, backoff time.Duration) *batchWriter {
+	l, _ := test.NewNullLogger()
+	influx, err := client.NewHTTPClient(client.HTTPConfig{Addr: s.URL})
+	assert.Nil(t, err)
+
+	bw := &batchWriter{
+		influx:        influx,
+		config:        client.BatchPointsConfig{Database: "db"},
+		logger:        l,
+		batchSize:     batchSize,
+		flushInterval: interval,
+		maxRetries:    maxRetries,
+		retryBackoff:  backoff,
+		flushTimeout:  time.Second,
+		queue:         make(chan *client.Point, 100),
+		done:          make(chan struct{}),
+		expired:       make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	go bw.run()
+
+	return bw
+}
+
+func testPoint(t *testing.T) *client.Point {
+	pt, err := client.NewPoint("measurement", nil, map[string]interface{}{"field": 1}, time.Now())
+	assert.Nil(t, err)
+
+	return pt
+}
+
+func Test_HTTPMonitor(t *testing.T) {
+	s := startTestInfluxServer(0)
+	defer s.Close()
+
+	l, _ := test.NewNullLogger()
+	mon := CreateMonitor(Settings{
+		Address:          s.URL,
+		Database:         "tcp-proxy-pool",
+		Transport:        TransportHTTP,
+		Username:         "user",
+		Password:         "secret",
+		RetentionPolicy:  "week",
+		BatchSize:        2,
+		FlushIntervalSec: 3600,
+	}, l)
+	tagged := mon.WithTags(map[string]string{TagPool: "alpha"})
+
+	// points are written once a batch is full, and any remaining once the monitor is closed
+	for i := 0; i < 3; i++ {
+		tagged.WriteContainerCreated(1)
+	}
+	assert.Equal(t, []int{2}, s.waitForLines(t, 2))
+
+	mon.CloseMonitorConnection()
+	assert.Equal(t, []int{2, 1}, s.lines())
+	assert.Equal(t, int64(0), mon.(*Client).DroppedPoints())
+
+	s.Lock()
+	defer s.Unlock()
+	request := s.requests[0]
+	assert.Equal(t, "tcp-proxy-pool", request.URL.Query().Get("db"))
+	assert.Equal(t, "week", request.URL.Query().Get("rp"))
+	username, password, _ := request.BasicAuth()
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "secret", password)
+	assert.True(t, strings.HasPrefix(s.batches[0], measurementContainerPool+",pool=alpha "))
+}
+
+func Test_BatchWriter(t *testing.T) {
+	t.Run("FlushByInterval", func(t *testing.T) {
+		s := startTestInfluxServer(0)
+		defer s.Close()
+
+		bw := startTestBatchWriter(t, s, 100, 0, 10*time.Millisecond, time.Millisecond)
+		defer bw.close()
+
+		bw.add(testPoint(t))
+		assert.Equal(t, []int{1}, s.waitForLines(t, 1))
+	})
+
+	t.Run("RetryWithBackoff", func(t *testing.T) {
+		s := startTestInfluxServer(2)
+		defer s.Close()
+
+		bw := startTestBatchWriter(t, s, 1, 2, time.Hour, time.Millisecond)
+		bw.add(testPoint(t))
+		bw.close()
+
+		assert.Equal(t, []int{1}, s.lines())
+		assert.Equal(t, int64(0), bw.droppedPoints())
+	})
+
+	t.Run("DropAfterRetries", func(t *testing.T) {
+		s := startTestInfluxServer(-1)
+		defer s.Close()
+
+		bw := startTestBatchWriter(t, s, 10, 1, time.Hour, time.Millisecond)
+		bw.add(testPoint(t))
+		bw.add(testPoint(t))
+		bw.close()
+
+		assert.Equal(t, 0, len(s.lines()))
+		assert.Equal(t, int64(2), bw.droppedPoints())
+	})
+
+	t.Run("FlushTimeout", func(t *testing.T) {
+		s := startTestInfluxServer(-1)
+		defer s.Close()
+
+		// the batch would be retried after an hour, but closing the writer interrupts the backoff and it is retried
+		// at once; it is then dropped as the next retry would be after the flush timeout
+		bw := startTestBatchWriter(t, s, 1, 10, time.Hour, time.Hour)
+		bw.add(testPoint(t))
+		for i := 0; i < 100 && s.requestCount() == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		started := time.Now()
+		bw.close()
+		assert.True(t, time.Since(started) < 5*time.Second)
+
+		<-bw.stopped
+		assert.Equal(t, 2, s.requestCount())
+		assert.Equal(t, int64(1), bw.droppedPoints())
+	})
+
+	t.Run("DropWhenFull", func(t *testing.T) {
+		// the writer is not started, so nothing is taken from the queue
+		bw := &batchWriter{queue: make(chan *client.Point, 2)}
+		for i := 0; i < 5; i++ {
+			bw.add(testPoint(t))
+		}
+
+		assert.Equal(t, int64(3), bw.droppedPoints())
+	})
+}
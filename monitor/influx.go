@@ -1,12 +1,14 @@
 package monitor
 
 import (
-	"github.com/sirupsen/logrus"
-	"strings"
+	"errors"
 	"github.com/influxdata/influxdb/client/v2"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
 	"github.com/nextmetaphor/tcp-proxy-pool/log"
-	"time"
+	"github.com/sirupsen/logrus"
 	"net"
+	"strings"
+	"time"
 )
 
 const (
@@ -15,49 +17,198 @@ const (
 	logErrorCreatingPoint             = "Error creating point"
 	logErrorWritingPoint              = "Error writing point"
 
+	errorUnknownTransport = "unknown monitor transport: "
+
 	measurementDataTransfer = "data-transfer"
 	fieldCopiedToServer     = "copied-to-server"
 	fieldCopiedFromServer   = "copied-from-server"
+	fieldThrottledMillis    = "throttled-ms"
 
 	measurementConnectionPool = "connection-pool"
 	fieldConnectionsAccepted  = "connections-accepted"
 	fieldConnectionsRejected  = "connections-rejected"
 	fieldConnectionsInUse     = "connections-in-use"
 	fieldConnectionPoolSize   = "connection-pool-size"
+	fieldHandshakesFailed     = "handshakes-failed"
+	fieldProtocolsRejected    = "protocols-rejected"
+	fieldSessionsTerminated   = "sessions-terminated"
+	fieldConnectionsDenied    = "connections-denied"
+	fieldAuthenticationFailed = "authentications-failed"
+	fieldBackendDialMillis    = "backend-dial-ms"
+	fieldSessionMillis        = "session-duration-ms"
+
+	measurementClientLimits = "client-limits"
+	fieldConnectionsLimited = "connections-limited"
 
-	measurementContainerPool = "container-pool"
-	fieldContainersCreated   = "container-created"
-	fieldContainersDestroyed = "container-destroyed"
+	measurementContainerPool   = "container-pool"
+	fieldContainersCreated     = "container-created"
+	fieldContainersDestroyed   = "container-destroyed"
+	fieldContainersFailed      = "container-failed"
+	fieldContainerCreateMillis = "container-create-ms"
+	fieldContainers            = "containers"
+	fieldContainersFree        = "containers-free"
+	fieldContainersUsed        = "containers-used"
+	fieldContainersStarting    = "containers-starting"
+
+	measurementCertificates       = "certificates"
+	fieldCertificatesReloaded     = "certificates-reloaded"
+	fieldCertificateReloadsFailed = "certificate-reloads-failed"
 
 	tagTCPProxyPoolClientConn = "client-conn"
 	tagTCPProxyPoolServerConn = "server-conn"
+	tagClientSubject          = "client-subject"
+	tagTerminationReason      = "termination-reason"
+	tagLimit                  = "limit"
 )
 
-var (
-	influxClient client.Client
-)
-
-// CreateMonitor simply creates a pointer to a Client
+// CreateMonitor creates the monitor described by the settings provided, which writes points to InfluxDB using the
+// configured transport. If no address is configured, or the connection cannot be created, then a NoOp monitor is
+// returned so that points are discarded.
 // TODO return error
-func CreateMonitor(ms Settings, l *logrus.Logger) *Client {
+func CreateMonitor(ms Settings, l *logrus.Logger) Monitor {
 	if strings.TrimSpace(ms.Address) == "" {
-		return nil
+		return NoOp{}
 	}
 
-	monitorClient, err := client.NewUDPClient(client.UDPConfig{
-		Addr: ms.Address,
-	})
+	var influx client.Client
+	var err error
+	switch ms.Transport {
+	case TransportUDP, "":
+		influx, err = client.NewUDPClient(client.UDPConfig{
+			Addr: ms.Address,
+		})
+	case TransportHTTP:
+		influx, err = client.NewHTTPClient(client.HTTPConfig{
+			Addr:     ms.Address,
+			Username: ms.Username,
+			Password: ms.Password,
+			Timeout:  httpWriteTimeout,
+		})
+	default:
+		err = errors.New(errorUnknownTransport + ms.Transport)
+	}
 	if err != nil {
 		log.Error(logErrorCreatingMonitorConnection, err, l)
+		return NoOp{}
 	}
-	influxClient = monitorClient
 
-	return &Client{
+	mon := &Client{
 		settings: ms,
 		logger:   l,
+		influx:   influx,
+		pending:  &pendingPoints{},
+	}
+	if ms.Transport == TransportHTTP {
+		mon.batch = newBatchWriter(influx, ms, l)
+	}
+
+	return mon
+}
+
+// DroppedPoints returns the number of points which have been dropped, either as they were written once the monitor
+// connection was closed or as they could not be written in batches
+func (mon *Client) DroppedPoints() int64 {
+	dropped := mon.pending.droppedPoints()
+	if mon.batch != nil {
+		dropped += mon.batch.droppedPoints()
+	}
+
+	return dropped
+}
+
+// start tracks a point which is about to be written, returning false, and counting the point as dropped, should the
+// monitor connection have been closed
+func (p *pendingPoints) start() bool {
+	if p == nil {
+		return true
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		p.dropped++
+		return false
+	}
+	p.writing.Add(1)
+
+	return true
+}
+
+// done is called once a point tracked by start has been written, or queued to be written in batches
+func (p *pendingPoints) done() {
+	if p != nil {
+		p.writing.Done()
 	}
 }
 
+// close stops any further points from being written, and then waits for those still being written
+func (p *pendingPoints) close() {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+
+	p.writing.Wait()
+}
+
+// droppedPoints returns the number of points which have been dropped as they were written once closed
+func (p *pendingPoints) droppedPoints() int64 {
+	if p == nil {
+		return 0
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.dropped
+}
+
+// milliseconds returns the duration provided in whole milliseconds
+func milliseconds(d time.Duration) int64 {
+	return d.Nanoseconds() / int64(time.Millisecond)
+}
+
+// WithTags returns a copy of the monitor which adds the tags provided, together with any tags that the monitor
+// already adds, to every point that it writes
+func (mon *Client) WithTags(tags map[string]string) Monitor {
+	tagged := *mon
+	tagged.tags = make(map[string]string, len(mon.tags)+len(tags))
+	for k, v := range mon.tags {
+		tagged.tags[k] = v
+	}
+	for k, v := range tags {
+		tagged.tags[k] = v
+	}
+
+	return &tagged
+}
+
+// writePointAsync writes the point in a separate goroutine, keeping track of it so that it can be flushed when the
+// monitor connection is closed. Should points be written in batches then the point is queued instead. Points written
+// once the monitor connection has been closed are dropped.
+func (mon *Client) writePointAsync(measurementName string, tags map[string]string, fields map[string]interface{}) {
+	if !mon.pending.start() {
+		return
+	}
+
+	if mon.batch != nil {
+		defer mon.pending.done()
+		if pt := mon.newPoint(measurementName, tags, fields); pt != nil {
+			mon.batch.add(pt)
+		}
+		return
+	}
+
+	go func() {
+		defer mon.pending.done()
+		mon.writePoint(measurementName, tags, fields)
+	}()
+}
+
 func (mon *Client) writePoint(measurementName string, tags map[string]string, fields map[string]interface{}) {
 	if strings.TrimSpace(mon.settings.Address) == "" {
 		return
@@ -73,17 +224,53 @@ func (mon *Client) writePoint(measurementName string, tags map[string]string, fi
 		return
 	}
 
-	pt, err := client.NewPoint(measurementName, tags, fields, time.Now())
-	if err != nil {
-		log.Error(logErrorCreatingPoint, err, mon.logger)
+	pt := mon.newPoint(measurementName, tags, fields)
+	if pt == nil {
 		return
 	}
 	bp.AddPoint(pt)
 
-	if influxClient != nil {
-		if err := influxClient.Write(bp); err != nil {
-			log.Error(logErrorWritingPoint, err, mon.logger)
-		}
+	if err := mon.influx.Write(bp); err != nil {
+		log.Error(logErrorWritingPoint, err, mon.logger)
+	}
+}
+
+// newPoint creates a point in the measurement provided with the tags of the monitor together with those provided,
+// which take precedence; nil is returned if the point cannot be created
+func (mon *Client) newPoint(measurementName string, tags map[string]string, fields map[string]interface{}) *client.Point {
+	pointTags := make(map[string]string, len(mon.tags)+len(tags))
+	for k, v := range mon.tags {
+		pointTags[k] = v
+	}
+	for k, v := range tags {
+		pointTags[k] = v
+	}
+
+	pt, err := client.NewPoint(measurementName, pointTags, fields, time.Now())
+	if err != nil {
+		log.Error(logErrorCreatingPoint, err, mon.logger)
+		return nil
+	}
+
+	return pt
+}
+
+// connectionTags returns the tags identifying the client connection provided, including the verified client subject
+// where there is one
+func connectionTags(src net.Conn) map[string]string {
+	tags := map[string]string{
+		tagTCPProxyPoolClientConn: src.LocalAddr().String(),
+		tagTCPProxyPoolServerConn: src.RemoteAddr().String(),
+	}
+	addIdentityTag(tags, src)
+
+	return tags
+}
+
+// addIdentityTag adds the verified client subject of the connection to the tags, if it has one
+func addIdentityTag(tags map[string]string, conn net.Conn) {
+	if identity := cntr.IdentityOf(conn); identity != nil {
+		tags[tagClientSubject] = identity.Subject
 	}
 }
 
@@ -97,49 +284,110 @@ func (mon *Client) WriteBytesCopied(srcIsServer bool, totalBytesCopied int64, ds
 			tagTCPProxyPoolClientConn: dst.LocalAddr().String(),
 			tagTCPProxyPoolServerConn: src.LocalAddr().String(),
 		}
+		addIdentityTag(tags, src)
 	} else {
 		fields = map[string]interface{}{fieldCopiedToServer: totalBytesCopied}
 		tags = map[string]string{
 			tagTCPProxyPoolClientConn: src.LocalAddr().String(),
 			tagTCPProxyPoolServerConn: dst.LocalAddr().String(),
 		}
+		addIdentityTag(tags, dst)
 	}
 
-	go mon.writePoint(
+	mon.writePointAsync(
 		measurementDataTransfer,
 		tags,
 		fields)
 }
 
+// WriteBandwidthThrottled writes the time that copying data for a client connection was delayed by bandwidth limits
+// to the monitor
+func (mon *Client) WriteBandwidthThrottled(src net.Conn, throttled time.Duration) {
+	mon.writePointAsync(
+		measurementDataTransfer,
+		connectionTags(src),
+		map[string]interface{}{fieldThrottledMillis: milliseconds(throttled)})
+}
+
 // WriteConnectionAccepted writes a point to the monitor to indicate that a connection was accepted
 func (mon *Client) WriteConnectionAccepted(src net.Conn) {
-	go mon.writePoint(
+	mon.writePointAsync(
 		measurementConnectionPool,
-		map[string]string{
-			tagTCPProxyPoolClientConn: src.LocalAddr().String(),
-			tagTCPProxyPoolServerConn: src.RemoteAddr().String(),
-		},
+		connectionTags(src),
 		map[string]interface{}{fieldConnectionsAccepted: 1})
 }
 
 // WriteConnectionRejected writes a point to indicate that a connection was rejected
 func (mon *Client) WriteConnectionRejected(src net.Conn) {
-	go mon.writePoint(
+	mon.writePointAsync(
 		measurementConnectionPool,
-		map[string]string{
-			tagTCPProxyPoolClientConn: src.LocalAddr().String(),
-			tagTCPProxyPoolServerConn: src.RemoteAddr().String(),
-		},
+		connectionTags(src),
 		map[string]interface{}{fieldConnectionsRejected: 1})
 }
 
+// WriteConnectionDenied writes a point to indicate that a connection was closed as its client is not allowed to
+// connect by the access control lists
+func (mon *Client) WriteConnectionDenied(src net.Conn) {
+	mon.writePointAsync(
+		measurementConnectionPool,
+		connectionTags(src),
+		map[string]interface{}{fieldConnectionsDenied: 1})
+}
+
+// WriteAuthenticationFailed writes a point to indicate that a client was closed as it did not send a valid token
+func (mon *Client) WriteAuthenticationFailed(src net.Conn) {
+	mon.writePointAsync(
+		measurementConnectionPool,
+		connectionTags(src),
+		map[string]interface{}{fieldAuthenticationFailed: 1})
+}
+
+// WriteHandshakeFailed writes a point to indicate that the TLS handshake with a client failed
+func (mon *Client) WriteHandshakeFailed(src net.Conn) {
+	mon.writePointAsync(
+		measurementConnectionPool,
+		connectionTags(src),
+		map[string]interface{}{fieldHandshakesFailed: 1})
+}
+
+// WriteProtocolRejected writes a point to indicate that a client was rejected as it offered none of the supported
+// application protocols
+func (mon *Client) WriteProtocolRejected(src net.Conn) {
+	mon.writePointAsync(
+		measurementConnectionPool,
+		connectionTags(src),
+		map[string]interface{}{fieldProtocolsRejected: 1})
+}
+
+// WriteSessionTerminated writes a point to indicate that a session was terminated by the proxy, for example as it
+// was idle for too long, together with the reason for doing so
+func (mon *Client) WriteSessionTerminated(src net.Conn, reason string) {
+	tags := connectionTags(src)
+	tags[tagTerminationReason] = reason
+
+	mon.writePointAsync(
+		measurementConnectionPool,
+		tags,
+		map[string]interface{}{fieldSessionsTerminated: 1})
+}
+
+// WriteConnectionLimited writes a point to indicate that a connection was rejected as its client exceeded the limit
+// provided, before any container was assigned to it
+func (mon *Client) WriteConnectionLimited(src net.Conn, limit string) {
+	tags := connectionTags(src)
+	tags[tagLimit] = limit
+
+	mon.writePointAsync(
+		measurementClientLimits,
+		tags,
+		map[string]interface{}{fieldConnectionsLimited: 1})
+}
+
 // WriteConnectionPoolStats writes a the number of connections in use and the pool size to the monitor
 func (mon *Client) WriteConnectionPoolStats(src net.Conn, connectionsInUse, connectionPoolSize int) {
-	go mon.writePoint(
+	mon.writePointAsync(
 		measurementConnectionPool,
-		map[string]string{
-			tagTCPProxyPoolClientConn: src.LocalAddr().String(),
-			tagTCPProxyPoolServerConn: src.RemoteAddr().String()},
+		connectionTags(src),
 		map[string]interface{}{
 			fieldConnectionsInUse:   connectionsInUse,
 			fieldConnectionPoolSize: connectionPoolSize})
@@ -147,25 +395,89 @@ func (mon *Client) WriteConnectionPoolStats(src net.Conn, connectionsInUse, conn
 
 // WriteContainerCreated writes the number of connections created to the monitor
 func (mon *Client) WriteContainerCreated(numContainersCreated int) {
-	go mon.writePoint(
+	mon.writePointAsync(
 		measurementContainerPool,
-		map[string]string{
-		},
+		map[string]string{},
 		map[string]interface{}{fieldContainersCreated: numContainersCreated})
 }
 
 // WriteContainerDestroyed writes the number of connections destroyed to the monitor
 func (mon *Client) WriteContainerDestroyed(numContainersDestroyed int) {
-	go mon.writePoint(
+	mon.writePointAsync(
 		measurementContainerPool,
-		map[string]string{
-		},
+		map[string]string{},
 		map[string]interface{}{fieldContainersDestroyed: numContainersDestroyed})
 }
 
-// CloseMonitorConnection simple closes the InfluxDB client when processing is complete
+// WriteContainerFailed writes the number of containers which have failed and been removed from the pool to the
+// monitor
+func (mon *Client) WriteContainerFailed(numContainersFailed int) {
+	mon.writePointAsync(
+		measurementContainerPool,
+		map[string]string{},
+		map[string]interface{}{fieldContainersFailed: numContainersFailed})
+}
+
+// WriteContainerCreateTime writes the time taken by the container manager to create a container to the monitor
+func (mon *Client) WriteContainerCreateTime(created time.Duration) {
+	mon.writePointAsync(
+		measurementContainerPool,
+		map[string]string{},
+		map[string]interface{}{fieldContainerCreateMillis: milliseconds(created)})
+}
+
+// WriteContainerPoolStats writes the number of containers in the pool, how many of these are free and used, and how
+// many are being created for it to the monitor
+func (mon *Client) WriteContainerPoolStats(size, free, used, starting int) {
+	mon.writePointAsync(
+		measurementContainerPool,
+		map[string]string{},
+		map[string]interface{}{
+			fieldContainers:         size,
+			fieldContainersFree:     free,
+			fieldContainersUsed:     used,
+			fieldContainersStarting: starting})
+}
+
+// WriteBackendDialLatency writes the time taken to connect to a container to the monitor
+func (mon *Client) WriteBackendDialLatency(latency time.Duration) {
+	mon.writePointAsync(
+		measurementConnectionPool,
+		map[string]string{},
+		map[string]interface{}{fieldBackendDialMillis: milliseconds(latency)})
+}
+
+// WriteSessionDuration writes the time from a client connection being accepted to its session ending to the monitor
+func (mon *Client) WriteSessionDuration(src net.Conn, duration time.Duration) {
+	mon.writePointAsync(
+		measurementConnectionPool,
+		connectionTags(src),
+		map[string]interface{}{fieldSessionMillis: milliseconds(duration)})
+}
+
+// WriteCertificateReloaded writes a point to indicate that the certificate presented to clients of the pool provided
+// was reloaded, or that reloading it failed and the previous certificate continues to be used
+func (mon *Client) WriteCertificateReloaded(pool string, success bool) {
+	field := fieldCertificatesReloaded
+	if !success {
+		field = fieldCertificateReloadsFailed
+	}
+
+	mon.writePointAsync(
+		measurementCertificates,
+		map[string]string{TagPool: pool},
+		map[string]interface{}{field: 1})
+}
+
+// CloseMonitorConnection waits for any points still being written, or writes any still queued to be written in
+// batches within the flush timeout, and then closes the InfluxDB client when processing is complete. Any points
+// written from then on are dropped.
 func (mon *Client) CloseMonitorConnection() {
-	if influxClient != nil {
-		influxClient.Close()
+	mon.pending.close()
+	if mon.batch != nil {
+		mon.batch.close()
 	}
+
+	// we're not going to act on Close errors, so ignore purposefully
+	mon.influx.Close()
 }
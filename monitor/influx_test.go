@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+)
+
+func Test_NewPoint(t *testing.T) {
+	l, _ := test.NewNullLogger()
+	mon := &Client{logger: l}
+	tagged := mon.WithTags(map[string]string{TagPool: "alpha", TagListener: "l"}).(*Client)
+
+	// the tags provided take precedence over those of the monitor, and are left unaltered
+	tags := map[string]string{TagPool: "beta"}
+	pt := tagged.newPoint(measurementCertificates, tags, map[string]interface{}{fieldCertificatesReloaded: 1})
+	assert.Equal(t, map[string]string{TagPool: "beta", TagListener: "l"}, pt.Tags())
+	assert.Equal(t, map[string]string{TagPool: "beta"}, tags)
+}
+
+func Test_CloseMonitorConnection(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	l, _ := test.NewNullLogger()
+	mon := CreateMonitor(Settings{Address: conn.LocalAddr().String()}, l)
+	tagged := mon.WithTags(map[string]string{TagPool: "alpha"})
+
+	// points may be written whilst the monitor is being closed, and are either written or dropped
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tagged.WriteContainerCreated(1)
+		}()
+	}
+	mon.CloseMonitorConnection()
+	wg.Wait()
+
+	// once closed, every point written is dropped
+	dropped := mon.(*Client).DroppedPoints()
+	tagged.WriteContainerCreated(1)
+	assert.Equal(t, dropped+1, mon.(*Client).DroppedPoints())
+}
@@ -0,0 +1,382 @@
+package monitor
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MetricsContentType is the content type of the Prometheus text format written by Metrics
+	MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	metricConnectionsAccepted = "tcp_proxy_pool_connections_accepted_total"
+	metricConnectionsRejected = "tcp_proxy_pool_connections_rejected_total"
+	metricBytesCopied         = "tcp_proxy_pool_bytes_copied_total"
+	metricContainers          = "tcp_proxy_pool_containers"
+	metricContainersFree      = "tcp_proxy_pool_containers_free"
+	metricContainersUsed      = "tcp_proxy_pool_containers_used"
+	metricContainersStarting  = "tcp_proxy_pool_containers_starting"
+	metricContainerCreate     = "tcp_proxy_pool_container_create_seconds"
+	metricBackendDial         = "tcp_proxy_pool_backend_dial_seconds"
+	metricSessionDuration     = "tcp_proxy_pool_session_duration_seconds"
+	metricPointsDropped       = "tcp_proxy_pool_monitor_points_dropped_total"
+
+	metricTypeCounter   = "counter"
+	metricTypeGauge     = "gauge"
+	metricTypeHistogram = "histogram"
+
+	labelPool      = "pool"
+	labelListener  = "listener"
+	labelDirection = "direction"
+	labelReason    = "reason"
+
+	directionToContainer = "to-container"
+	directionToClient    = "to-client"
+
+	reasonNoContainer          = "no-container"
+	reasonDenied               = "denied"
+	reasonHandshakeFailed      = "handshake-failed"
+	reasonAuthenticationFailed = "authentication-failed"
+)
+
+type (
+	// Metrics accumulates the points written to the monitor returned by its Monitor method, and those derived from it
+	// using WithTags, so that they can be exposed in the Prometheus text format. Every metric is labelled by the pool
+	// that it relates to, which is empty for connections rejected by a listener before reaching a pool; the connections
+	// rejected are also labelled by the listener so that these can be told apart.
+	Metrics struct {
+		lock sync.Mutex
+
+		// series holds the values of every metric, keyed by the metric name and then by its rendered labels
+		series map[string]map[string]*metricSeries
+
+		// droppers are the monitors whose dropped points are counted
+		droppers []pointDropper
+	}
+
+	// pointDropper is implemented by monitors which may drop points rather than write them, such as a Client writing
+	// points in batches
+	pointDropper interface {
+		DroppedPoints() int64
+	}
+
+	// metricsMonitor is a Monitor which accumulates points in the metrics, labelled by the pool and listener tags of
+	// the monitor; points which are not exposed as metrics are discarded
+	metricsMonitor struct {
+		NoOp
+		metrics  *Metrics
+		pool     string
+		listener string
+	}
+
+	// metricFamily describes a metric; buckets holds the upper bounds of the buckets of a histogram
+	metricFamily struct {
+		name       string
+		help       string
+		metricType string
+		buckets    []float64
+	}
+
+	// metricSeries holds the value of a counter or gauge with a given set of labels. For a histogram the value is the
+	// sum of the observations, and buckets holds the cumulative count of observations in each bucket.
+	metricSeries struct {
+		value   float64
+		count   uint64
+		buckets []uint64
+	}
+)
+
+// metricFamilies holds every metric exposed, in the order in which they are written
+var metricFamilies = []metricFamily{
+	{name: metricConnectionsAccepted, metricType: metricTypeCounter,
+		help: "Client connections which have been assigned a container."},
+	{name: metricConnectionsRejected, metricType: metricTypeCounter,
+		help: "Client connections which were rejected, by listener and reason; pool is empty before routing."},
+	{name: metricBytesCopied, metricType: metricTypeCounter,
+		help: "Bytes copied between clients and containers, by direction."},
+	{name: metricContainers, metricType: metricTypeGauge,
+		help: "Containers in the pool."},
+	{name: metricContainersFree, metricType: metricTypeGauge,
+		help: "Containers in the pool which are not assigned to a client."},
+	{name: metricContainersUsed, metricType: metricTypeGauge,
+		help: "Containers in the pool which are assigned to a client."},
+	{name: metricContainersStarting, metricType: metricTypeGauge,
+		help: "Containers being created for the pool."},
+	{name: metricContainerCreate, metricType: metricTypeHistogram,
+		help:    "Time taken by the container manager to create a container.",
+		buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}},
+	{name: metricBackendDial, metricType: metricTypeHistogram,
+		help:    "Time taken to connect to a container.",
+		buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}},
+	{name: metricSessionDuration, metricType: metricTypeHistogram,
+		help:    "Duration of client sessions.",
+		buckets: []float64{1, 10, 60, 300, 900, 1800, 3600, 14400, 86400}},
+	{name: metricPointsDropped, metricType: metricTypeCounter,
+		help: "Points which the monitor dropped rather than writing them."},
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{series: make(map[string]map[string]*metricSeries)}
+}
+
+// CountDroppedPoints adds the points dropped by the monitor provided to those exposed, should it be a monitor which
+// may drop points
+func (m *Metrics) CountDroppedPoints(mon Monitor) {
+	d, ok := mon.(pointDropper)
+	if !ok {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.droppers = append(m.droppers, d)
+}
+
+// metricLabels renders the label names and values provided, which alternate, for use within the braces of a sample
+func metricLabels(namesAndValues ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(namesAndValues[i+1])
+		labels = append(labels, namesAndValues[i]+`="`+value+`"`)
+	}
+
+	return strings.Join(labels, ",")
+}
+
+// get returns the series of the metric with the labels provided, creating it if required; the lock must be held
+func (m *Metrics) get(name, labels string) *metricSeries {
+	byLabels := m.series[name]
+	if byLabels == nil {
+		byLabels = make(map[string]*metricSeries)
+		m.series[name] = byLabels
+	}
+
+	s := byLabels[labels]
+	if s == nil {
+		s = &metricSeries{}
+		byLabels[labels] = s
+	}
+
+	return s
+}
+
+// add adds the value provided to the counter
+func (m *Metrics) add(name, labels string, value float64) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.get(name, labels).value += value
+}
+
+// set sets the gauge to the value provided
+func (m *Metrics) set(name, labels string, value float64) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.get(name, labels).value = value
+}
+
+// observe records the value provided in the histogram
+func (m *Metrics) observe(name, labels string, value float64) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var buckets []float64
+	for _, f := range metricFamilies {
+		if f.name == name {
+			buckets = f.buckets
+		}
+	}
+
+	s := m.get(name, labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(buckets))
+	}
+	for i, bound := range buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+// writeSample writes a single sample, adding the extra label provided to the labels of the series if it is not empty
+func writeSample(buf *bytes.Buffer, name, labels, extra string, value float64) {
+	if extra != "" {
+		if labels != "" {
+			labels += ","
+		}
+		labels += extra
+	}
+
+	buf.WriteString(name)
+	if labels != "" {
+		buf.WriteString("{" + labels + "}")
+	}
+	buf.WriteString(" " + formatMetricValue(value) + "\n")
+}
+
+// formatMetricValue formats the value as per the Prometheus text format
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WriteTo writes every metric to the writer provided in the Prometheus text format, ordering the series of each
+// metric by their labels
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+
+	m.lock.Lock()
+	if len(m.droppers) > 0 {
+		var dropped int64
+		for _, d := range m.droppers {
+			dropped += d.DroppedPoints()
+		}
+		m.get(metricPointsDropped, "").value = float64(dropped)
+	}
+	for _, f := range metricFamilies {
+		buf.WriteString("# HELP " + f.name + " " + f.help + "\n")
+		buf.WriteString("# TYPE " + f.name + " " + f.metricType + "\n")
+
+		var labels []string
+		for l := range m.series[f.name] {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+
+		for _, l := range labels {
+			s := m.series[f.name][l]
+			if f.metricType != metricTypeHistogram {
+				writeSample(&buf, f.name, l, "", s.value)
+				continue
+			}
+
+			for i, bound := range f.buckets {
+				writeSample(&buf, f.name+"_bucket", l, metricLabels("le", formatMetricValue(bound)), float64(s.buckets[i]))
+			}
+			writeSample(&buf, f.name+"_bucket", l, metricLabels("le", "+Inf"), float64(s.count))
+			writeSample(&buf, f.name+"_sum", l, "", s.value)
+			writeSample(&buf, f.name+"_count", l, "", float64(s.count))
+		}
+	}
+	m.lock.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// Monitor returns a monitor which accumulates the points written to it in the metrics
+func (m *Metrics) Monitor() Monitor {
+	return metricsMonitor{metrics: m}
+}
+
+// labels returns the metric labels identifying the pool that the monitor writes points for
+func (mm metricsMonitor) labels(namesAndValues ...string) string {
+	return metricLabels(append([]string{labelPool, mm.pool}, namesAndValues...)...)
+}
+
+// rejected increments the connections rejected by the listener for the reason provided
+func (mm metricsMonitor) rejected(reason string) {
+	mm.metrics.add(metricConnectionsRejected, mm.labels(labelListener, mm.listener, labelReason, reason), 1)
+}
+
+// WithTags returns a monitor which labels its metrics with the pool and listener tags provided, if there are any
+func (mm metricsMonitor) WithTags(tags map[string]string) Monitor {
+	if pool, ok := tags[TagPool]; ok {
+		mm.pool = pool
+	}
+	if listener, ok := tags[TagListener]; ok {
+		mm.listener = listener
+	}
+
+	return mm
+}
+
+// WriteBytesCopied adds the total copied in one direction of a session to the counter for that direction, as does
+// the InfluxDB monitor, so the counter only increases once the direction is complete
+func (mm metricsMonitor) WriteBytesCopied(srcIsServer bool, totalBytesCopied int64, dst, src net.Conn) {
+	direction := directionToClient
+	if srcIsServer {
+		direction = directionToContainer
+	}
+
+	mm.metrics.add(metricBytesCopied, mm.labels(labelDirection, direction), float64(totalBytesCopied))
+}
+
+// WriteConnectionAccepted increments the connections accepted
+func (mm metricsMonitor) WriteConnectionAccepted(src net.Conn) {
+	mm.metrics.add(metricConnectionsAccepted, mm.labels(), 1)
+}
+
+// WriteConnectionRejected increments the connections rejected as no container could be assigned
+func (mm metricsMonitor) WriteConnectionRejected(src net.Conn) {
+	mm.rejected(reasonNoContainer)
+}
+
+// WriteConnectionDenied increments the connections rejected by the access control lists
+func (mm metricsMonitor) WriteConnectionDenied(src net.Conn) {
+	mm.rejected(reasonDenied)
+}
+
+// WriteConnectionLimited increments the connections rejected by the client limit provided
+func (mm metricsMonitor) WriteConnectionLimited(src net.Conn, limit string) {
+	mm.rejected(limit)
+}
+
+// WriteHandshakeFailed increments the connections rejected as the TLS handshake failed
+func (mm metricsMonitor) WriteHandshakeFailed(src net.Conn) {
+	mm.rejected(reasonHandshakeFailed)
+}
+
+// WriteAuthenticationFailed increments the connections rejected as the client failed to authenticate
+func (mm metricsMonitor) WriteAuthenticationFailed(src net.Conn) {
+	mm.rejected(reasonAuthenticationFailed)
+}
+
+// WriteContainerCreateTime observes the time taken to create a container
+func (mm metricsMonitor) WriteContainerCreateTime(created time.Duration) {
+	mm.metrics.observe(metricContainerCreate, mm.labels(), created.Seconds())
+}
+
+// WriteContainerPoolStats sets the gauges of the number of containers in the pool
+func (mm metricsMonitor) WriteContainerPoolStats(size, free, used, starting int) {
+	labels := mm.labels()
+	mm.metrics.set(metricContainers, labels, float64(size))
+	mm.metrics.set(metricContainersFree, labels, float64(free))
+	mm.metrics.set(metricContainersUsed, labels, float64(used))
+	mm.metrics.set(metricContainersStarting, labels, float64(starting))
+}
+
+// WriteBackendDialLatency observes the time taken to connect to a container
+func (mm metricsMonitor) WriteBackendDialLatency(latency time.Duration) {
+	mm.metrics.observe(metricBackendDial, mm.labels(), latency.Seconds())
+}
+
+// WriteSessionDuration observes the duration of a session
+func (mm metricsMonitor) WriteSessionDuration(src net.Conn, duration time.Duration) {
+	mm.metrics.observe(metricSessionDuration, mm.labels(), duration.Seconds())
+}
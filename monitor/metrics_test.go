@@ -0,0 +1,115 @@
+package monitor
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// writeMetrics returns the metrics in the Prometheus text format
+func writeMetrics(t *testing.T, m *Metrics) string {
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	return buf.String()
+}
+
+func Test_MetricLabels(t *testing.T) {
+	assert.Equal(t, "", metricLabels())
+	assert.Equal(t, `pool="a"`, metricLabels("pool", "a"))
+	assert.Equal(t, `pool="a",direction="b"`, metricLabels("pool", "a", "direction", "b"))
+	assert.Equal(t, `pool="a\"b\\c\nd"`, metricLabels("pool", "a\"b\\c\nd"))
+}
+
+func Test_Metrics(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		var m *Metrics
+		m.add(metricConnectionsAccepted, "", 1)
+		m.set(metricContainers, "", 1)
+		m.observe(metricBackendDial, "", 1)
+		assert.Equal(t, "", writeMetrics(t, m))
+	})
+
+	t.Run("CountersAndGauges", func(t *testing.T) {
+		m := NewMetrics()
+		m.add(metricConnectionsAccepted, `pool="b"`, 1)
+		m.add(metricConnectionsAccepted, `pool="a"`, 1)
+		m.add(metricConnectionsAccepted, `pool="a"`, 2)
+		m.set(metricContainers, `pool="a"`, 4)
+		m.set(metricContainers, `pool="a"`, 3)
+
+		text := writeMetrics(t, m)
+		assert.Contains(t, text, "# HELP "+metricConnectionsAccepted+" ")
+		assert.Contains(t, text, "# TYPE "+metricConnectionsAccepted+" counter\n"+
+			metricConnectionsAccepted+`{pool="a"} 3`+"\n"+
+			metricConnectionsAccepted+`{pool="b"} 1`+"\n")
+		assert.Contains(t, text, "# TYPE "+metricContainers+" gauge\n"+metricContainers+`{pool="a"} 3`+"\n")
+	})
+
+	t.Run("Histogram", func(t *testing.T) {
+		m := NewMetrics()
+		m.observe(metricBackendDial, `pool="a"`, 0.003)
+		m.observe(metricBackendDial, `pool="a"`, 0.2)
+		m.observe(metricBackendDial, `pool="a"`, 10)
+
+		text := writeMetrics(t, m)
+		for _, sample := range []string{
+			metricBackendDial + `_bucket{pool="a",le="0.001"} 0`,
+			metricBackendDial + `_bucket{pool="a",le="0.005"} 1`,
+			metricBackendDial + `_bucket{pool="a",le="0.25"} 2`,
+			metricBackendDial + `_bucket{pool="a",le="2.5"} 2`,
+			metricBackendDial + `_bucket{pool="a",le="+Inf"} 3`,
+			metricBackendDial + `_sum{pool="a"} 10.203`,
+			metricBackendDial + `_count{pool="a"} 3`,
+		} {
+			assert.Contains(t, text, sample+"\n")
+		}
+	})
+}
+
+func Test_MetricsMonitor(t *testing.T) {
+	m := NewMetrics()
+	tagged := m.Monitor().WithTags(map[string]string{TagPool: "alpha"}).WithTags(map[string]string{TagListener: "l"})
+
+	tagged.WriteContainerPoolStats(3, 2, 1, 1)
+	tagged.WriteContainerCreateTime(0)
+	tagged.WriteBytesCopied(true, 4, nil, nil)
+	tagged.WriteBytesCopied(true, 6, nil, nil)
+	tagged.WriteBytesCopied(false, 5, nil, nil)
+	tagged.WriteConnectionRejected(nil)
+	tagged.WriteConnectionDenied(nil)
+	tagged.WriteConnectionDenied(nil)
+	tagged.WriteConnectionLimited(nil, "connection-rate")
+	tagged.WriteHandshakeFailed(nil)
+	tagged.WriteAuthenticationFailed(nil)
+	// points which are not exposed as metrics are discarded
+	tagged.WriteContainerDestroyed(1)
+	// connections rejected by a listener before reaching a pool have no pool
+	m.Monitor().WithTags(map[string]string{TagListener: "l"}).WriteConnectionDenied(nil)
+
+	text := writeMetrics(t, m)
+	assert.Contains(t, text, metricContainersFree+`{pool="alpha"} 2`+"\n")
+	assert.Contains(t, text, metricContainersStarting+`{pool="alpha"} 1`+"\n")
+	assert.Contains(t, text, metricContainerCreate+`_count{pool="alpha"} 1`+"\n")
+	assert.Contains(t, text, metricBytesCopied+`{pool="alpha",direction="to-client"} 5`+"\n")
+	assert.Contains(t, text, metricBytesCopied+`{pool="alpha",direction="to-container"} 10`+"\n")
+	assert.Contains(t, text, metricConnectionsRejected+`{pool="alpha",listener="l",reason="no-container"} 1`+"\n")
+	assert.Contains(t, text, metricConnectionsRejected+`{pool="alpha",listener="l",reason="denied"} 2`+"\n")
+	assert.Contains(t, text, metricConnectionsRejected+`{pool="alpha",listener="l",reason="connection-rate"} 1`+"\n")
+	assert.Contains(t, text, metricConnectionsRejected+`{pool="alpha",listener="l",reason="handshake-failed"} 1`+"\n")
+	assert.Contains(t, text, metricConnectionsRejected+`{pool="alpha",listener="l",reason="authentication-failed"} 1`+"\n")
+	assert.Contains(t, text, metricConnectionsRejected+`{pool="",listener="l",reason="denied"} 1`+"\n")
+}
+
+func Test_CountDroppedPoints(t *testing.T) {
+	m := NewMetrics()
+
+	// monitors which never drop points are not counted
+	m.CountDroppedPoints(NoOp{})
+	assert.NotContains(t, writeMetrics(t, m), "\n"+metricPointsDropped+" ")
+
+	m.CountDroppedPoints(&Client{batch: &batchWriter{dropped: 3}})
+	m.CountDroppedPoints(&Client{batch: &batchWriter{dropped: 2}})
+	assert.Contains(t, writeMetrics(t, m), "\n"+metricPointsDropped+" 5\n")
+}
@@ -1,32 +1,111 @@
 package monitor
 
 import (
+	"github.com/influxdata/influxdb/client/v2"
 	"github.com/sirupsen/logrus"
 	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// TagPool is the tag used to identify the container pool that a point relates to
+	TagPool = "pool"
+	// TagListener is the tag used to identify the listener that a point relates to
+	TagListener = "listener"
+
+	// TransportUDP writes each point to InfluxDB as it occurs over UDP; this is the default
+	TransportUDP = "udp"
+	// TransportHTTP buffers points and writes them to InfluxDB in batches using the HTTP write API
+	TransportHTTP = "http"
 )
 
 type (
 	// Settings represents the various configuration parameters for a monitor and are typically read
 	// from an external configuration file
 	Settings struct {
+		// Address is host:port when using UDP, and the URL of the server, for example http://influxdb:8086, when
+		// using HTTP
 		Address  string
 		Database string
+
+		// Transport is either TransportUDP or TransportHTTP; if empty then UDP is used. The remaining settings only
+		// apply to HTTP.
+		Transport string
+
+		// Username and Password authenticate the writes, and RetentionPolicy is that of the points written; the
+		// default retention policy of the database is used if it is empty
+		Username        string
+		Password        string
+		RetentionPolicy string
+
+		// QueueSize is the number of points buffered before further points are dropped, and BatchSize the number
+		// written at once; a batch is written once it is full or every FlushIntervalSec, whichever happens first.
+		// A batch which cannot be written is retried up to MaxRetries times, doubling the delay after each failure,
+		// before its points are dropped. Defaults are used for any which are 0. Points still queued once the monitor
+		// is closed are dropped should they not have been written within 15 seconds.
+		QueueSize        int
+		BatchSize        int
+		FlushIntervalSec int
+		MaxRetries       int
 	}
 
-	// Client represents the internal representation of a monitor, specifically containing
-	// references to the logging components, monitor client etc needed
+	// Client is a Monitor which writes points to InfluxDB, specifically containing references to the logging
+	// components, InfluxDB client etc needed. Points are written over UDP as they occur, or buffered and written in
+	// batches over HTTP.
 	Client struct {
 		logger   *logrus.Logger
 		settings Settings
+
+		// influx and batch are shared by every copy of the Client made by WithTags; batch is nil unless points are
+		// written in batches
+		influx client.Client
+		batch  *batchWriter
+
+		// tags are added to every point written, for example to identify the pool that the point relates to
+		tags map[string]string
+
+		// pending tracks the points which are still being written, so that they can be flushed on close; it is a
+		// pointer as it is shared by every copy of the Client
+		pending *pendingPoints
 	}
 
-	// Monitor should be implemented to write to a time-series database for the various methods required
+	// pendingPoints tracks the points still being written by a Client; once closed, any further points are dropped
+	// and counted rather than written
+	pendingPoints struct {
+		lock    sync.Mutex
+		writing sync.WaitGroup
+		closed  bool
+		dropped int64
+	}
+
+	// Monitor is implemented by every monitor backend, for example to write to a time-series database, and is used
+	// by every component which writes points. NoOp discards points, NewMultiMonitor writes them to several monitors
+	// and Recorder records them in memory for tests.
 	Monitor interface {
+		// WithTags returns a monitor which adds the tags provided, together with any tags that the monitor already
+		// adds, to every point that it writes
+		WithTags(tags map[string]string) Monitor
+
 		WriteBytesCopied(srcIsServer bool, totalBytesCopied int64, dst, src net.Conn)
+		WriteBandwidthThrottled(src net.Conn, throttled time.Duration)
 		WriteConnectionAccepted(src net.Conn)
+		WriteConnectionRejected(src net.Conn)
+		WriteConnectionDenied(src net.Conn)
+		WriteHandshakeFailed(src net.Conn)
+		WriteAuthenticationFailed(src net.Conn)
+		WriteProtocolRejected(src net.Conn)
+		WriteSessionTerminated(src net.Conn, reason string)
+		WriteConnectionLimited(src net.Conn, limit string)
 		WriteConnectionPoolStats(src net.Conn, connectionsInUse, connectionPoolSize int)
 		WriteContainerCreated(numContainersCreated int)
 		WriteContainerDestroyed(numContainersDestroyed int)
+		WriteContainerFailed(numContainersFailed int)
+		WriteContainerCreateTime(created time.Duration)
+		WriteContainerPoolStats(size, free, used, starting int)
+		WriteBackendDialLatency(latency time.Duration)
+		WriteSessionDuration(src net.Conn, duration time.Duration)
+		WriteCertificateReloaded(pool string, success bool)
 		CloseMonitorConnection()
 	}
 )
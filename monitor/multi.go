@@ -0,0 +1,174 @@
+package monitor
+
+import (
+	"net"
+	"time"
+)
+
+type (
+	// multiMonitor writes every point to each of its monitors in turn
+	multiMonitor []Monitor
+)
+
+// NewMultiMonitor returns a monitor which writes every point to each of the monitors provided, for example to write
+// to InfluxDB whilst also exposing metrics to Prometheus. Nil monitors are ignored.
+func NewMultiMonitor(monitors ...Monitor) Monitor {
+	var multi multiMonitor
+	for _, m := range monitors {
+		if m != nil {
+			multi = append(multi, m)
+		}
+	}
+
+	return multi
+}
+
+// WithTags returns a monitor which writes to each of the monitors with the tags provided added
+func (multi multiMonitor) WithTags(tags map[string]string) Monitor {
+	tagged := make(multiMonitor, len(multi))
+	for i, m := range multi {
+		tagged[i] = m.WithTags(tags)
+	}
+
+	return tagged
+}
+
+// WriteBytesCopied writes the number of bytes copied to every monitor
+func (multi multiMonitor) WriteBytesCopied(srcIsServer bool, totalBytesCopied int64, dst, src net.Conn) {
+	for _, m := range multi {
+		m.WriteBytesCopied(srcIsServer, totalBytesCopied, dst, src)
+	}
+}
+
+// WriteBandwidthThrottled writes the time that a client connection was throttled to every monitor
+func (multi multiMonitor) WriteBandwidthThrottled(src net.Conn, throttled time.Duration) {
+	for _, m := range multi {
+		m.WriteBandwidthThrottled(src, throttled)
+	}
+}
+
+// WriteConnectionAccepted writes a point to every monitor to indicate that a connection was accepted
+func (multi multiMonitor) WriteConnectionAccepted(src net.Conn) {
+	for _, m := range multi {
+		m.WriteConnectionAccepted(src)
+	}
+}
+
+// WriteConnectionRejected writes a point to every monitor to indicate that a connection was rejected
+func (multi multiMonitor) WriteConnectionRejected(src net.Conn) {
+	for _, m := range multi {
+		m.WriteConnectionRejected(src)
+	}
+}
+
+// WriteConnectionDenied writes a point to every monitor to indicate that a connection was denied
+func (multi multiMonitor) WriteConnectionDenied(src net.Conn) {
+	for _, m := range multi {
+		m.WriteConnectionDenied(src)
+	}
+}
+
+// WriteHandshakeFailed writes a point to every monitor to indicate that a TLS handshake failed
+func (multi multiMonitor) WriteHandshakeFailed(src net.Conn) {
+	for _, m := range multi {
+		m.WriteHandshakeFailed(src)
+	}
+}
+
+// WriteAuthenticationFailed writes a point to every monitor to indicate that a client failed to authenticate
+func (multi multiMonitor) WriteAuthenticationFailed(src net.Conn) {
+	for _, m := range multi {
+		m.WriteAuthenticationFailed(src)
+	}
+}
+
+// WriteProtocolRejected writes a point to every monitor to indicate that a client offered no supported protocol
+func (multi multiMonitor) WriteProtocolRejected(src net.Conn) {
+	for _, m := range multi {
+		m.WriteProtocolRejected(src)
+	}
+}
+
+// WriteSessionTerminated writes a point to every monitor to indicate that a session was terminated
+func (multi multiMonitor) WriteSessionTerminated(src net.Conn, reason string) {
+	for _, m := range multi {
+		m.WriteSessionTerminated(src, reason)
+	}
+}
+
+// WriteConnectionLimited writes a point to every monitor to indicate that a client exceeded a limit
+func (multi multiMonitor) WriteConnectionLimited(src net.Conn, limit string) {
+	for _, m := range multi {
+		m.WriteConnectionLimited(src, limit)
+	}
+}
+
+// WriteConnectionPoolStats writes the number of connections in use and the pool size to every monitor
+func (multi multiMonitor) WriteConnectionPoolStats(src net.Conn, connectionsInUse, connectionPoolSize int) {
+	for _, m := range multi {
+		m.WriteConnectionPoolStats(src, connectionsInUse, connectionPoolSize)
+	}
+}
+
+// WriteContainerCreated writes the number of containers created to every monitor
+func (multi multiMonitor) WriteContainerCreated(numContainersCreated int) {
+	for _, m := range multi {
+		m.WriteContainerCreated(numContainersCreated)
+	}
+}
+
+// WriteContainerDestroyed writes the number of containers destroyed to every monitor
+func (multi multiMonitor) WriteContainerDestroyed(numContainersDestroyed int) {
+	for _, m := range multi {
+		m.WriteContainerDestroyed(numContainersDestroyed)
+	}
+}
+
+// WriteContainerFailed writes the number of containers which have failed to every monitor
+func (multi multiMonitor) WriteContainerFailed(numContainersFailed int) {
+	for _, m := range multi {
+		m.WriteContainerFailed(numContainersFailed)
+	}
+}
+
+// WriteContainerCreateTime writes the time taken to create a container to every monitor
+func (multi multiMonitor) WriteContainerCreateTime(created time.Duration) {
+	for _, m := range multi {
+		m.WriteContainerCreateTime(created)
+	}
+}
+
+// WriteContainerPoolStats writes the number of containers in the pool to every monitor
+func (multi multiMonitor) WriteContainerPoolStats(size, free, used, starting int) {
+	for _, m := range multi {
+		m.WriteContainerPoolStats(size, free, used, starting)
+	}
+}
+
+// WriteBackendDialLatency writes the time taken to connect to a container to every monitor
+func (multi multiMonitor) WriteBackendDialLatency(latency time.Duration) {
+	for _, m := range multi {
+		m.WriteBackendDialLatency(latency)
+	}
+}
+
+// WriteSessionDuration writes the duration of a session to every monitor
+func (multi multiMonitor) WriteSessionDuration(src net.Conn, duration time.Duration) {
+	for _, m := range multi {
+		m.WriteSessionDuration(src, duration)
+	}
+}
+
+// WriteCertificateReloaded writes a point to every monitor to indicate that a certificate was reloaded
+func (multi multiMonitor) WriteCertificateReloaded(pool string, success bool) {
+	for _, m := range multi {
+		m.WriteCertificateReloaded(pool, success)
+	}
+}
+
+// CloseMonitorConnection closes every monitor
+func (multi multiMonitor) CloseMonitorConnection() {
+	for _, m := range multi {
+		m.CloseMonitorConnection()
+	}
+}
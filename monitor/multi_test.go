@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_MultiMonitor(t *testing.T) {
+	first, second := NewRecorder(), NewRecorder()
+	multi := NewMultiMonitor(first, nil, second)
+
+	tagged := multi.WithTags(map[string]string{TagPool: "alpha"})
+	tagged.WriteContainerCreated(2)
+	tagged.WriteSessionTerminated(nil, "idle")
+	multi.WriteBackendDialLatency(time.Second)
+	multi.CloseMonitorConnection()
+
+	for _, r := range []*Recorder{first, second} {
+		assert.Equal(t, []Point{
+			{Method: "WriteContainerCreated", Tags: map[string]string{TagPool: "alpha"}, Values: []interface{}{2}},
+			{Method: "WriteSessionTerminated", Tags: map[string]string{TagPool: "alpha"}, Values: []interface{}{"idle"}},
+			{Method: "WriteBackendDialLatency", Tags: map[string]string{}, Values: []interface{}{time.Second}},
+		}, r.Points())
+		assert.True(t, r.Closed())
+	}
+}
+
+func Test_Recorder(t *testing.T) {
+	r := NewRecorder()
+	pool := r.WithTags(map[string]string{TagPool: "alpha"})
+	listener := pool.WithTags(map[string]string{TagListener: "l"})
+
+	listener.WriteConnectionAccepted(nil)
+	pool.WriteConnectionRejected(nil)
+	listener.WriteConnectionAccepted(nil)
+
+	accepted := r.PointsFor("WriteConnectionAccepted")
+	assert.Equal(t, 2, len(accepted))
+	assert.Equal(t, map[string]string{TagPool: "alpha", TagListener: "l"}, accepted[0].Tags)
+	assert.Equal(t, map[string]string{TagPool: "alpha"}, r.PointsFor("WriteConnectionRejected")[0].Tags)
+	assert.False(t, r.Closed())
+}
+
+func Test_CreateMonitor(t *testing.T) {
+	assert.Equal(t, NoOp{}, CreateMonitor(Settings{}, nil))
+	assert.Equal(t, NoOp{}, NoOp{}.WithTags(map[string]string{TagPool: "alpha"}))
+}
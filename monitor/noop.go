@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"net"
+	"time"
+)
+
+type (
+	// NoOp is a Monitor which discards every point written to it. It is used when no monitor is configured, and may
+	// be embedded by monitors which only act on some points.
+	NoOp struct{}
+)
+
+// WithTags returns the monitor, as there are no points to add the tags to
+func (NoOp) WithTags(tags map[string]string) Monitor {
+	return NoOp{}
+}
+
+// WriteBytesCopied does nothing
+func (NoOp) WriteBytesCopied(srcIsServer bool, totalBytesCopied int64, dst, src net.Conn) {}
+
+// WriteBandwidthThrottled does nothing
+func (NoOp) WriteBandwidthThrottled(src net.Conn, throttled time.Duration) {}
+
+// WriteConnectionAccepted does nothing
+func (NoOp) WriteConnectionAccepted(src net.Conn) {}
+
+// WriteConnectionRejected does nothing
+func (NoOp) WriteConnectionRejected(src net.Conn) {}
+
+// WriteConnectionDenied does nothing
+func (NoOp) WriteConnectionDenied(src net.Conn) {}
+
+// WriteHandshakeFailed does nothing
+func (NoOp) WriteHandshakeFailed(src net.Conn) {}
+
+// WriteAuthenticationFailed does nothing
+func (NoOp) WriteAuthenticationFailed(src net.Conn) {}
+
+// WriteProtocolRejected does nothing
+func (NoOp) WriteProtocolRejected(src net.Conn) {}
+
+// WriteSessionTerminated does nothing
+func (NoOp) WriteSessionTerminated(src net.Conn, reason string) {}
+
+// WriteConnectionLimited does nothing
+func (NoOp) WriteConnectionLimited(src net.Conn, limit string) {}
+
+// WriteConnectionPoolStats does nothing
+func (NoOp) WriteConnectionPoolStats(src net.Conn, connectionsInUse, connectionPoolSize int) {}
+
+// WriteContainerCreated does nothing
+func (NoOp) WriteContainerCreated(numContainersCreated int) {}
+
+// WriteContainerDestroyed does nothing
+func (NoOp) WriteContainerDestroyed(numContainersDestroyed int) {}
+
+// WriteContainerFailed does nothing
+func (NoOp) WriteContainerFailed(numContainersFailed int) {}
+
+// WriteContainerCreateTime does nothing
+func (NoOp) WriteContainerCreateTime(created time.Duration) {}
+
+// WriteContainerPoolStats does nothing
+func (NoOp) WriteContainerPoolStats(size, free, used, starting int) {}
+
+// WriteBackendDialLatency does nothing
+func (NoOp) WriteBackendDialLatency(latency time.Duration) {}
+
+// WriteSessionDuration does nothing
+func (NoOp) WriteSessionDuration(src net.Conn, duration time.Duration) {}
+
+// WriteCertificateReloaded does nothing
+func (NoOp) WriteCertificateReloaded(pool string, success bool) {}
+
+// CloseMonitorConnection does nothing
+func (NoOp) CloseMonitorConnection() {}
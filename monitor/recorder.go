@@ -0,0 +1,193 @@
+package monitor
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// Point is a point recorded by a Recorder: the name of the Monitor method called to write it, the tags of the
+	// monitor it was written to, and the values passed to the method other than connections
+	Point struct {
+		Method string
+		Tags   map[string]string
+		Values []interface{}
+	}
+
+	// Recorder is a Monitor which records every point written to it in memory, for use in tests. Monitors returned
+	// by WithTags record their points in the Recorder they were derived from.
+	Recorder struct {
+		tags     map[string]string
+		recorded *recordedPoints
+	}
+
+	// recordedPoints is shared by a Recorder and every monitor derived from it
+	recordedPoints struct {
+		sync.Mutex
+		points []Point
+		closed bool
+	}
+)
+
+// NewRecorder creates a Recorder which has not recorded any points
+func NewRecorder() *Recorder {
+	return &Recorder{recorded: &recordedPoints{}}
+}
+
+// Points returns every point recorded, in the order in which they were written
+func (r *Recorder) Points() []Point {
+	r.recorded.Lock()
+	defer r.recorded.Unlock()
+
+	return append([]Point(nil), r.recorded.points...)
+}
+
+// PointsFor returns the points recorded by calls to the Monitor method named
+func (r *Recorder) PointsFor(method string) []Point {
+	var points []Point
+	for _, p := range r.Points() {
+		if p.Method == method {
+			points = append(points, p)
+		}
+	}
+
+	return points
+}
+
+// Closed returns true once CloseMonitorConnection has been called
+func (r *Recorder) Closed() bool {
+	r.recorded.Lock()
+	defer r.recorded.Unlock()
+
+	return r.recorded.closed
+}
+
+// record records a point written by the method named with the values provided
+func (r *Recorder) record(method string, values ...interface{}) {
+	tags := make(map[string]string, len(r.tags))
+	for k, v := range r.tags {
+		tags[k] = v
+	}
+
+	r.recorded.Lock()
+	defer r.recorded.Unlock()
+
+	r.recorded.points = append(r.recorded.points, Point{Method: method, Tags: tags, Values: values})
+}
+
+// WithTags returns a monitor which records points in the same Recorder with the tags provided added
+func (r *Recorder) WithTags(tags map[string]string) Monitor {
+	tagged := &Recorder{tags: make(map[string]string, len(r.tags)+len(tags)), recorded: r.recorded}
+	for k, v := range r.tags {
+		tagged.tags[k] = v
+	}
+	for k, v := range tags {
+		tagged.tags[k] = v
+	}
+
+	return tagged
+}
+
+// WriteBytesCopied records the direction and number of bytes copied
+func (r *Recorder) WriteBytesCopied(srcIsServer bool, totalBytesCopied int64, dst, src net.Conn) {
+	r.record("WriteBytesCopied", srcIsServer, totalBytesCopied)
+}
+
+// WriteBandwidthThrottled records the time throttled
+func (r *Recorder) WriteBandwidthThrottled(src net.Conn, throttled time.Duration) {
+	r.record("WriteBandwidthThrottled", throttled)
+}
+
+// WriteConnectionAccepted records that a connection was accepted
+func (r *Recorder) WriteConnectionAccepted(src net.Conn) {
+	r.record("WriteConnectionAccepted")
+}
+
+// WriteConnectionRejected records that a connection was rejected
+func (r *Recorder) WriteConnectionRejected(src net.Conn) {
+	r.record("WriteConnectionRejected")
+}
+
+// WriteConnectionDenied records that a connection was denied
+func (r *Recorder) WriteConnectionDenied(src net.Conn) {
+	r.record("WriteConnectionDenied")
+}
+
+// WriteHandshakeFailed records that a TLS handshake failed
+func (r *Recorder) WriteHandshakeFailed(src net.Conn) {
+	r.record("WriteHandshakeFailed")
+}
+
+// WriteAuthenticationFailed records that a client failed to authenticate
+func (r *Recorder) WriteAuthenticationFailed(src net.Conn) {
+	r.record("WriteAuthenticationFailed")
+}
+
+// WriteProtocolRejected records that a client offered no supported protocol
+func (r *Recorder) WriteProtocolRejected(src net.Conn) {
+	r.record("WriteProtocolRejected")
+}
+
+// WriteSessionTerminated records the reason that a session was terminated
+func (r *Recorder) WriteSessionTerminated(src net.Conn, reason string) {
+	r.record("WriteSessionTerminated", reason)
+}
+
+// WriteConnectionLimited records the limit that a client exceeded
+func (r *Recorder) WriteConnectionLimited(src net.Conn, limit string) {
+	r.record("WriteConnectionLimited", limit)
+}
+
+// WriteConnectionPoolStats records the number of connections in use and the pool size
+func (r *Recorder) WriteConnectionPoolStats(src net.Conn, connectionsInUse, connectionPoolSize int) {
+	r.record("WriteConnectionPoolStats", connectionsInUse, connectionPoolSize)
+}
+
+// WriteContainerCreated records the number of containers created
+func (r *Recorder) WriteContainerCreated(numContainersCreated int) {
+	r.record("WriteContainerCreated", numContainersCreated)
+}
+
+// WriteContainerDestroyed records the number of containers destroyed
+func (r *Recorder) WriteContainerDestroyed(numContainersDestroyed int) {
+	r.record("WriteContainerDestroyed", numContainersDestroyed)
+}
+
+// WriteContainerFailed records the number of containers which have failed
+func (r *Recorder) WriteContainerFailed(numContainersFailed int) {
+	r.record("WriteContainerFailed", numContainersFailed)
+}
+
+// WriteContainerCreateTime records the time taken to create a container
+func (r *Recorder) WriteContainerCreateTime(created time.Duration) {
+	r.record("WriteContainerCreateTime", created)
+}
+
+// WriteContainerPoolStats records the number of containers in the pool
+func (r *Recorder) WriteContainerPoolStats(size, free, used, starting int) {
+	r.record("WriteContainerPoolStats", size, free, used, starting)
+}
+
+// WriteBackendDialLatency records the time taken to connect to a container
+func (r *Recorder) WriteBackendDialLatency(latency time.Duration) {
+	r.record("WriteBackendDialLatency", latency)
+}
+
+// WriteSessionDuration records the duration of a session
+func (r *Recorder) WriteSessionDuration(src net.Conn, duration time.Duration) {
+	r.record("WriteSessionDuration", duration)
+}
+
+// WriteCertificateReloaded records the pool whose certificate was reloaded, and whether this succeeded
+func (r *Recorder) WriteCertificateReloaded(pool string, success bool) {
+	r.record("WriteCertificateReloaded", pool, success)
+}
+
+// CloseMonitorConnection records that the monitor has been closed
+func (r *Recorder) CloseMonitorConnection() {
+	r.recorded.Lock()
+	defer r.recorded.Unlock()
+
+	r.recorded.closed = true
+}
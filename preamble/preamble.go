@@ -0,0 +1,111 @@
+// Package preamble reads and validates the authentication token that a client sends before anything else once it has
+// connected, so that only authenticated clients are assigned a container.
+package preamble
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	// FramingLine is a token sent as a single line, terminated by LF or CRLF
+	FramingLine = "line"
+	// FramingJSON is a JSON object with a Token field, preceded by its length as a 4-byte big-endian integer
+	FramingJSON = "json"
+
+	// MaxLength is the maximum length of a token line or JSON frame
+	MaxLength = 4096
+
+	errorUnknownFraming = "unknown authentication framing: "
+	errorTooLong        = "authentication preamble is too long"
+	errorEmptyToken     = "authentication preamble holds no token"
+)
+
+type (
+	// frame is the JSON object sent when FramingJSON is used
+	frame struct {
+		Token string
+	}
+)
+
+// ValidFraming returns an error if the framing provided is not one of the Framing constants
+func ValidFraming(framing string) error {
+	if framing != FramingLine && framing != FramingJSON {
+		return errors.New(errorUnknownFraming + framing)
+	}
+
+	return nil
+}
+
+// ReadToken reads the token sent by the client using the framing provided. Nothing is read beyond the end of the
+// preamble, so that whatever the client sends next can be passed on untouched.
+func ReadToken(r io.Reader, framing string) (string, error) {
+	var token string
+	var err error
+
+	switch framing {
+	case FramingLine:
+		token, err = readLine(r)
+	case FramingJSON:
+		token, err = readFrame(r)
+	default:
+		return "", errors.New(errorUnknownFraming + framing)
+	}
+
+	if err != nil {
+		return "", err
+	}
+	if token = strings.TrimSpace(token); token == "" {
+		return "", errors.New(errorEmptyToken)
+	}
+
+	return token, nil
+}
+
+// readLine reads a line a byte at a time, as any buffering would consume data following it
+func readLine(r io.Reader) (string, error) {
+	line := make([]byte, 0, 256)
+	b := make([]byte, 1)
+
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		if len(line) >= MaxLength {
+			return "", errors.New(errorTooLong)
+		}
+		line = append(line, b[0])
+	}
+}
+
+// readFrame reads a length-prefixed JSON frame and returns the token that it holds
+func readFrame(r io.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if length > MaxLength {
+		return "", errors.New(errorTooLong)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+
+	var f frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", err
+	}
+
+	return f.Token, nil
+}
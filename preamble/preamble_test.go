@@ -0,0 +1,94 @@
+package preamble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+// jsonFrame returns the length-prefixed JSON frame holding the body provided
+func jsonFrame(body string) []byte {
+	frame := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+
+	return append(frame, body...)
+}
+
+func Test_ValidFraming(t *testing.T) {
+	assert.Nil(t, ValidFraming(FramingLine))
+	assert.Nil(t, ValidFraming(FramingJSON))
+	assert.Equal(t, errorUnknownFraming+"xml", ValidFraming("xml").Error())
+}
+
+func Test_ReadTokenLine(t *testing.T) {
+	t.Run("TokenThenData", func(t *testing.T) {
+		r := strings.NewReader("secret\r\nhello")
+		token, err := ReadToken(r, FramingLine)
+		assert.Nil(t, err)
+		assert.Equal(t, "secret", token)
+
+		// the data following the token is left unread
+		rest := new(bytes.Buffer)
+		rest.ReadFrom(r)
+		assert.Equal(t, "hello", rest.String())
+	})
+
+	t.Run("EmptyToken", func(t *testing.T) {
+		_, err := ReadToken(strings.NewReader("  \n"), FramingLine)
+		assert.Equal(t, errorEmptyToken, err.Error())
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		_, err := ReadToken(strings.NewReader(strings.Repeat("a", MaxLength+1)+"\n"), FramingLine)
+		assert.Equal(t, errorTooLong, err.Error())
+	})
+
+	t.Run("Unterminated", func(t *testing.T) {
+		_, err := ReadToken(strings.NewReader("secret"), FramingLine)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
+
+func Test_ReadTokenJSON(t *testing.T) {
+	t.Run("TokenThenData", func(t *testing.T) {
+		r := bytes.NewReader(append(jsonFrame(`{"Token": "secret"}`), "hello"...))
+		token, err := ReadToken(r, FramingJSON)
+		assert.Nil(t, err)
+		assert.Equal(t, "secret", token)
+
+		rest := new(bytes.Buffer)
+		rest.ReadFrom(r)
+		assert.Equal(t, "hello", rest.String())
+	})
+
+	t.Run("NoToken", func(t *testing.T) {
+		_, err := ReadToken(bytes.NewReader(jsonFrame(`{}`)), FramingJSON)
+		assert.Equal(t, errorEmptyToken, err.Error())
+	})
+
+	t.Run("InvalidJSON", func(t *testing.T) {
+		_, err := ReadToken(bytes.NewReader(jsonFrame(`{"Token": `)), FramingJSON)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		frame := make([]byte, 4)
+		binary.BigEndian.PutUint32(frame, MaxLength+1)
+		_, err := ReadToken(bytes.NewReader(frame), FramingJSON)
+		assert.Equal(t, errorTooLong, err.Error())
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		frame := jsonFrame(`{"Token": "secret"}`)
+		_, err := ReadToken(bytes.NewReader(frame[:len(frame)-1]), FramingJSON)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
+
+func Test_ReadTokenUnknownFraming(t *testing.T) {
+	_, err := ReadToken(strings.NewReader("secret\n"), "xml")
+	assert.Equal(t, errorUnknownFraming+"xml", err.Error())
+}
@@ -0,0 +1,170 @@
+package preamble
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MinHMACKeyLength is the minimum length of the key used to sign tokens
+	MinHMACKeyLength = 32
+
+	errorNoTokens         = "no tokens in "
+	errorHMACKeyTooShort  = "HMAC key is too short: "
+	errorUnknownToken     = "unknown token"
+	errorMalformedToken   = "malformed token"
+	errorInvalidSignature = "invalid token signature"
+	errorTokenExpired     = "token has expired"
+)
+
+type (
+	// Validator validates the token sent by a client, returning the subject that it identifies
+	Validator interface {
+		Validate(token string) (subject string, err error)
+	}
+
+	// TokenList accepts any of a static list of tokens; the subject of each is the token's position in the list, so
+	// that tokens are not logged
+	TokenList struct {
+		tokens [][]byte
+	}
+
+	// HMACValidator accepts tokens signed using a shared key which have not yet expired
+	HMACValidator struct {
+		key []byte
+		now func() time.Time
+	}
+
+	// Claims are the contents of a signed token: the subject that it identifies and when it expires, as a Unix time
+	Claims struct {
+		Subject string `json:"sub"`
+		Expiry  int64  `json:"exp"`
+	}
+)
+
+// ReadTokenFile creates a TokenList from the file provided, which holds one token per line; blank lines and those
+// starting with # are ignored
+func ReadTokenFile(file string) (*TokenList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &TokenList{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list.tokens = append(list.tokens, []byte(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(list.tokens) == 0 {
+		return nil, errors.New(errorNoTokens + file)
+	}
+
+	return list, nil
+}
+
+// Validate returns the subject of the token provided should it be in the list. Every token is compared in constant
+// time, so that the time taken does not reveal how much of a token is correct.
+func (l *TokenList) Validate(token string) (string, error) {
+	match := -1
+	for i, t := range l.tokens {
+		if subtle.ConstantTimeCompare(t, []byte(token)) == 1 {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return "", errors.New(errorUnknownToken)
+	}
+
+	return "token-" + strconv.Itoa(match+1), nil
+}
+
+// ReadHMACKeyFile creates an HMACValidator using the key in the file provided, ignoring any surrounding whitespace
+func ReadHMACKeyFile(file string) (*HMACValidator, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) < MinHMACKeyLength {
+		return nil, errors.New(errorHMACKeyTooShort + file)
+	}
+
+	return NewHMACValidator(key), nil
+}
+
+// NewHMACValidator creates an HMACValidator using the key provided
+func NewHMACValidator(key []byte) *HMACValidator {
+	return &HMACValidator{key: key, now: time.Now}
+}
+
+// Sign creates a token for the claims provided, signed using the key. The token is the base64url-encoded JSON claims
+// followed by a full stop and the base64url-encoded HMAC-SHA256 signature of the encoded claims.
+func Sign(key []byte, claims Claims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature(key, payload)), nil
+}
+
+// signature returns the HMAC-SHA256 signature of the payload provided
+func signature(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+// Validate returns the subject of the token provided should it have been signed using the key and not have expired
+func (v *HMACValidator) Validate(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", errors.New(errorMalformedToken)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New(errorMalformedToken)
+	}
+	if !hmac.Equal(sig, signature(v.key, parts[0])) {
+		return "", errors.New(errorInvalidSignature)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.New(errorMalformedToken)
+	}
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return "", errors.New(errorMalformedToken)
+	}
+
+	if !v.now().Before(time.Unix(claims.Expiry, 0)) {
+		return "", errors.New(errorTokenExpired)
+	}
+
+	return claims.Subject, nil
+}
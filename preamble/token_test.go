@@ -0,0 +1,104 @@
+package preamble
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes the content provided to a file in the directory, returning its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+	return file
+}
+
+func Test_TokenList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preamble")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("Validate", func(t *testing.T) {
+		list, err := ReadTokenFile(writeFile(t, dir, "tokens", "# clients\nfirst-token\n\n  second-token  \n"))
+		assert.Nil(t, err)
+
+		subject, err := list.Validate("second-token")
+		assert.Nil(t, err)
+		assert.Equal(t, "token-2", subject)
+
+		_, err = list.Validate("second")
+		assert.Equal(t, errorUnknownToken, err.Error())
+	})
+
+	t.Run("NoTokens", func(t *testing.T) {
+		file := writeFile(t, dir, "empty", "# nobody\n")
+		_, err := ReadTokenFile(file)
+		assert.Equal(t, errorNoTokens+file, err.Error())
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		_, err := ReadTokenFile(filepath.Join(dir, "missing"))
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_HMACValidator(t *testing.T) {
+	key := []byte(strings.Repeat("k", MinHMACKeyLength))
+	now := time.Unix(1600000000, 0)
+	v := NewHMACValidator(key)
+	v.now = func() time.Time { return now }
+
+	t.Run("Valid", func(t *testing.T) {
+		token, err := Sign(key, Claims{Subject: "alice", Expiry: now.Add(time.Minute).Unix()})
+		assert.Nil(t, err)
+
+		subject, err := v.Validate(token)
+		assert.Nil(t, err)
+		assert.Equal(t, "alice", subject)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token, _ := Sign(key, Claims{Subject: "alice", Expiry: now.Unix()})
+		_, err := v.Validate(token)
+		assert.Equal(t, errorTokenExpired, err.Error())
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		token, _ := Sign([]byte(strings.Repeat("x", MinHMACKeyLength)), Claims{Subject: "alice", Expiry: now.Add(time.Minute).Unix()})
+		_, err := v.Validate(token)
+		assert.Equal(t, errorInvalidSignature, err.Error())
+	})
+
+	t.Run("AlteredClaims", func(t *testing.T) {
+		token, _ := Sign(key, Claims{Subject: "alice", Expiry: now.Add(time.Minute).Unix()})
+		other, _ := Sign(key, Claims{Subject: "mallory", Expiry: now.Add(time.Minute).Unix()})
+		_, err := v.Validate(strings.Split(other, ".")[0] + "." + strings.Split(token, ".")[1])
+		assert.Equal(t, errorInvalidSignature, err.Error())
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, token := range []string{"no-separator", "a.b.c", "payload.!!!"} {
+			_, err := v.Validate(token)
+			assert.Equal(t, errorMalformedToken, err.Error(), token)
+		}
+	})
+}
+
+func Test_ReadHMACKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preamble")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	v, err := ReadHMACKeyFile(writeFile(t, dir, "key", strings.Repeat("k", MinHMACKeyLength)+"\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, MinHMACKeyLength, len(v.key))
+
+	file := writeFile(t, dir, "short", "short\n")
+	_, err = ReadHMACKeyFile(file)
+	assert.Equal(t, errorHMACKeyTooShort+file, err.Error())
+}
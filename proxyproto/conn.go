@@ -0,0 +1,140 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// Listener wraps a net.Listener, expecting connections from trusted addresses to begin with a PROXY protocol
+	// header. Connections from other addresses are returned unaltered.
+	Listener struct {
+		net.Listener
+
+		// Trusted reports whether the address provided is that of a proxy which is permitted to send a header
+		Trusted func(addr net.Addr) bool
+
+		// Timeout limits the time taken to read the header, if non-zero
+		Timeout time.Duration
+	}
+
+	// Conn is a connection from a trusted proxy which begins with a PROXY protocol header. The header is read when
+	// ReadHeader or Read is first called; once read, RemoteAddr and LocalAddr return the addresses of the original
+	// connection described by the header.
+	Conn struct {
+		net.Conn
+
+		reader   *bufio.Reader
+		timeout  time.Duration
+		readOnce sync.Once
+
+		lock   sync.RWMutex
+		header *Header
+		err    error
+	}
+
+	closeWriter interface {
+		CloseWrite() error
+	}
+)
+
+// Accept waits for and returns the next connection, wrapping it in a Conn if it is from a trusted address. The header
+// is not read here, so that a slow proxy does not block the accepting of other connections.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Trusted == nil || !l.Trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return NewConn(conn, l.Timeout), nil
+}
+
+// NewConn wraps the connection provided, which is expected to begin with a header. Reading the header is limited to
+// the timeout provided, if non-zero.
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+// ReadHeader reads the header from the connection if it has not already been read, returning it together with any
+// error that occurred
+func (c *Conn) ReadHeader() (*Header, error) {
+	c.readOnce.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		header, err := Read(c.reader)
+
+		c.lock.Lock()
+		c.header, c.err = header, err
+		c.lock.Unlock()
+	})
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.header, c.err
+}
+
+// Read reads data following the header from the connection, reading the header first if necessary
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.ReadHeader(); err != nil {
+		return 0, err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address from the header if it has been read and describes a TCP connection,
+// otherwise the address of the proxy
+func (c *Conn) RemoteAddr() net.Addr {
+	if h := c.currentHeader(); h != nil && h.Source != nil {
+		return h.Source
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the header if it has been read and describes a TCP connection,
+// otherwise the local address of the connection from the proxy
+func (c *Conn) LocalAddr() net.Addr {
+	if h := c.currentHeader(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// ProxyAddr returns the address of the proxy which sent the connection
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// CloseWrite closes the write side of the underlying connection where this is supported, otherwise closing it
+// completely
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+
+	return c.Conn.Close()
+}
+
+// currentHeader returns the header if it has already been read, without waiting for it to be read
+func (c *Conn) currentHeader() *Header {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.header
+}
@@ -0,0 +1,252 @@
+package proxyproto
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"github.com/nextmetaphor/tcp-proxy-pool/cntr"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	// Version1 identifies the human-readable version of the PROXY protocol
+	Version1 = 1
+	// Version2 identifies the binary version of the PROXY protocol, which additionally supports TLVs
+	Version2 = 2
+
+	// TypeALPN is the TLV type holding the application protocol negotiated with the client
+	TypeALPN = 0x01
+	// TypeAuthority is the TLV type holding the server name requested by the client
+	TypeAuthority = 0x02
+	// TypeSSL is the TLV type holding details of the TLS session with the client, itself containing sub-TLVs
+	TypeSSL = 0x20
+	// SubtypeSSLVersion is the SSL sub-TLV type holding the TLS version used by the client
+	SubtypeSSLVersion = 0x21
+	// SubtypeSSLCN is the SSL sub-TLV type holding the common name of the client certificate
+	SubtypeSSLCN = 0x22
+
+	// ClientSSL is set in the SSL TLV client field when the client connected over TLS
+	ClientSSL = 0x01
+	// ClientCertConn is set in the SSL TLV client field when the client presented a certificate on this connection
+	ClientCertConn = 0x02
+
+	// VerifyFailed is the SSL TLV verify field when the client did not present a certificate which was verified; the
+	// field is zero only when it did
+	VerifyFailed = 0x01
+
+	v1Prefix     = "PROXY "
+	v1Unknown    = "UNKNOWN"
+	v1TCP4       = "TCP4"
+	v1TCP6       = "TCP6"
+	v1Terminator = "\r\n"
+
+	v2VersionCommandProxy = 0x21
+	v2FamilyUnspecified   = 0x00
+	v2FamilyTCP4          = 0x11
+	v2FamilyTCP6          = 0x21
+
+	errorUnsupportedVersion = "unsupported PROXY protocol version: "
+	errorTLVTooLong         = "PROXY protocol TLV value too long"
+)
+
+var (
+	// v2Signature is the fixed sequence of bytes which starts every version 2 header
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	tlsVersionNames = map[uint16]string{
+		tls.VersionTLS10: "TLSv1.0",
+		tls.VersionTLS11: "TLSv1.1",
+		tls.VersionTLS12: "TLSv1.2",
+		tls.VersionTLS13: "TLSv1.3",
+	}
+)
+
+type (
+	// TLV is a type-length-value extension carried by a version 2 header
+	TLV struct {
+		Type  byte
+		Value []byte
+	}
+
+	// Header represents a PROXY protocol header, describing the original connection from a client
+	Header struct {
+		Version     int
+		Source      net.Addr
+		Destination net.Addr
+		TLVs        []TLV
+	}
+
+	// tlsConn is implemented by connections over which TLS has been terminated
+	tlsConn interface {
+		ConnectionState() tls.ConnectionState
+	}
+)
+
+// ValidVersion returns an error if the version provided is not a supported PROXY protocol version
+func ValidVersion(version int) error {
+	if version != Version1 && version != Version2 {
+		return errors.New(errorUnsupportedVersion + strconv.Itoa(version))
+	}
+
+	return nil
+}
+
+// NewHeader creates a header of the version provided describing the client connection. For version 2 headers, if
+// TLS was terminated on the client connection then TLVs are added for the server name requested, the application
+// protocol negotiated and the common name of any verified client certificate.
+func NewHeader(version int, client net.Conn) *Header {
+	h := &Header{
+		Version:     version,
+		Source:      client.RemoteAddr(),
+		Destination: client.LocalAddr(),
+	}
+
+	if version != Version2 {
+		return h
+	}
+
+	tc, ok := client.(tlsConn)
+	if !ok {
+		return h
+	}
+	state := tc.ConnectionState()
+
+	if state.NegotiatedProtocol != "" {
+		h.TLVs = append(h.TLVs, TLV{Type: TypeALPN, Value: []byte(state.NegotiatedProtocol)})
+	}
+	if state.ServerName != "" {
+		h.TLVs = append(h.TLVs, TLV{Type: TypeAuthority, Value: []byte(state.ServerName)})
+	}
+
+	// the SSL TLV consists of the client flags, the 32-bit verification result and then sub-TLVs
+	ssl := []byte{ClientSSL, 0, 0, 0, VerifyFailed}
+	if len(state.PeerCertificates) > 0 {
+		ssl[0] |= ClientCertConn
+	}
+	if len(state.VerifiedChains) > 0 {
+		ssl[4] = 0
+	}
+	if name, ok := tlsVersionNames[state.Version]; ok {
+		ssl = appendTLV(ssl, TLV{Type: SubtypeSSLVersion, Value: []byte(name)})
+	}
+	if identity := cntr.IdentityOf(client); identity != nil {
+		ssl = appendTLV(ssl, TLV{Type: SubtypeSSLCN, Value: []byte(identity.CommonName)})
+	}
+	h.TLVs = append(h.TLVs, TLV{Type: TypeSSL, Value: ssl})
+
+	return h
+}
+
+// WriteTo writes the encoded header to the writer provided, returning the number of bytes written and any error
+// that occurred
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	var data []byte
+	var err error
+
+	switch h.Version {
+	case Version1:
+		data = h.encodeV1()
+	case Version2:
+		data, err = h.encodeV2()
+	default:
+		err = errors.New(errorUnsupportedVersion + strconv.Itoa(h.Version))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// tcpAddresses returns the source and destination addresses as TCP addresses, converting IPv4 addresses to IPv6 if
+// the address families differ. If either is not a TCP address then ok is false.
+func (h *Header) tcpAddresses() (src, dst *net.TCPAddr, ipv4 bool, ok bool) {
+	src, srcOK := h.Source.(*net.TCPAddr)
+	dst, dstOK := h.Destination.(*net.TCPAddr)
+	if !srcOK || !dstOK {
+		return nil, nil, false, false
+	}
+
+	ipv4 = (src.IP.To4() != nil) && (dst.IP.To4() != nil)
+	return src, dst, ipv4, true
+}
+
+func (h *Header) encodeV1() []byte {
+	src, dst, ipv4, ok := h.tcpAddresses()
+	if !ok {
+		return []byte(v1Prefix + v1Unknown + v1Terminator)
+	}
+
+	protocol := v1TCP6
+	srcIP, dstIP := src.IP.To16().String(), dst.IP.To16().String()
+	if ipv4 {
+		protocol = v1TCP4
+		srcIP, dstIP = src.IP.To4().String(), dst.IP.To4().String()
+	} else {
+		// net.IP.String would render IPv4-mapped addresses in dotted form, which is not valid for TCP6
+		srcIP, dstIP = ipv6String(src.IP), ipv6String(dst.IP)
+	}
+
+	return []byte(v1Prefix + protocol + " " + srcIP + " " + dstIP + " " +
+		strconv.Itoa(src.Port) + " " + strconv.Itoa(dst.Port) + v1Terminator)
+}
+
+func (h *Header) encodeV2() ([]byte, error) {
+	var addresses []byte
+	family := byte(v2FamilyUnspecified)
+
+	if src, dst, ipv4, ok := h.tcpAddresses(); ok {
+		if ipv4 {
+			family = v2FamilyTCP4
+			addresses = append(addresses, src.IP.To4()...)
+			addresses = append(addresses, dst.IP.To4()...)
+		} else {
+			family = v2FamilyTCP6
+			addresses = append(addresses, src.IP.To16()...)
+			addresses = append(addresses, dst.IP.To16()...)
+		}
+		addresses = appendUint16(addresses, uint16(src.Port))
+		addresses = appendUint16(addresses, uint16(dst.Port))
+	}
+
+	var tlvs []byte
+	for _, tlv := range h.TLVs {
+		if len(tlv.Value) > 0xFFFF {
+			return nil, errors.New(errorTLVTooLong)
+		}
+		tlvs = appendTLV(tlvs, tlv)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	buf.WriteByte(v2VersionCommandProxy)
+	buf.WriteByte(family)
+	binary.Write(&buf, binary.BigEndian, uint16(len(addresses)+len(tlvs)))
+	buf.Write(addresses)
+	buf.Write(tlvs)
+
+	return buf.Bytes(), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendTLV(b []byte, tlv TLV) []byte {
+	b = append(b, tlv.Type)
+	b = appendUint16(b, uint16(len(tlv.Value)))
+	return append(b, tlv.Value...)
+}
+
+// ipv6String formats the IP address provided in IPv6 notation, even if it is an IPv4 address
+func ipv6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+
+	return ip.To16().String()
+}
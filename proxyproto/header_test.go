@@ -0,0 +1,145 @@
+package proxyproto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func tcpAddr(ip string, port int) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func encode(t *testing.T, h *Header) []byte {
+	var buf bytes.Buffer
+	n, err := h.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	return buf.Bytes()
+}
+
+func Test_ValidVersion(t *testing.T) {
+	assert.Nil(t, ValidVersion(Version1))
+	assert.Nil(t, ValidVersion(Version2))
+	assert.NotNil(t, ValidVersion(0))
+	assert.NotNil(t, ValidVersion(3))
+}
+
+func Test_WriteToV1(t *testing.T) {
+	t.Run("TCP4", func(t *testing.T) {
+		h := &Header{Version: Version1, Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("198.51.100.2", 443)}
+		assert.Equal(t, "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n", string(encode(t, h)))
+	})
+
+	t.Run("TCP6", func(t *testing.T) {
+		h := &Header{Version: Version1, Source: tcpAddr("2001:db8::1", 56324), Destination: tcpAddr("2001:db8::2", 443)}
+		assert.Equal(t, "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", string(encode(t, h)))
+	})
+
+	t.Run("MixedFamilies", func(t *testing.T) {
+		h := &Header{Version: Version1, Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("2001:db8::2", 443)}
+		assert.Equal(t, "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 56324 443\r\n", string(encode(t, h)))
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		h := &Header{Version: Version1, Source: &net.UnixAddr{Name: "a"}, Destination: &net.UnixAddr{Name: "b"}}
+		assert.Equal(t, "PROXY UNKNOWN\r\n", string(encode(t, h)))
+	})
+}
+
+func Test_WriteToV2(t *testing.T) {
+	t.Run("TCP4WithTLVs", func(t *testing.T) {
+		h := &Header{
+			Version:     Version2,
+			Source:      tcpAddr("192.0.2.1", 0x1234),
+			Destination: tcpAddr("198.51.100.2", 443),
+			TLVs:        []TLV{{Type: TypeAuthority, Value: []byte("a.test")}},
+		}
+
+		expected := append([]byte{}, v2Signature...)
+		expected = append(expected, 0x21, 0x11, 0x00, 12+3+6)
+		expected = append(expected, 192, 0, 2, 1, 198, 51, 100, 2, 0x12, 0x34, 0x01, 0xBB)
+		expected = append(expected, TypeAuthority, 0x00, 0x06)
+		expected = append(expected, "a.test"...)
+		assert.Equal(t, expected, encode(t, h))
+	})
+
+	t.Run("TCP6", func(t *testing.T) {
+		h := &Header{Version: Version2, Source: tcpAddr("2001:db8::1", 1), Destination: tcpAddr("2001:db8::2", 2)}
+
+		data := encode(t, h)
+		assert.Equal(t, byte(0x21), data[13])
+		assert.Equal(t, []byte{0x00, 36}, data[14:16])
+		assert.Equal(t, 16+36, len(data))
+	})
+
+	t.Run("Unspecified", func(t *testing.T) {
+		h := &Header{Version: Version2, Source: &net.UnixAddr{Name: "a"}, Destination: &net.UnixAddr{Name: "b"}}
+		assert.Equal(t, append(append([]byte{}, v2Signature...), 0x21, 0x00, 0x00, 0x00), encode(t, h))
+	})
+
+	t.Run("TLVTooLong", func(t *testing.T) {
+		h := &Header{Version: Version2, TLVs: []TLV{{Type: TypeALPN, Value: make([]byte, 0x10000)}}}
+		_, err := h.WriteTo(&bytes.Buffer{})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("UnsupportedVersion", func(t *testing.T) {
+		_, err := (&Header{Version: 3}).WriteTo(&bytes.Buffer{})
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_NewHeader(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "server.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"server.test"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	t.Run("PlainConnection", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+
+		assert.Nil(t, NewHeader(Version2, a).TLVs)
+	})
+
+	t.Run("TLSConnection", func(t *testing.T) {
+		a, b := net.Pipe()
+		server := tls.Server(a, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}})
+		client := tls.Client(b, &tls.Config{InsecureSkipVerify: true, ServerName: "server.test", NextProtos: []string{"h2"}})
+		defer server.Close()
+		defer client.Close()
+
+		go client.Handshake()
+		assert.Nil(t, server.Handshake())
+
+		h := NewHeader(Version2, server)
+		assert.Equal(t, 3, len(h.TLVs))
+		assert.Equal(t, TLV{Type: TypeALPN, Value: []byte("h2")}, h.TLVs[0])
+		assert.Equal(t, TLV{Type: TypeAuthority, Value: []byte("server.test")}, h.TLVs[1])
+		assert.Equal(t, byte(TypeSSL), h.TLVs[2].Type)
+		assert.Equal(t, byte(ClientSSL), h.TLVs[2].Value[0])
+		// no client certificate was presented, so the verify field must not indicate success
+		assert.Equal(t, []byte{0, 0, 0, VerifyFailed}, h.TLVs[2].Value[1:5])
+
+		assert.Nil(t, NewHeader(Version1, server).TLVs)
+	})
+}
@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// v1MaxLength is the maximum length of a version 1 header, including the terminating CRLF
+	v1MaxLength = 107
+
+	v2HeaderLength      = 16
+	v2Version           = 0x20
+	v2CommandLocal      = 0x00
+	v2CommandProxy      = 0x01
+	v2AddressLengthTCP4 = 12
+	v2AddressLengthTCP6 = 36
+	v2TLVHeaderLength   = 3
+
+	errorNoHeader          = "PROXY protocol header not found"
+	errorInvalidV1Header   = "invalid PROXY protocol v1 header"
+	errorInvalidV2Header   = "invalid PROXY protocol v2 header"
+	errorUnsupportedFamily = "unsupported PROXY protocol v2 address family"
+)
+
+// Read reads a version 1 or version 2 header from the reader provided, which must be positioned at the start of the
+// stream. For headers which do not describe a TCP connection, such as the v1 UNKNOWN protocol or the v2 LOCAL
+// command, the Source and Destination of the returned header are nil.
+func Read(r *bufio.Reader) (*Header, error) {
+	signature, err := r.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(signature, v2Signature) {
+		return readV2(r)
+	}
+
+	prefix, err := r.Peek(len(v1Prefix))
+	if err == nil && string(prefix) == v1Prefix {
+		return readV1(r)
+	}
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return nil, errors.New(errorNoHeader)
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte(v1Terminator)) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte(v1Terminator)) {
+		return nil, errors.New(errorInvalidV1Header)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), v1Terminator), " ")
+	h := &Header{Version: Version1}
+
+	if len(fields) >= 2 && fields[1] == v1Unknown {
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != v1TCP4 && fields[1] != v1TCP6) {
+		return nil, errors.New(errorInvalidV1Header)
+	}
+
+	src, srcErr := parseV1Address(fields[1], fields[2], fields[4])
+	dst, dstErr := parseV1Address(fields[1], fields[3], fields[5])
+	if srcErr != nil || dstErr != nil {
+		return nil, errors.New(errorInvalidV1Header)
+	}
+	h.Source, h.Destination = src, dst
+
+	return h, nil
+}
+
+func parseV1Address(protocol, ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || ((protocol == v1TCP4) != (addr.To4() != nil && !strings.Contains(ip, ":"))) {
+		return nil, errors.New(errorInvalidV1Header)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 0xFFFF {
+		return nil, errors.New(errorInvalidV1Header)
+	}
+
+	return &net.TCPAddr{IP: addr, Port: p}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	versionCommand, family := fixed[12], fixed[13]
+	if versionCommand&0xF0 != v2Version {
+		return nil, errors.New(errorInvalidV2Header)
+	}
+	command := versionCommand & 0x0F
+	if command != v2CommandLocal && command != v2CommandProxy {
+		return nil, errors.New(errorInvalidV2Header)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: Version2}
+
+	addressLength := 0
+	switch family {
+	case v2FamilyUnspecified:
+	case v2FamilyTCP4:
+		addressLength = v2AddressLengthTCP4
+	case v2FamilyTCP6:
+		addressLength = v2AddressLengthTCP6
+	default:
+		// other families, such as UDP or UNIX sockets, are skipped over as they cannot describe a TCP client
+		if command == v2CommandProxy {
+			return nil, errors.New(errorUnsupportedFamily)
+		}
+	}
+	if len(payload) < addressLength {
+		return nil, errors.New(errorInvalidV2Header)
+	}
+
+	if command == v2CommandProxy && addressLength > 0 {
+		ipLength := (addressLength - 4) / 2
+		ports := payload[2*ipLength:]
+		h.Source = &net.TCPAddr{
+			IP:   net.IP(append([]byte{}, payload[:ipLength]...)),
+			Port: int(binary.BigEndian.Uint16(ports[0:2])),
+		}
+		h.Destination = &net.TCPAddr{
+			IP:   net.IP(append([]byte{}, payload[ipLength:2*ipLength]...)),
+			Port: int(binary.BigEndian.Uint16(ports[2:4])),
+		}
+	}
+
+	tlvs := payload[addressLength:]
+	if addressLength == 0 {
+		// without a known address family the extent of the addresses is unknown, so TLVs cannot be located
+		return h, nil
+	}
+	for len(tlvs) > 0 {
+		if len(tlvs) < v2TLVHeaderLength {
+			return nil, errors.New(errorInvalidV2Header)
+		}
+		length := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < v2TLVHeaderLength+length {
+			return nil, errors.New(errorInvalidV2Header)
+		}
+		h.TLVs = append(h.TLVs, TLV{
+			Type:  tlvs[0],
+			Value: append([]byte{}, tlvs[v2TLVHeaderLength:v2TLVHeaderLength+length]...),
+		})
+		tlvs = tlvs[v2TLVHeaderLength+length:]
+	}
+
+	return h, nil
+}
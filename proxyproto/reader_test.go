@@ -0,0 +1,93 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Read(t *testing.T) {
+	roundTrip := func(h *Header) *Header {
+		var buf bytes.Buffer
+		_, err := h.WriteTo(&buf)
+		assert.Nil(t, err)
+		buf.WriteString("data")
+
+		r := bufio.NewReader(&buf)
+		read, err := Read(r)
+		assert.Nil(t, err)
+
+		// everything following the header should remain to be read
+		rest, _ := ioutil.ReadAll(r)
+		assert.Equal(t, "data", string(rest))
+
+		return read
+	}
+
+	for _, version := range []int{Version1, Version2} {
+		h := roundTrip(&Header{Version: version, Source: tcpAddr("192.0.2.1", 1000), Destination: tcpAddr("198.51.100.2", 443)})
+		assert.Equal(t, "192.0.2.1:1000", h.Source.String())
+		assert.Equal(t, "198.51.100.2:443", h.Destination.String())
+
+		h = roundTrip(&Header{Version: version, Source: tcpAddr("2001:db8::1", 1000), Destination: tcpAddr("2001:db8::2", 443)})
+		assert.Equal(t, "[2001:db8::1]:1000", h.Source.String())
+
+		h = roundTrip(&Header{Version: version, Source: &net.UnixAddr{}, Destination: &net.UnixAddr{}})
+		assert.Nil(t, h.Source)
+	}
+
+	t.Run("TLVs", func(t *testing.T) {
+		tlvs := []TLV{{Type: TypeALPN, Value: []byte("h2")}, {Type: TypeAuthority, Value: []byte("a.test")}}
+		h := roundTrip(&Header{Version: Version2, Source: tcpAddr("192.0.2.1", 1), Destination: tcpAddr("192.0.2.2", 2), TLVs: tlvs})
+		assert.Equal(t, tlvs, h.TLVs)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, data := range []string{
+			"",
+			"GET / HTTP/1.1\r\n",
+			"PROXY TCP4 192.0.2.1 192.0.2.2 1\r\n",
+			"PROXY TCP4 2001:db8::1 192.0.2.2 1 2\r\n",
+			"PROXY TCP4 192.0.2.1 192.0.2.2 1 70000\r\n",
+			"PROXY TCP4 " + strings.Repeat("1", 200),
+			string(v2Signature) + "\x11\x11\x00\x00",
+			string(v2Signature) + "\x21\x11\x00\x04\x00\x00\x00\x00",
+		} {
+			_, err := Read(bufio.NewReader(strings.NewReader(data)))
+			assert.NotNil(t, err, data)
+		}
+	})
+}
+
+func Test_Conn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	conn := NewConn(a, time.Second)
+	assert.Equal(t, "pipe", conn.RemoteAddr().String())
+
+	go b.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.2 1000 443\r\nhello"))
+
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, "192.0.2.1:1000", conn.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.2:443", conn.LocalAddr().String())
+	assert.Equal(t, "pipe", conn.ProxyAddr().String())
+
+	t.Run("Timeout", func(t *testing.T) {
+		c, d := net.Pipe()
+		defer c.Close()
+		defer d.Close()
+
+		_, err := NewConn(c, 10*time.Millisecond).ReadHeader()
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Bucket is a token bucket which may be shared between goroutines. Tokens are added at a constant rate up to the
+	// size of the burst; taking more tokens than are available puts the bucket into debt, which must be repaid by
+	// waiting before the tokens are used.
+	Bucket struct {
+		lock   sync.Mutex
+		rate   float64
+		burst  float64
+		tokens float64
+		last   time.Time
+
+		// now returns the current time; it is replaced in tests
+		now func() time.Time
+	}
+)
+
+// NewBucket creates a full bucket which adds rate tokens per second, holding at most burst tokens. If burst is not
+// positive then it is set to rate, allowing one second's worth of tokens to be taken at once. A nil bucket is
+// returned if rate is not positive, which imposes no limit.
+func NewBucket(rate, burst int64) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+
+	b := &Bucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	b.last = b.now()
+
+	return b
+}
+
+// Burst returns the maximum number of tokens that the bucket holds, or zero for a nil bucket
+func (b *Bucket) Burst() int64 {
+	if b == nil {
+		return 0
+	}
+
+	return int64(b.burst)
+}
+
+// Take removes n tokens from the bucket, returning the time that the caller must wait before using them. A nil bucket
+// always returns zero.
+func (b *Bucket) Take(n int64) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// TryTake removes n tokens from the bucket only if they are all available, returning whether they were. A nil bucket
+// always returns true.
+func (b *Bucket) TryTake(n int64) bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+
+	return true
+}
+
+// Full returns true if the bucket holds its maximum number of tokens, in which case it is indistinguishable from a
+// newly-created bucket. A nil bucket is always full.
+func (b *Bucket) Full() bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	return b.tokens >= b.burst
+}
+
+// refill adds the tokens accumulated since the bucket was last used; the lock must be held
+func (b *Bucket) refill() {
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Wait takes n tokens from each of the buckets provided, which may be nil, and sleeps until they can all be used or
+// done is closed, whichever is first. The time spent waiting is returned, together with false should done have been
+// closed first; a nil done channel is never closed.
+func Wait(done <-chan struct{}, n int64, buckets ...*Bucket) (time.Duration, bool) {
+	var wait time.Duration
+	for _, b := range buckets {
+		if d := b.Take(n); d > wait {
+			wait = d
+		}
+	}
+
+	if wait <= 0 {
+		return 0, true
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return wait, true
+	case <-done:
+		return time.Since(start), false
+	}
+}
+
+// ChunkSize returns the largest number of tokens, no greater than max, that can be taken from every one of the
+// buckets provided without exceeding its burst
+func ChunkSize(max int64, buckets ...*Bucket) int64 {
+	for _, b := range buckets {
+		if burst := b.Burst(); burst > 0 && burst < max {
+			max = burst
+		}
+	}
+
+	return max
+}
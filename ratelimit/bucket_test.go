@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// newTestBucket creates a bucket whose clock is advanced manually
+func newTestBucket(rate, burst int64) (*Bucket, *time.Time) {
+	now := time.Now()
+	b := NewBucket(rate, burst)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	return b, &now
+}
+
+func Test_NewBucket(t *testing.T) {
+	assert.Nil(t, NewBucket(0, 100))
+	assert.Equal(t, int64(100), NewBucket(100, 0).Burst())
+	assert.Equal(t, int64(10), NewBucket(100, 10).Burst())
+}
+
+func Test_Take(t *testing.T) {
+	b, now := newTestBucket(1000, 500)
+
+	// the bucket starts full
+	assert.Equal(t, time.Duration(0), b.Take(500))
+
+	// once empty, tokens must be waited for
+	assert.Equal(t, 100*time.Millisecond, b.Take(100))
+
+	// the debt is repaid over time
+	*now = now.Add(200 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), b.Take(100))
+
+	// tokens never accumulate beyond the burst
+	*now = now.Add(time.Hour)
+	assert.Equal(t, time.Duration(0), b.Take(500))
+	assert.Equal(t, time.Millisecond, b.Take(1))
+}
+
+func Test_TryTake(t *testing.T) {
+	b, now := newTestBucket(10, 2)
+
+	assert.True(t, b.TryTake(1))
+	assert.True(t, b.TryTake(1))
+	assert.False(t, b.TryTake(1))
+	assert.False(t, b.Full())
+
+	// a failed attempt does not put the bucket into debt
+	*now = now.Add(100 * time.Millisecond)
+	assert.True(t, b.TryTake(1))
+
+	*now = now.Add(time.Second)
+	assert.True(t, b.Full())
+}
+
+func Test_NilBucket(t *testing.T) {
+	var b *Bucket
+	assert.Equal(t, time.Duration(0), b.Take(1000))
+	assert.Equal(t, int64(0), b.Burst())
+	wait, ok := Wait(nil, 1000, nil, b)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.True(t, ok)
+	assert.True(t, b.TryTake(1000))
+	assert.True(t, b.Full())
+}
+
+func Test_Wait(t *testing.T) {
+	t.Run("Throttled", func(t *testing.T) {
+		b := NewBucket(1000, 100)
+		b.Take(100)
+
+		wait, ok := Wait(nil, 50, b)
+		assert.True(t, ok)
+		assert.Equal(t, 50*time.Millisecond, wait.Round(time.Millisecond))
+	})
+
+	t.Run("Done", func(t *testing.T) {
+		b := NewBucket(1, 1)
+		b.Take(1)
+
+		// closing done ends the wait rather than sleeping for the hour that the tokens would take
+		done := make(chan struct{})
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(done)
+		}()
+
+		started := time.Now()
+		_, ok := Wait(done, 3600, b)
+		assert.False(t, ok)
+		assert.True(t, time.Since(started) < 5*time.Second)
+	})
+}
+
+func Test_ChunkSize(t *testing.T) {
+	assert.Equal(t, int64(1024), ChunkSize(1024))
+	assert.Equal(t, int64(1024), ChunkSize(1024, nil, NewBucket(100, 2048)))
+	assert.Equal(t, int64(100), ChunkSize(1024, NewBucket(100, 0), NewBucket(1000, 0)))
+}